@@ -0,0 +1,364 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"execute_command/utils"
+)
+
+// GenesisHash is the previous hash of the first record in a log
+var GenesisHash = strings.Repeat("0", 64)
+
+// Record is a single audit log entry. Every record carries the hash of the
+// previous record, so deleting or modifying an entry breaks the chain
+type Record struct {
+	Sequence       int64     `json:"seq"`
+	Time           time.Time `json:"time"`
+	User           string    `json:"user"`
+	Host           string    `json:"host"`
+	WorkDir        string    `json:"work_dir"`
+	Action         string    `json:"action"`
+	Executor       string    `json:"executor"`
+	Shell          string    `json:"shell"`
+	Command        string    `json:"command"`
+	DecodedCommand string    `json:"decoded_command,omitempty"`
+	Status         string    `json:"status"`
+	ExitCode       int       `json:"exit_code"`
+	DurationMs     int64     `json:"duration_ms"`
+	Attempts       int       `json:"attempts"`
+	StdoutSHA256   string    `json:"stdout_sha256,omitempty"`
+	StderrSHA256   string    `json:"stderr_sha256,omitempty"`
+	LimitExceeded  string    `json:"limit_exceeded,omitempty"`
+	RunAsUser      string    `json:"run_as_user,omitempty"`   // User the command ran as
+	RunAsUID       *int      `json:"run_as_uid,omitempty"`    // Effective uid of the command
+	RunAsGID       *int      `json:"run_as_gid,omitempty"`    // Effective gid of the command
+	Caller         string    `json:"caller,omitempty"`        // User who asked the server to run the command
+	CallerUID      *int      `json:"caller_uid,omitempty"`    // Peer credentials of the caller
+	CallerGID      *int      `json:"caller_gid,omitempty"`    // Group id of the caller
+	CallerPID      int       `json:"caller_pid,omitempty"`    // Process of the caller
+	Signer         string    `json:"signer,omitempty"`        // Trusted key that signed the command's bundle
+	SignerKeyID    string    `json:"signer_key_id,omitempty"` // ID of the key the bundle claims to be signed with
+	BundleNonce    string    `json:"bundle_nonce,omitempty"`  // Nonce of the signed bundle
+	Error          string    `json:"error,omitempty"`
+	Redactions     []string  `json:"redactions,omitempty"` // Rules that masked secrets in this record
+	PrevHash       string    `json:"prev_hash"`
+	Hash           string    `json:"hash"`
+}
+
+// NewRecord creates a record for an action, filled with the current time,
+// user, host and working directory
+func NewRecord(action string) *Record {
+	record := &Record{
+		Time:   time.Now().UTC(),
+		Action: action,
+	}
+	if current, err := user.Current(); err == nil {
+		record.User = current.Username
+	}
+	record.Host, _ = os.Hostname()
+	record.WorkDir, _ = os.Getwd()
+	return record
+}
+
+// computeHash returns the SHA-256 of the record's JSON encoding without its hash
+func (r *Record) computeHash() (string, error) {
+	unhashed := *r
+	unhashed.Hash = ""
+	data, err := json.Marshal(&unhashed)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// HashOutput returns the hex SHA-256 of captured output, or "" if there was none
+func HashOutput(output string) string {
+	if output == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(output))
+	return hex.EncodeToString(sum[:])
+}
+
+// head is the position of the last record, stored next to the log so that
+// truncating the end of the log can also be detected
+type head struct {
+	Sequence int64  `json:"seq"`
+	Hash     string `json:"hash"`
+}
+
+// Logger appends records to an audit log file (JSON lines)
+type Logger struct {
+	path   string
+	mu     sync.Mutex
+	logger *utils.ModuleLogger
+}
+
+// NewLogger creates a new audit logger writing to path
+func NewLogger(path string) *Logger {
+	return &Logger{
+		path:   path,
+		logger: utils.GetModuleLogger("audit"),
+	}
+}
+
+// DefaultPath returns the default audit log location in the state directory
+func DefaultPath() (string, error) {
+	dir, err := utils.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "audit.jsonl"), nil
+}
+
+// Path returns the path of the audit log
+func (l *Logger) Path() string {
+	return l.path
+}
+
+// Append links a record to the end of the chain and writes it to the log
+func (l *Logger) Append(record *Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %v", err)
+	}
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	// Serialize writers from other processes while the chain is extended
+	if err := utils.LockFile(file); err != nil {
+		return fmt.Errorf("failed to lock audit log: %v", err)
+	}
+	defer utils.UnlockFile(file)
+
+	last, err := l.readHead()
+	if err != nil {
+		return err
+	}
+	// A record written by an append whose head update failed is ahead of
+	// the head; continue after it rather than reusing its sequence. A log
+	// behind the head was truncated, which is left for Verify to report
+	tail, err := lastRecord(file)
+	if err != nil {
+		return err
+	}
+	if tail != nil && tail.Sequence > last.Sequence {
+		l.logger.Warn("Audit head file at record %d is behind the log, recovered from last record %d", last.Sequence, tail.Sequence)
+		last = head{Sequence: tail.Sequence, Hash: tail.Hash}
+	}
+
+	record.Sequence = last.Sequence + 1
+	record.PrevHash = last.Hash
+	if record.Hash, err = record.computeHash(); err != nil {
+		return fmt.Errorf("failed to hash audit record: %v", err)
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %v", err)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit record: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %v", err)
+	}
+
+	l.logger.Debug("Appended audit record %d to %s", record.Sequence, l.path)
+	return l.writeHead(head{Sequence: record.Sequence, Hash: record.Hash})
+}
+
+// headPath returns the path of the head file
+func (l *Logger) headPath() string {
+	return l.path + ".head"
+}
+
+// readHead returns the position of the last record. If the head file is
+// missing, it is recovered from the last record in the log
+func (l *Logger) readHead() (head, error) {
+	data, err := os.ReadFile(l.headPath())
+	if err == nil {
+		var h head
+		if err := json.Unmarshal(data, &h); err != nil {
+			return head{}, fmt.Errorf("corrupt audit head file %s: %v", l.headPath(), err)
+		}
+		return h, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return head{}, fmt.Errorf("failed to read audit head file: %v", err)
+	}
+
+	h := head{Hash: GenesisHash}
+	err = readRecords(l.path, func(_ int, record *Record, parseErr error) {
+		if parseErr == nil {
+			h = head{Sequence: record.Sequence, Hash: record.Hash}
+		}
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return head{}, err
+	}
+	if h.Sequence > 0 {
+		l.logger.Warn("Audit head file missing, recovered from last record %d", h.Sequence)
+	}
+	return h, nil
+}
+
+// lastRecord returns the last record of the log, reading it from the end,
+// or nil if the log is empty or its last line is unreadable (which Verify
+// reports)
+func lastRecord(file *os.File) (*Record, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	const chunkSize = 64 * 1024
+	var tail []byte
+	for offset := info.Size(); offset > 0; {
+		n := int64(chunkSize)
+		if n > offset {
+			n = offset
+		}
+		offset -= n
+		chunk := make([]byte, n)
+		if _, err := file.ReadAt(chunk, offset); err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %v", err)
+		}
+		tail = append(chunk, tail...)
+
+		trimmed := bytes.TrimRight(tail, " \t\r\n")
+		start := bytes.LastIndexByte(trimmed, '\n')
+		if start < 0 && offset > 0 || len(trimmed) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(trimmed[start+1:], &record); err != nil {
+			return nil, nil
+		}
+		return &record, nil
+	}
+	return nil, nil
+}
+
+// writeHead atomically replaces the head file
+func (l *Logger) writeHead(h head) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	tmp := l.headPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write audit head file: %v", err)
+	}
+	return os.Rename(tmp, l.headPath())
+}
+
+// VerifyReport is the result of verifying an audit log
+type VerifyReport struct {
+	Records  int
+	Problems []string
+}
+
+// OK reports whether the log verified without problems
+func (vr *VerifyReport) OK() bool {
+	return len(vr.Problems) == 0
+}
+
+// Verify checks the hash chain of an audit log, reporting modified, deleted,
+// reordered or truncated entries
+func Verify(path string) (*VerifyReport, error) {
+	report := &VerifyReport{}
+	prevHash := GenesisHash
+	var prevSequence int64
+
+	err := readRecords(path, func(lineNumber int, record *Record, parseErr error) {
+		report.Records++
+		if parseErr != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("line %d: unreadable record: %v", lineNumber, parseErr))
+			return
+		}
+
+		if record.Sequence != prevSequence+1 {
+			report.Problems = append(report.Problems, fmt.Sprintf("line %d: sequence %d follows %d (entries deleted or reordered)",
+				lineNumber, record.Sequence, prevSequence))
+		}
+		if record.PrevHash != prevHash {
+			report.Problems = append(report.Problems, fmt.Sprintf("line %d: previous hash does not match record %d (chain broken)",
+				lineNumber, prevSequence))
+		}
+		if hash, err := record.computeHash(); err != nil || hash != record.Hash {
+			report.Problems = append(report.Problems, fmt.Sprintf("line %d: record %d hash mismatch (entry modified)",
+				lineNumber, record.Sequence))
+		}
+
+		prevSequence = record.Sequence
+		prevHash = record.Hash
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare the end of the chain with the head file to detect truncation
+	data, err := os.ReadFile(path + ".head")
+	switch {
+	case err == nil:
+		var h head
+		if err := json.Unmarshal(data, &h); err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("head file unreadable: %v", err))
+		} else if h.Sequence != prevSequence || h.Hash != prevHash {
+			report.Problems = append(report.Problems, fmt.Sprintf("head file points to record %d but log ends at record %d (entries deleted at end)",
+				h.Sequence, prevSequence))
+		}
+	case errors.Is(err, os.ErrNotExist):
+		if report.Records > 0 {
+			report.Problems = append(report.Problems, "head file missing (truncation at end cannot be checked)")
+		}
+	default:
+		return nil, fmt.Errorf("failed to read audit head file: %v", err)
+	}
+
+	return report, nil
+}
+
+// readRecords calls fn for every non-empty line of the log
+func readRecords(path string, fn func(lineNumber int, record *Record, parseErr error)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			var record Record
+			parseErr := json.Unmarshal(line, &record)
+			fn(lineNumber, &record, parseErr)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read audit log: %v", err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeLog appends records for the commands to a new log and returns its path
+func writeLog(t *testing.T, commands ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	logger := NewLogger(path)
+	for _, command := range commands {
+		record := NewRecord("execute")
+		record.Command = command
+		record.Status = "success"
+		if err := logger.Append(record); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	return path
+}
+
+// editLines rewrites the log's lines with fn
+func editLines(t *testing.T, path string, fn func([]string) []string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	lines = fn(lines)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// verifyProblems verifies the log and returns its problems
+func verifyProblems(t *testing.T, path string) []string {
+	t.Helper()
+	report, err := Verify(path)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	return report.Problems
+}
+
+// requireProblem fails unless a problem contains the text
+func requireProblem(t *testing.T, problems []string, text string) {
+	t.Helper()
+	for _, problem := range problems {
+		if strings.Contains(problem, text) {
+			return
+		}
+	}
+	t.Fatalf("no problem containing %q in %q", text, problems)
+}
+
+func TestVerifyIntactLog(t *testing.T) {
+	path := writeLog(t, "ls", "uptime", "whoami")
+	report, err := Verify(path)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !report.OK() || report.Records != 3 {
+		t.Fatalf("got %d records, problems %q; want 3 records and no problems", report.Records, report.Problems)
+	}
+}
+
+func TestVerifyDetectsModifiedRecord(t *testing.T) {
+	path := writeLog(t, "ls", "uptime", "whoami")
+	editLines(t, path, func(lines []string) []string {
+		lines[1] = strings.Replace(lines[1], `"command":"uptime"`, `"command":"rm -rf /"`, 1)
+		return lines
+	})
+	requireProblem(t, verifyProblems(t, path), "record 2 hash mismatch")
+}
+
+func TestVerifyDetectsRehashedRecord(t *testing.T) {
+	// Recomputing the modified record's own hash still breaks the next link
+	path := writeLog(t, "ls", "uptime", "whoami")
+	editLines(t, path, func(lines []string) []string {
+		var records []*Record
+		readRecords(path, func(_ int, record *Record, _ error) {
+			records = append(records, record)
+		})
+		records[1].Command = "rm -rf /"
+		records[1].Hash, _ = records[1].computeHash()
+		line, err := json.Marshal(records[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines[1] = string(line)
+		return lines
+	})
+	requireProblem(t, verifyProblems(t, path), "previous hash does not match record 2")
+}
+
+func TestVerifyDetectsDeletedRecord(t *testing.T) {
+	path := writeLog(t, "ls", "uptime", "whoami")
+	editLines(t, path, func(lines []string) []string {
+		return append(lines[:1], lines[2:]...)
+	})
+	problems := verifyProblems(t, path)
+	requireProblem(t, problems, "sequence 3 follows 1")
+	requireProblem(t, problems, "chain broken")
+}
+
+func TestVerifyDetectsTruncatedLog(t *testing.T) {
+	path := writeLog(t, "ls", "uptime", "whoami")
+	editLines(t, path, func(lines []string) []string {
+		return lines[:2]
+	})
+	requireProblem(t, verifyProblems(t, path), "head file points to record 3 but log ends at record 2")
+}
+
+func TestVerifyDetectsUnreadableLine(t *testing.T) {
+	path := writeLog(t, "ls", "uptime")
+	editLines(t, path, func(lines []string) []string {
+		lines[0] = lines[0][:len(lines[0])/2]
+		return lines
+	})
+	requireProblem(t, verifyProblems(t, path), "line 1: unreadable record")
+}
+
+func TestAppendRecoversMissingHead(t *testing.T) {
+	path := writeLog(t, "ls", "uptime")
+	if err := os.Remove(path + ".head"); err != nil {
+		t.Fatal(err)
+	}
+	record := NewRecord("execute")
+	record.Command = "whoami"
+	if err := NewLogger(path).Append(record); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if record.Sequence != 3 {
+		t.Fatalf("sequence = %d, want 3", record.Sequence)
+	}
+	if problems := verifyProblems(t, path); len(problems) > 0 {
+		t.Fatalf("problems after recovery: %q", problems)
+	}
+}
+
+func TestAppendAfterFailedHeadWrite(t *testing.T) {
+	path := writeLog(t, "ls")
+	// A directory in the way of the head's temporary file fails the update
+	if err := os.Mkdir(path+".head.tmp", 0700); err != nil {
+		t.Fatal(err)
+	}
+	record := NewRecord("execute")
+	record.Command = "uptime"
+	if err := NewLogger(path).Append(record); err == nil {
+		t.Fatal("Append succeeded without writing the head")
+	}
+	if err := os.Remove(path + ".head.tmp"); err != nil {
+		t.Fatal(err)
+	}
+
+	record = NewRecord("execute")
+	record.Command = "whoami"
+	if err := NewLogger(path).Append(record); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if record.Sequence != 3 {
+		t.Fatalf("sequence = %d, want 3", record.Sequence)
+	}
+	if problems := verifyProblems(t, path); len(problems) > 0 {
+		t.Fatalf("problems after a failed head write: %q", problems)
+	}
+}
+
+func TestLastRecordReadsAcrossChunks(t *testing.T) {
+	path := writeLog(t, "ls", strings.Repeat("x", 200*1024))
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	record, err := lastRecord(file)
+	if err != nil {
+		t.Fatalf("lastRecord: %v", err)
+	}
+	if record == nil || record.Sequence != 2 || len(record.Command) != 200*1024 {
+		t.Fatalf("lastRecord = %v, want record 2", record)
+	}
+}
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"execute_command/server"
+	"execute_command/utils"
+)
+
+// APIError is an error response from the server
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+// Error returns the error message sent by the server
+func (e *APIError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Client submits commands to a server started with the serve action
+type Client struct {
+	baseURL     string
+	token       string
+	traceparent string
+	http        *http.Client
+	logger      *utils.ModuleLogger
+}
+
+// TLSFiles configures HTTPS connections to a server
+type TLSFiles struct {
+	CAFile   string // CA that signed the server certificate (default: the system roots)
+	CertFile string // Client certificate for servers that verify clients
+	KeyFile  string // Key of the client certificate
+}
+
+// IsSet checks if any TLS file is configured
+func (tf TLSFiles) IsSet() bool {
+	return tf.CAFile != "" || tf.CertFile != "" || tf.KeyFile != ""
+}
+
+// config builds the TLS configuration for the files
+func (tf TLSFiles) config() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if tf.CAFile != "" {
+		pem, err := os.ReadFile(tf.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA %s", tf.CAFile)
+		}
+	}
+	if tf.CertFile != "" || tf.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(tf.CertFile, tf.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// New creates a client for an address: unix:///path or unix:/path for a
+// Unix socket, http(s)://host:port or host:port for TCP. The token may be
+// empty when the server identifies callers by their Unix socket credentials
+// or client certificate
+func New(address, token string, tlsFiles TLSFiles) (*Client, error) {
+	c := &Client{
+		token:  token,
+		logger: utils.GetModuleLogger("client"),
+	}
+
+	switch {
+	case strings.HasPrefix(address, "unix:"):
+		path := server.SocketPath(address)
+		if path == "" {
+			return nil, fmt.Errorf("invalid remote address %q: missing socket path", address)
+		}
+		var dialer net.Dialer
+		c.baseURL = "http://unix"
+		c.http = &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		}}
+	case strings.HasPrefix(address, "https://"):
+		tlsConfig, err := tlsFiles.config()
+		if err != nil {
+			return nil, err
+		}
+		c.baseURL = strings.TrimSuffix(address, "/")
+		c.http = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	case strings.HasPrefix(address, "http://"):
+		c.baseURL = strings.TrimSuffix(address, "/")
+		c.http = &http.Client{}
+	default:
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, fmt.Errorf("invalid remote address %q (use unix:///path, http://host:port or host:port)", address)
+		}
+		c.baseURL = "http://" + address
+		c.http = &http.Client{}
+	}
+	if tlsFiles.IsSet() && !strings.HasPrefix(address, "https://") {
+		return nil, fmt.Errorf("TLS options need an https:// remote address")
+	}
+	return c, nil
+}
+
+// SetTraceparent sends a W3C trace context with every request, so that the
+// server's spans join the caller's trace ("" sends none)
+func (c *Client) SetTraceparent(traceparent string) {
+	c.traceparent = traceparent
+}
+
+// ResolveToken returns the bearer token for a server: from the environment,
+// the token file, or the api.token generated by a server in the state
+// directory. It returns "" if there is none
+func ResolveToken(tokenFile string) (string, error) {
+	if token := os.Getenv(server.TokenEnv); token != "" {
+		return token, nil
+	}
+
+	optional := false
+	if tokenFile == "" {
+		dir, err := utils.StateDir()
+		if err != nil {
+			return "", nil
+		}
+		tokenFile = filepath.Join(dir, "api.token")
+		optional = true
+	}
+	data, err := os.ReadFile(tokenFile)
+	if errors.Is(err, os.ErrNotExist) && optional {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Submit asks the server to run a command
+func (c *Client) Submit(ctx context.Context, request server.Request) (*server.Execution, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %v", err)
+	}
+	execution := &server.Execution{}
+	if err := c.do(ctx, http.MethodPost, "/v1/executions", bytes.NewReader(body), execution); err != nil {
+		return nil, err
+	}
+	c.logger.Debug("Submitted execution %s", execution.ID)
+	return execution, nil
+}
+
+// Cancel asks the server to cancel a running execution
+func (c *Client) Cancel(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/executions/"+id, nil, nil)
+}
+
+// Stream copies the output of an execution to stdout and stderr as the
+// server sends it and returns the execution once it has finished
+func (c *Client) Stream(ctx context.Context, id string, stdout, stderr io.Writer) (*server.Execution, error) {
+	response, err := c.send(ctx, http.MethodGet, "/v1/executions/"+id+"/events", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var event, data string
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			switch event {
+			case "stdout", "stderr":
+				var chunk server.Chunk
+				if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+					return nil, fmt.Errorf("invalid output event: %v", err)
+				}
+				if event == "stdout" {
+					io.WriteString(stdout, chunk.Data)
+				} else {
+					io.WriteString(stderr, chunk.Data)
+				}
+			case "done":
+				execution := &server.Execution{}
+				if err := json.Unmarshal([]byte(data), execution); err != nil {
+					return nil, fmt.Errorf("invalid done event: %v", err)
+				}
+				return execution, nil
+			}
+			event, data = "", ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read events: %v", err)
+	}
+	return nil, fmt.Errorf("event stream ended before execution %s finished", id)
+}
+
+// do sends a request and decodes the JSON response into result (if not nil)
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader, result interface{}) error {
+	response, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return fmt.Errorf("invalid response: %v", err)
+	}
+	return nil
+}
+
+// send sends a request and turns error responses into an APIError
+func (c *Client) send(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.traceparent != "" {
+		request.Header.Set("traceparent", c.traceparent)
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach server: %v", err)
+	}
+	if response.StatusCode >= 300 {
+		defer response.Body.Close()
+		var message struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(response.Body).Decode(&message) != nil || message.Error == "" {
+			message.Error = http.StatusText(response.StatusCode)
+		}
+		return nil, &APIError{StatusCode: response.StatusCode, Message: message.Error}
+	}
+	return response, nil
+}
@@ -3,9 +3,10 @@ package executor
 import (
 	"encoding/base64"
 	"fmt"
-	"os"
+	"os/exec"
 	"unicode/utf16"
 
+	"execute_command/redact"
 	"execute_command/utils"
 )
 
@@ -14,6 +15,7 @@ type Base64Executor struct {
 	logger         *utils.ModuleLogger
 	shellType      ShellType
 	defaultCommand string
+	options        ExecutionOptions
 }
 
 // NewBase64Executor creates a new Base64Executor instance
@@ -24,7 +26,7 @@ func NewBase64Executor() CommandExecutor {
 		shellType = PowerShellShell
 	}
 	return &Base64Executor{
-		logger:         utils.GetModuleLogger("executor.base64"),
+		logger:         executorLogger(Base64Type, shellType, ExecutionOptions{}),
 		shellType:      shellType,
 		defaultCommand: getDefaultBase64Command(),
 	}
@@ -33,7 +35,7 @@ func NewBase64Executor() CommandExecutor {
 // NewBase64ExecutorWithShell creates a new Base64Executor instance with specific shell
 func NewBase64ExecutorWithShell(shellType ShellType) CommandExecutor {
 	return &Base64Executor{
-		logger:         utils.GetModuleLogger("executor.base64"),
+		logger:         executorLogger(Base64Type, shellType, ExecutionOptions{}),
 		shellType:      shellType,
 		defaultCommand: getDefaultBase64Command(),
 	}
@@ -42,10 +44,28 @@ func NewBase64ExecutorWithShell(shellType ShellType) CommandExecutor {
 // SetShellType sets the shell type for the executor
 func (be *Base64Executor) SetShellType(shellType ShellType) {
 	be.shellType = shellType
+	be.logger = executorLogger(Base64Type, shellType, be.options)
+}
+
+// redactor returns the redactor that masks secrets in results
+func (be *Base64Executor) redactor() *redact.Redactor {
+	return be.options.redactor()
+}
+
+// SetOptions sets the execution options (timeout, retries) for the executor
+func (be *Base64Executor) SetOptions(options ExecutionOptions) {
+	be.options = options
+	be.logger = executorLogger(Base64Type, be.shellType, options)
 }
 
 // ExecuteCommand executes a base64 encoded command
 func (be *Base64Executor) ExecuteCommand(encodedCommand string) error {
+	_, err := be.Execute(encodedCommand)
+	return err
+}
+
+// Execute executes a base64 encoded command and returns the result of every attempt
+func (be *Base64Executor) Execute(encodedCommand string) (*ExecutionResult, error) {
 	// Use default command if no command provided
 	if encodedCommand == "" {
 		encodedCommand = be.defaultCommand
@@ -55,19 +75,32 @@ func (be *Base64Executor) ExecuteCommand(encodedCommand string) error {
 	}
 	be.logger.Debug("Executing base64 command (shell: %s)", be.shellType.String())
 
-	// For PowerShell and Linux shell, we can use native/piped methods
-	if be.shellType == PowerShellShell || be.shellType == ShShell {
-		return be.executeBase64CommandDirect(encodedCommand)
-	}
-
-	// For CMD and auto (on Windows), we need to decode first
+	// Always decode first so the policy sees the real command, not the payload
+	span := be.options.Trace.Child("decode")
 	decoded, err := be.DecodeCommand(encodedCommand)
+	span.RecordError(err)
+	span.End()
 	if err != nil {
 		be.logger.Error("Failed to decode base64: %v", err)
-		return fmt.Errorf("failed to decode base64: %v", err)
+		return nil, err
+	}
+	if err := ValidateCommandWith(decoded, Base64Type, be.shellType, be.options); err != nil {
+		be.logger.Error("Command rejected: %v", err)
+		return nil, err
 	}
 
-	return be.executeCommand(decoded)
+	// For PowerShell and Linux shell, we can use native/piped methods
+	var result *ExecutionResult
+	if be.shellType == PowerShellShell || be.shellType == ShShell {
+		result, err = be.executeBase64CommandDirect(encodedCommand)
+	} else {
+		// For CMD and auto (on Windows), run the decoded command
+		result, err = be.executeCommand(decoded)
+	}
+	result.Command = encodedCommand
+	result.DecodedCommand = decoded
+	redactResult(result, be.options.redactor(), be.EncodeCommand)
+	return result, err
 }
 
 // EncodeCommand encodes a command to base64
@@ -102,53 +135,74 @@ func (be *Base64Executor) DecodeCommand(encodedCommand string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to decode base64: %v", err)
 	}
+	// For PowerShell, the payload is UTF-16LE (see EncodeCommand)
+	if be.shellType == PowerShellShell {
+		return decodeUTF16LE(decoded)
+	}
 	return string(decoded), nil
 }
 
+// decodeUTF16LE decodes UTF-16LE bytes as produced by encodeForPowerShell
+func decodeUTF16LE(data []byte) (string, error) {
+	if len(data)%2 != 0 {
+		return "", fmt.Errorf("failed to decode base64: payload is not valid UTF-16LE")
+	}
+	codePoints := make([]uint16, len(data)/2)
+	for i := range codePoints {
+		codePoints[i] = uint16(data[i*2]) | uint16(data[i*2+1])<<8
+	}
+	return string(utf16.Decode(codePoints)), nil
+}
+
 // executeCommand is a private method that handles the actual command execution
-func (be *Base64Executor) executeCommand(command string) error {
+func (be *Base64Executor) executeCommand(command string) (*ExecutionResult, error) {
 	be.logger.Debug("Executing: %s", command)
 
-	// Get the appropriate shell command
-	cmd := GetShellCommand(command, be.shellType)
+	result := be.newResult(command)
 
-	// Set output to current process
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
+	// Get the appropriate shell command for every attempt
+	runWithRetry(be.logger, be.options, result, func() *exec.Cmd {
+		return GetShellCommand(command, be.shellType)
+	})
 
-	// Execute command
-	err := cmd.Run()
-	if err != nil {
+	if !result.Succeeded() {
+		err := attemptError(result.LastAttempt())
 		be.logger.Error("Command execution failed: %v", err)
-		return fmt.Errorf("command execution failed: %v", err)
+		return result, fmt.Errorf("command execution failed: %v", err)
 	}
 
 	be.logger.Info("Command executed successfully")
-	return nil
+	return result, nil
 }
 
 // executeBase64CommandDirect is a private method that handles base64 command execution directly
-func (be *Base64Executor) executeBase64CommandDirect(encodedCommand string) error {
+func (be *Base64Executor) executeBase64CommandDirect(encodedCommand string) (*ExecutionResult, error) {
 	be.logger.Debug("Executing base64 directly (shell: %s)", be.shellType.String())
 
-	// Get the appropriate shell command for base64
-	cmd := GetShellCommandForBase64(encodedCommand, be.shellType)
+	result := be.newResult(encodedCommand)
 
-	// Set output to current process
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
+	// Get the appropriate shell command for base64 for every attempt
+	runWithRetry(be.logger, be.options, result, func() *exec.Cmd {
+		return GetShellCommandForBase64(encodedCommand, be.shellType)
+	})
 
-	// Execute command
-	err := cmd.Run()
-	if err != nil {
+	if !result.Succeeded() {
+		err := attemptError(result.LastAttempt())
 		be.logger.Error("Base64 command execution failed: %v", err)
-		return fmt.Errorf("base64 command execution failed: %v", err)
+		return result, fmt.Errorf("base64 command execution failed: %v", err)
 	}
 
 	be.logger.Info("Base64 command executed successfully")
-	return nil
+	return result, nil
+}
+
+// newResult creates an empty execution result for this executor
+func (be *Base64Executor) newResult(command string) *ExecutionResult {
+	return &ExecutionResult{
+		Command:  command,
+		Executor: Base64Type.String(),
+		Shell:    be.shellType.String(),
+	}
 }
 
 // getDefaultBase64Command returns the default base64 encoded command based on OS
@@ -0,0 +1,51 @@
+package executor
+
+import (
+	"unicode/utf8"
+)
+
+// tailBuffer captures a command's output, keeping only the last max bytes
+// while the command runs (max 0 keeps everything)
+type tailBuffer struct {
+	max       int
+	data      []byte
+	truncated bool
+}
+
+// Write implements io.Writer. Older output is dropped once the buffer holds
+// twice max, so it is copied at most once per max bytes written
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.max > 0 && len(p) >= b.max {
+		// The write replaces everything kept so far
+		b.truncated = b.truncated || len(b.data) > 0 || len(p) > b.max
+		b.data = append(b.data[:0], p[len(p)-b.max:]...)
+		return n, nil
+	}
+	b.data = append(b.data, p...)
+	if b.max > 0 && len(b.data) > 2*b.max {
+		b.data = append(b.data[:0], b.data[len(b.data)-b.max:]...)
+		b.truncated = true
+	}
+	return n, nil
+}
+
+// Truncated reports whether output was dropped
+func (b *tailBuffer) Truncated() bool {
+	return b.truncated || b.max > 0 && len(b.data) > b.max
+}
+
+// String returns the output kept, starting at a rune boundary if the
+// beginning was dropped
+func (b *tailBuffer) String() string {
+	data := b.data
+	if b.max > 0 && len(data) > b.max {
+		data = data[len(data)-b.max:]
+	}
+	if b.Truncated() {
+		for len(data) > 0 && !utf8.RuneStart(data[0]) {
+			data = data[1:]
+		}
+	}
+	return string(data)
+}
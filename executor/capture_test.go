@@ -0,0 +1,66 @@
+package executor
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTailBuffer(t *testing.T) {
+	tests := []struct {
+		max       int
+		writes    []string
+		want      string
+		truncated bool
+	}{
+		{0, []string{"abc", "def"}, "abcdef", false},
+		{6, []string{"abc", "def"}, "abcdef", false},
+		{4, []string{"abc", "def"}, "cdef", true},
+		{4, []string{"abcdefgh"}, "efgh", true},
+		{4, []string{"abcd"}, "abcd", false},
+		{4, []string{"x", "abcd"}, "abcd", true},
+		{3, []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"}, "ghi", true},
+		{4, []string{"ab", "日本"}, "本", true}, // Cut at a rune boundary
+	}
+	for _, test := range tests {
+		b := &tailBuffer{max: test.max}
+		for _, write := range test.writes {
+			if n, err := b.Write([]byte(write)); n != len(write) || err != nil {
+				t.Fatalf("Write(%q) = %d, %v", write, n, err)
+			}
+		}
+		if got := b.String(); got != test.want || b.Truncated() != test.truncated {
+			t.Errorf("max %d, writes %q: got %q (truncated %v), want %q (truncated %v)",
+				test.max, test.writes, got, b.Truncated(), test.want, test.truncated)
+		}
+	}
+}
+
+func TestTailBufferBoundsMemory(t *testing.T) {
+	b := &tailBuffer{max: 1000}
+	chunk := []byte(strings.Repeat("x", 100))
+	for i := 0; i < 10000; i++ {
+		b.Write(chunk)
+		if len(b.data) > 2*b.max {
+			t.Fatalf("buffer holds %d bytes after %d writes, more than %d", len(b.data), i+1, 2*b.max)
+		}
+	}
+	if got := b.String(); len(got) != 1000 || !b.Truncated() {
+		t.Fatalf("kept %d bytes (truncated %v), want 1000", len(got), b.Truncated())
+	}
+}
+
+func TestMaxOutputCapsAttempt(t *testing.T) {
+	if !IsUnix() {
+		t.Skip("uses sh")
+	}
+	options := ExecutionOptions{MaxOutput: 1000, Stdout: io.Discard, Stderr: io.Discard, Stdin: strings.NewReader("")}
+	result, err := NewExecutorFactory().CreateExecutorWithOptions(PlainType, ShShell, options).Execute("head -c 100000 /dev/zero | tr '\\0' x; echo end")
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	attempt := result.Attempts[0]
+	if len(attempt.Stdout) != 1000 || !strings.HasSuffix(attempt.Stdout, "xend\n") || !attempt.OutputTruncated {
+		t.Fatalf("stdout has %d bytes ending in %q (truncated %v)", len(attempt.Stdout), attempt.Stdout[len(attempt.Stdout)-5:], attempt.OutputTruncated)
+	}
+}
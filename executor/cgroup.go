@@ -0,0 +1,36 @@
+package executor
+
+import (
+	"time"
+)
+
+// CgroupOptions places each execution in its own transient cgroup v2, so the
+// whole process tree can be limited, accounted and killed together (Linux only)
+type CgroupOptions struct {
+	Enabled   bool    `json:"enabled,omitempty"`    // Use a cgroup even without limits (for accounting)
+	Parent    string  `json:"parent,omitempty"`     // Delegated parent cgroup directory (default: the tool's own cgroup)
+	MemoryMax uint64  `json:"memory_max,omitempty"` // Memory limit in bytes (memory.max)
+	CPUMax    float64 `json:"cpu_max,omitempty"`    // CPU quota in CPUs, e.g. 0.5 for half a CPU (cpu.max)
+	PidsMax   uint64  `json:"pids_max,omitempty"`   // Maximum number of processes (pids.max)
+}
+
+// IsSet reports whether executions should run in a transient cgroup
+func (co CgroupOptions) IsSet() bool {
+	return co.Enabled || co.MemoryMax > 0 || co.CPUMax > 0 || co.PidsMax > 0
+}
+
+// Names of the cgroup limits, as reported in AttemptResult.LimitExceeded
+const (
+	LimitCgroupMemory = "memory_max"
+	LimitCgroupPids   = "pids_max"
+)
+
+// CgroupUsage is the resource usage of an execution's whole process tree, read from its cgroup
+type CgroupUsage struct {
+	MemoryPeak  uint64        `json:"memory_peak"`            // Peak memory in bytes (0 if not available)
+	CPUUsage    time.Duration `json:"cpu_usage"`              // Total CPU time
+	CPUUser     time.Duration `json:"cpu_user"`               // User CPU time
+	CPUSystem   time.Duration `json:"cpu_system"`             // System CPU time
+	OOMKills    uint64        `json:"oom_kills,omitempty"`    // Processes killed for exceeding memory.max
+	PidsLimited uint64        `json:"pids_limited,omitempty"` // Forks refused because of pids.max
+}
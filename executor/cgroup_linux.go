@@ -0,0 +1,293 @@
+//go:build linux
+
+package executor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	cgroupParentMu sync.Mutex
+	cgroupCounter  uint64
+)
+
+// transientCgroup is a cgroup created for a single attempt
+type transientCgroup struct {
+	path string
+	dir  *os.File
+}
+
+// createCgroup creates a transient cgroup under the parent and applies the limits
+func createCgroup(options CgroupOptions) (*transientCgroup, error) {
+	parent, err := prepareCgroupParent(options)
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("execute_command-%d-%d", os.Getpid(), atomic.AddUint64(&cgroupCounter, 1))
+	path := filepath.Join(parent, name)
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup %s: %v", path, err)
+	}
+	cg := &transientCgroup{path: path}
+
+	settings := map[string]string{}
+	if options.MemoryMax > 0 {
+		settings["memory.max"] = strconv.FormatUint(options.MemoryMax, 10)
+	}
+	if options.CPUMax > 0 {
+		const period = 100000
+		settings["cpu.max"] = fmt.Sprintf("%d %d", int64(options.CPUMax*period), period)
+	}
+	if options.PidsMax > 0 {
+		settings["pids.max"] = strconv.FormatUint(options.PidsMax, 10)
+	}
+	for file, value := range settings {
+		if err := cg.write(file, value); err != nil {
+			cg.remove()
+			return nil, fmt.Errorf("failed to set %s: %v", file, err)
+		}
+	}
+	if options.MemoryMax > 0 {
+		// Without this the tree could swap instead of hitting memory.max (ignored if swap accounting is off)
+		cg.write("memory.swap.max", "0")
+	}
+
+	if cg.dir, err = os.Open(path); err != nil {
+		cg.remove()
+		return nil, fmt.Errorf("failed to open cgroup %s: %v", path, err)
+	}
+	return cg, nil
+}
+
+// attach makes the command start directly inside the cgroup
+func (cg *transientCgroup) attach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(cg.dir.Fd())
+}
+
+// kill kills every process in the cgroup at once
+func (cg *transientCgroup) kill() error {
+	if err := cg.write("cgroup.kill", "1"); err == nil {
+		return nil
+	}
+
+	// cgroup.kill needs Linux 5.14; fall back to killing each process
+	procs, err := os.ReadFile(filepath.Join(cg.path, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+	for _, field := range strings.Fields(string(procs)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+	return nil
+}
+
+// usage reads the resource usage of the cgroup
+func (cg *transientCgroup) usage() *CgroupUsage {
+	usage := &CgroupUsage{}
+
+	cpu := cg.readKeyed("cpu.stat")
+	usage.CPUUsage = time.Duration(cpu["usage_usec"]) * time.Microsecond
+	usage.CPUUser = time.Duration(cpu["user_usec"]) * time.Microsecond
+	usage.CPUSystem = time.Duration(cpu["system_usec"]) * time.Microsecond
+
+	if peak, err := os.ReadFile(filepath.Join(cg.path, "memory.peak")); err == nil {
+		usage.MemoryPeak, _ = strconv.ParseUint(strings.TrimSpace(string(peak)), 10, 64)
+	}
+	usage.OOMKills = cg.readKeyed("memory.events")["oom_kill"]
+	usage.PidsLimited = cg.readKeyed("pids.events")["max"]
+
+	return usage
+}
+
+// remove kills anything left in the cgroup and deletes it
+func (cg *transientCgroup) remove() error {
+	if cg.dir != nil {
+		cg.dir.Close()
+	}
+	cg.kill()
+
+	// Processes leave the cgroup asynchronously after being killed
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		err := os.Remove(cg.path)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) || time.Now().After(deadline) {
+			return fmt.Errorf("failed to remove cgroup %s: %v", cg.path, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// write writes a value to a cgroup interface file
+func (cg *transientCgroup) write(file, value string) error {
+	return os.WriteFile(filepath.Join(cg.path, file), []byte(value), 0644)
+}
+
+// readKeyed reads a flat keyed cgroup file ("key value" lines)
+func (cg *transientCgroup) readKeyed(file string) map[string]uint64 {
+	values := map[string]uint64{}
+	data, err := os.ReadFile(filepath.Join(cg.path, file))
+	if err != nil {
+		return values
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			values[fields[0]], _ = strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return values
+}
+
+// prepareCgroupParent returns the parent cgroup directory with the controllers
+// needed by the limits enabled for its children
+func prepareCgroupParent(options CgroupOptions) (string, error) {
+	cgroupParentMu.Lock()
+	defer cgroupParentMu.Unlock()
+
+	parent := options.Parent
+	ownCgroup := false
+	if parent == "" {
+		var err error
+		if parent, err = currentCgroupPath(); err != nil {
+			return "", err
+		}
+		ownCgroup = true
+	}
+
+	var required []string
+	if options.MemoryMax > 0 {
+		required = append(required, "memory")
+	}
+	if options.CPUMax > 0 {
+		required = append(required, "cpu")
+	}
+	if options.PidsMax > 0 {
+		required = append(required, "pids")
+	}
+
+	available := strings.Fields(readFileString(filepath.Join(parent, "cgroup.controllers")))
+	enabled := strings.Fields(readFileString(filepath.Join(parent, "cgroup.subtree_control")))
+
+	var enable []string
+	for _, controller := range []string{"memory", "cpu", "pids"} {
+		isRequired := containsString(required, controller)
+		if !containsString(available, controller) {
+			if isRequired {
+				return "", fmt.Errorf("cgroup controller %q is not available in %s (is it delegated?)", controller, parent)
+			}
+			continue
+		}
+		if !containsString(enabled, controller) {
+			enable = append(enable, "+"+controller)
+		}
+	}
+	if len(enable) == 0 {
+		return parent, nil
+	}
+
+	control := filepath.Join(parent, "cgroup.subtree_control")
+	err := os.WriteFile(control, []byte(strings.Join(enable, " ")), 0644)
+	if err != nil && errors.Is(err, syscall.EBUSY) && ownCgroup {
+		// A cgroup with processes cannot enable controllers for its children
+		// ("no internal processes"), so move this process into a leaf first
+		if err = moveSelfToLeaf(parent); err == nil {
+			err = os.WriteFile(control, []byte(strings.Join(enable, " ")), 0644)
+		}
+	}
+	if err != nil {
+		if len(required) > 0 {
+			return "", fmt.Errorf("failed to enable cgroup controllers %v in %s: %v", enable, parent, err)
+		}
+		// Controllers are only needed for limits; accounting works without them
+	}
+	return parent, nil
+}
+
+// moveSelfToLeaf moves the current process into a leaf cgroup under parent
+func moveSelfToLeaf(parent string) error {
+	leaf := filepath.Join(parent, "execute_command.supervisor")
+	if err := os.Mkdir(leaf, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	return os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0644)
+}
+
+// currentCgroupPath returns the cgroup v2 directory of the current process
+func currentCgroupPath() (string, error) {
+	mount, err := cgroup2Mount()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", fmt.Errorf("failed to read /proc/self/cgroup: %v", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return filepath.Join(mount, strings.TrimPrefix(line, "0::")), nil
+		}
+	}
+	return "", fmt.Errorf("process is not in a cgroup v2 hierarchy")
+}
+
+// cgroup2Mount returns the mount point of the cgroup v2 filesystem
+func cgroup2Mount() (string, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", fmt.Errorf("failed to read mountinfo: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Fields after the " - " separator are: fstype source options
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		_, after, found := strings.Cut(line, " - ")
+		if found && strings.HasPrefix(after, "cgroup2 ") && len(fields) > 4 {
+			return fields[4], nil
+		}
+	}
+	return "", fmt.Errorf("cgroup v2 is not mounted")
+}
+
+// readFileString reads a file as a string, returning "" on error
+func readFileString(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// containsString checks if a slice contains a value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
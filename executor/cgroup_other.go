@@ -0,0 +1,24 @@
+//go:build !linux
+
+package executor
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// transientCgroup is not available outside Linux
+type transientCgroup struct{}
+
+// createCgroup is not supported outside Linux
+func createCgroup(options CgroupOptions) (*transientCgroup, error) {
+	return nil, fmt.Errorf("cgroups are only supported on Linux")
+}
+
+func (cg *transientCgroup) attach(cmd *exec.Cmd) {}
+
+func (cg *transientCgroup) kill() error { return nil }
+
+func (cg *transientCgroup) usage() *CgroupUsage { return nil }
+
+func (cg *transientCgroup) remove() error { return nil }
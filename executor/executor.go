@@ -7,6 +7,7 @@ import (
 	"runtime"
 	"strings"
 
+	"execute_command/policy"
 	"execute_command/utils"
 )
 
@@ -70,6 +71,30 @@ func (ef *ExecutorFactory) CreateExecutorWithShell(executorType ExecutorType, sh
 	}
 }
 
+// executorLogger returns the logger of an executor, with its type, shell
+// and the log fields of its options attached to every line
+func executorLogger(executorType ExecutorType, shellType ShellType, options ExecutionOptions) *utils.ModuleLogger {
+	return utils.GetModuleLogger("executor."+executorType.String()).
+		With("executor", executorType.String(), "shell", ResolveShellType(shellType).String()).
+		WithFields(options.LogFields)
+}
+
+// CreateExecutorWithOptions creates an executor with specific shell type and execution options
+func (ef *ExecutorFactory) CreateExecutorWithOptions(executorType ExecutorType, shellType ShellType, options ExecutionOptions) CommandExecutor {
+	ef.logger.Debug("Creating %s executor (shell: %s, timeout: %v, retries: %d)",
+		executorType.String(), shellType.String(), options.Timeout, options.Retry.MaxRetries)
+	switch executorType {
+	case PlainType:
+		executor := NewPlainExecutorWithShell(shellType).(*PlainExecutor)
+		executor.SetOptions(options)
+		return executor
+	default:
+		executor := NewBase64ExecutorWithShell(shellType).(*Base64Executor)
+		executor.SetOptions(options)
+		return executor
+	}
+}
+
 // GetDefaultExecutor creates a default executor (Base64)
 func (ef *ExecutorFactory) GetDefaultExecutor() CommandExecutor {
 	return ef.CreateExecutor(Base64Type)
@@ -163,16 +188,22 @@ func ParseExecutorType(executor string) ExecutorType {
 	}
 }
 
+// ResolveShellType returns the concrete shell used for a shell type,
+// choosing based on the OS when the type is auto
+func ResolveShellType(shellType ShellType) ShellType {
+	if shellType != AutoShell {
+		return shellType
+	}
+	if IsWindows() {
+		return CMDShell
+	}
+	return ShShell
+}
+
 // GetShellCommand returns the appropriate shell command for the current OS and shell type
 func GetShellCommand(command string, shellType ShellType) *exec.Cmd {
 	// If auto, determine based on OS
-	if shellType == AutoShell {
-		if IsWindows() {
-			shellType = CMDShell
-		} else {
-			shellType = ShShell
-		}
-	}
+	shellType = ResolveShellType(shellType)
 
 	switch shellType {
 	case CMDShell:
@@ -194,13 +225,7 @@ func GetShellCommand(command string, shellType ShellType) *exec.Cmd {
 // GetShellCommandForBase64 returns the appropriate shell command for base64 encoded commands
 func GetShellCommandForBase64(encodedCommand string, shellType ShellType) *exec.Cmd {
 	// If auto, determine based on OS
-	if shellType == AutoShell {
-		if IsWindows() {
-			shellType = CMDShell
-		} else {
-			shellType = ShShell
-		}
-	}
+	shellType = ResolveShellType(shellType)
 
 	switch shellType {
 	case CMDShell:
@@ -212,20 +237,25 @@ func GetShellCommandForBase64(encodedCommand string, shellType ShellType) *exec.
 		return exec.Command("powershell", "-EncodedCommand", encodedCommand)
 	case ShShell:
 		// Sh can handle base64 through pipe: echo "base64" | base64 -d | sh
-		command := fmt.Sprintf("echo '%s' | base64 -d | sh", encodedCommand)
-		return exec.Command("sh", "-c", command)
+		return base64PipeCommand(encodedCommand)
 	default:
 		// Fallback to auto behavior
 		if IsWindows() {
 			return exec.Command("cmd", "/C", encodedCommand)
 		} else {
 			// For Linux, use pipe method
-			command := fmt.Sprintf("echo '%s' | base64 -d | sh", encodedCommand)
-			return exec.Command("sh", "-c", command)
+			return base64PipeCommand(encodedCommand)
 		}
 	}
 }
 
+// base64PipeCommand builds the sh pipe that decodes and runs a base64 payload.
+// The payload is passed as a positional parameter rather than spliced into the
+// script, so it is never interpreted by the outer shell
+func base64PipeCommand(encodedCommand string) *exec.Cmd {
+	return exec.Command("sh", "-c", `printf '%s' "$1" | base64 -d | sh`, "sh", encodedCommand)
+}
+
 // GetDefaultShellCommand returns the default shell command (backward compatibility)
 func GetDefaultShellCommand(command string) *exec.Cmd {
 	return GetShellCommand(command, AutoShell)
@@ -233,19 +263,46 @@ func GetDefaultShellCommand(command string) *exec.Cmd {
 
 // ValidateCommand checks if a command is safe to execute (basic validation)
 func ValidateCommand(command string) error {
+	return ValidateCommandFor(command, PlainType, AutoShell)
+}
+
+// ValidateCommandFor checks a plaintext command before execution: it must not
+// be empty and must be allowed by the active command policy for the executor
+// and shell. Base64 payloads must be decoded before validation
+func ValidateCommandFor(command string, executorType ExecutorType, shellType ShellType) error {
+	return ValidateCommandWith(command, executorType, shellType, ExecutionOptions{})
+}
+
+// ValidateCommandWith is ValidateCommandFor with the caller and policy of
+// an execution's options
+func ValidateCommandWith(command string, executorType ExecutorType, shellType ShellType, options ExecutionOptions) error {
 	if command == "" {
 		return fmt.Errorf("command cannot be empty")
 	}
 
-	// Add more validation rules here if needed
-	// For example, check for dangerous commands, etc.
+	span := options.Trace.Child("policy")
+	defer span.End()
+	commandPolicy := options.Policy
+	if commandPolicy == nil {
+		commandPolicy = GetPolicy()
+	}
+	decision := evaluatePolicy(commandPolicy, command, executorType, shellType, options.Caller)
+	span.SetAttribute("policy.allowed", decision.Allowed)
+	if decision.Rule != nil {
+		span.SetAttribute("policy.rule", decision.Rule.Name)
+	}
+	if !decision.Allowed {
+		err := &policy.DeniedError{Decision: decision}
+		span.RecordError(err)
+		return err
+	}
 
 	return nil
 }
 
 // ExecuteCommandWithValidation executes a command with basic validation
 func ExecuteCommandWithValidation(command string, shellType ShellType) error {
-	if err := ValidateCommand(command); err != nil {
+	if err := ValidateCommandFor(command, PlainType, shellType); err != nil {
 		return err
 	}
 
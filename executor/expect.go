@@ -0,0 +1,136 @@
+package executor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ExecutionStatus represents the outcome of an attempt or execution
+type ExecutionStatus int
+
+const (
+	StatusSuccess           ExecutionStatus = iota // Ran and met all success criteria
+	StatusFailed                                   // Failed to start or exited with an unexpected code
+	StatusTimeout                                  // Killed after the timeout expired
+	StatusExpectationFailed                        // Ran but did not meet the declared expectations
+	StatusCanceled                                 // Killed because the execution was canceled
+)
+
+// String returns the string representation of ExecutionStatus
+func (es ExecutionStatus) String() string {
+	switch es {
+	case StatusSuccess:
+		return "success"
+	case StatusFailed:
+		return "failed"
+	case StatusTimeout:
+		return "timeout"
+	case StatusExpectationFailed:
+		return "expectation_failed"
+	case StatusCanceled:
+		return "canceled"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the status as its string representation
+func (es ExecutionStatus) MarshalText() ([]byte, error) {
+	return []byte(es.String()), nil
+}
+
+// UnmarshalText decodes a status from its string representation
+func (es *ExecutionStatus) UnmarshalText(text []byte) error {
+	for _, status := range []ExecutionStatus{StatusSuccess, StatusFailed, StatusTimeout, StatusExpectationFailed, StatusCanceled} {
+		if status.String() == string(text) {
+			*es = status
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown execution status: %s", text)
+}
+
+// Expectations declares the success criteria for an execution
+type Expectations struct {
+	ExitCodes      []int          // Allowed exit codes (empty = only 0)
+	StdoutMatch    *regexp.Regexp // Stdout must match
+	StdoutNotMatch *regexp.Regexp // Stdout must not match
+	StderrMatch    *regexp.Regexp // Stderr must match
+	StderrNotMatch *regexp.Regexp // Stderr must not match
+	Contains       []string       // Stdout must contain each string
+	MaxDuration    time.Duration  // Attempt must finish within this duration (0 = no limit)
+}
+
+// IsSet reports whether any expectation beyond the default (exit code 0) is declared
+func (e Expectations) IsSet() bool {
+	return len(e.ExitCodes) > 0 || e.StdoutMatch != nil || e.StdoutNotMatch != nil ||
+		e.StderrMatch != nil || e.StderrNotMatch != nil || len(e.Contains) > 0 || e.MaxDuration > 0
+}
+
+// Check evaluates the expectations against a finished attempt and returns
+// a description of every expectation that was not met
+func (e Expectations) Check(attempt *AttemptResult) []string {
+	var failures []string
+
+	if len(e.ExitCodes) > 0 && !containsInt(e.ExitCodes, attempt.ExitCode) {
+		failures = append(failures, fmt.Sprintf("exit code %d not in allowed codes %v", attempt.ExitCode, e.ExitCodes))
+	}
+	if e.StdoutMatch != nil && !e.StdoutMatch.MatchString(attempt.Stdout) {
+		failures = append(failures, fmt.Sprintf("stdout does not match %q", e.StdoutMatch.String()))
+	}
+	if e.StdoutNotMatch != nil && e.StdoutNotMatch.MatchString(attempt.Stdout) {
+		failures = append(failures, fmt.Sprintf("stdout matches forbidden pattern %q", e.StdoutNotMatch.String()))
+	}
+	if e.StderrMatch != nil && !e.StderrMatch.MatchString(attempt.Stderr) {
+		failures = append(failures, fmt.Sprintf("stderr does not match %q", e.StderrMatch.String()))
+	}
+	if e.StderrNotMatch != nil && e.StderrNotMatch.MatchString(attempt.Stderr) {
+		failures = append(failures, fmt.Sprintf("stderr matches forbidden pattern %q", e.StderrNotMatch.String()))
+	}
+	for _, text := range e.Contains {
+		if !strings.Contains(attempt.Stdout, text) {
+			failures = append(failures, fmt.Sprintf("stdout does not contain %q", text))
+		}
+	}
+	if e.MaxDuration > 0 && attempt.Duration > e.MaxDuration {
+		failures = append(failures, fmt.Sprintf("duration %v exceeds maximum %v", attempt.Duration, e.MaxDuration))
+	}
+
+	return failures
+}
+
+// evaluateStatus determines the status of a finished attempt
+func evaluateStatus(attempt *AttemptResult, expectations Expectations) ExecutionStatus {
+	if attempt.Canceled {
+		return StatusCanceled
+	}
+	if attempt.TimedOut {
+		return StatusTimeout
+	}
+	if attempt.Error != "" || attempt.LimitExceeded != "" {
+		return StatusFailed
+	}
+
+	// Without explicit exit codes, a non-zero exit is a plain failure
+	if len(expectations.ExitCodes) == 0 && attempt.ExitCode != 0 {
+		return StatusFailed
+	}
+
+	attempt.ExpectationFailures = expectations.Check(attempt)
+	if len(attempt.ExpectationFailures) > 0 {
+		return StatusExpectationFailed
+	}
+	return StatusSuccess
+}
+
+// containsInt checks if a slice contains a value
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package executor
+
+import (
+	"os"
+	"os/user"
+)
+
+// RunAs selects the user and group a command runs as (Linux only)
+type RunAs struct {
+	User  string `json:"user,omitempty"`  // User name or numeric uid
+	Group string `json:"group,omitempty"` // Group name or numeric gid (default: the user's primary group)
+}
+
+// IsSet reports whether the command should run as another user or group
+func (ra RunAs) IsSet() bool {
+	return ra.User != "" || ra.Group != ""
+}
+
+// processIdentity is the resolved user and group a command runs as
+type processIdentity struct {
+	User   string
+	UID    int
+	GID    int
+	Groups []uint32
+	Home   string
+	Switch bool // Whether credentials must be changed for the child
+}
+
+// currentIdentity returns the effective identity of this process
+// (uid and gid are -1 on Windows)
+func currentIdentity() *processIdentity {
+	identity := &processIdentity{UID: os.Geteuid(), GID: os.Getegid()}
+	if current, err := user.Current(); err == nil {
+		identity.User = current.Username
+		identity.Home = current.HomeDir
+	}
+	return identity
+}
@@ -0,0 +1,142 @@
+//go:build linux
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Capability bits checked before switching credentials
+const (
+	capSetgid = 6
+	capSetuid = 7
+)
+
+// resolveIdentity resolves the user and group to run as and checks that this
+// process is allowed to switch to them
+func resolveIdentity(runAs RunAs) (*processIdentity, error) {
+	current := currentIdentity()
+	if !runAs.IsSet() {
+		return current, nil
+	}
+
+	identity := &processIdentity{UID: current.UID, GID: current.GID, User: current.User, Home: current.Home, Switch: true}
+
+	if runAs.User != "" {
+		u, err := lookupUser(runAs.User)
+		if err != nil {
+			return nil, err
+		}
+		identity.User = u.Username
+		identity.Home = u.HomeDir
+		identity.UID, _ = strconv.Atoi(u.Uid)
+		identity.GID, _ = strconv.Atoi(u.Gid)
+
+		// Supplementary groups of the target user
+		if groupIDs, err := u.GroupIds(); err == nil {
+			for _, id := range groupIDs {
+				if gid, err := strconv.ParseUint(id, 10, 32); err == nil {
+					identity.Groups = append(identity.Groups, uint32(gid))
+				}
+			}
+		}
+	}
+
+	if runAs.Group != "" {
+		gid, err := lookupGroupID(runAs.Group)
+		if err != nil {
+			return nil, err
+		}
+		identity.GID = gid
+	}
+
+	// Nothing to switch when the target is this process's own identity
+	if identity.UID == current.UID && identity.GID == current.GID {
+		identity.Switch = false
+		return identity, nil
+	}
+
+	if identity.UID != current.UID && !hasCapability(capSetuid) {
+		return nil, fmt.Errorf("insufficient privilege to run as user %s (uid %d): requires root or CAP_SETUID",
+			identity.User, identity.UID)
+	}
+	if (identity.GID != current.GID || len(identity.Groups) > 0) && !hasCapability(capSetgid) {
+		return nil, fmt.Errorf("insufficient privilege to run as group %d: requires root or CAP_SETGID", identity.GID)
+	}
+
+	return identity, nil
+}
+
+// apply sets the command's credentials and user environment (HOME, USER, LOGNAME)
+func (pi *processIdentity) apply(cmd *exec.Cmd) {
+	if !pi.Switch {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid:    uint32(pi.UID),
+		Gid:    uint32(pi.GID),
+		Groups: pi.Groups,
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	for name, value := range map[string]string{"HOME": pi.Home, "USER": pi.User, "LOGNAME": pi.User} {
+		env = append(environWithout(env, name), name+"="+value)
+	}
+	cmd.Env = env
+}
+
+// lookupUser finds a user by name or numeric uid
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u, nil
+		}
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user %q: %v", name, err)
+	}
+	return u, nil
+}
+
+// lookupGroupID finds a group id by name or numeric gid
+func lookupGroupID(name string) (int, error) {
+	if gid, err := strconv.Atoi(name); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, fmt.Errorf("unknown group %q: %v", name, err)
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// hasCapability checks if a capability is in this process's effective set
+func hasCapability(bit uint) bool {
+	if os.Geteuid() == 0 {
+		return true
+	}
+	data, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "CapEff:") {
+			caps, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "CapEff:")), 16, 64)
+			return err == nil && caps&(1<<bit) != 0
+		}
+	}
+	return false
+}
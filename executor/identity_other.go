@@ -0,0 +1,19 @@
+//go:build !linux
+
+package executor
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// resolveIdentity returns the current identity; switching users is only supported on Linux
+func resolveIdentity(runAs RunAs) (*processIdentity, error) {
+	if runAs.IsSet() {
+		return nil, fmt.Errorf("running as another user is only supported on Linux")
+	}
+	return currentIdentity(), nil
+}
+
+// apply is a no-op outside Linux
+func (pi *processIdentity) apply(cmd *exec.Cmd) {}
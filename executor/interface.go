@@ -5,6 +5,9 @@ type CommandExecutor interface {
 	// ExecuteCommand executes a command (behavior depends on executor type)
 	ExecuteCommand(command string) error
 
+	// Execute executes a command and returns the result of every attempt
+	Execute(command string) (*ExecutionResult, error)
+
 	// EncodeCommand encodes a command to base64
 	EncodeCommand(command string) string
 
@@ -0,0 +1,122 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// limitsHelperArg is the hidden first argument that makes the program act as
+// the resource limit helper instead of parsing flags
+const limitsHelperArg = "__exec-with-limits"
+
+// limitsEnv passes the limits from the executor to the helper process
+const limitsEnv = "EXECUTE_COMMAND_RLIMITS"
+
+// ResourceLimits caps the resources available to an executed command (Linux only)
+type ResourceLimits struct {
+	CPUSeconds   uint64 `json:"cpu_seconds,omitempty"`   // CPU time in seconds (RLIMIT_CPU)
+	AddressSpace uint64 `json:"address_space,omitempty"` // Virtual memory in bytes (RLIMIT_AS)
+	OpenFiles    uint64 `json:"open_files,omitempty"`    // Open file descriptors (RLIMIT_NOFILE)
+	Processes    uint64 `json:"processes,omitempty"`     // Processes of the user (RLIMIT_NPROC)
+	FileSize     uint64 `json:"file_size,omitempty"`     // Size of files written in bytes (RLIMIT_FSIZE)
+}
+
+// Names of the limits, as reported in AttemptResult.LimitExceeded
+const (
+	LimitCPU          = "cpu"
+	LimitAddressSpace = "address_space"
+	LimitOpenFiles    = "open_files"
+	LimitProcesses    = "processes"
+	LimitFileSize     = "file_size"
+)
+
+// IsSet reports whether any limit is configured
+func (rl ResourceLimits) IsSet() bool {
+	return rl.CPUSeconds > 0 || rl.AddressSpace > 0 || rl.OpenFiles > 0 || rl.Processes > 0 || rl.FileSize > 0
+}
+
+// String returns the limits as name=value pairs
+func (rl ResourceLimits) String() string {
+	var fields []string
+	for _, limit := range rl.values() {
+		if limit.value > 0 {
+			fields = append(fields, limit.name+"="+strconv.FormatUint(limit.value, 10))
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// limitValue is a named limit value
+type limitValue struct {
+	name  string
+	value uint64
+}
+
+// values returns every limit with its name
+func (rl ResourceLimits) values() []limitValue {
+	return []limitValue{
+		{LimitCPU, rl.CPUSeconds},
+		{LimitAddressSpace, rl.AddressSpace},
+		{LimitOpenFiles, rl.OpenFiles},
+		{LimitProcesses, rl.Processes},
+		{LimitFileSize, rl.FileSize},
+	}
+}
+
+// parseResourceLimits parses limits from the form produced by String
+func parseResourceLimits(value string) (ResourceLimits, error) {
+	var rl ResourceLimits
+	targets := map[string]*uint64{
+		LimitCPU:          &rl.CPUSeconds,
+		LimitAddressSpace: &rl.AddressSpace,
+		LimitOpenFiles:    &rl.OpenFiles,
+		LimitProcesses:    &rl.Processes,
+		LimitFileSize:     &rl.FileSize,
+	}
+	for _, field := range strings.Split(value, ",") {
+		if field == "" {
+			continue
+		}
+		name, number, _ := strings.Cut(field, "=")
+		target, ok := targets[name]
+		if !ok {
+			return rl, fmt.Errorf("unknown resource limit: %s", name)
+		}
+		parsed, err := strconv.ParseUint(number, 10, 64)
+		if err != nil {
+			return rl, fmt.Errorf("invalid value for resource limit %s: %v", name, err)
+		}
+		*target = parsed
+	}
+	return rl, nil
+}
+
+// IsLimitsHelper checks if the program was started as the resource limit helper
+func IsLimitsHelper(args []string) bool {
+	return len(args) > 2 && args[1] == limitsHelperArg
+}
+
+// RunLimitsHelper applies the resource limits passed by the executor and
+// replaces the process with the target command (args[2:]). It only returns
+// control to the OS by exiting if the limits or the exec fail
+func RunLimitsHelper(args []string) {
+	limits, err := parseResourceLimits(os.Getenv(limitsEnv))
+	if err == nil {
+		err = execWithLimits(limits, args[2], args[3:])
+	}
+	fmt.Fprintf(os.Stderr, "execute_command: failed to apply resource limits: %v\n", err)
+	os.Exit(126)
+}
+
+// environWithout returns env without the entries for the given variable
+func environWithout(env []string, name string) []string {
+	filtered := make([]string, 0, len(env))
+	for _, entry := range env {
+		if !strings.HasPrefix(entry, name+"=") {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
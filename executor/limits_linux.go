@@ -0,0 +1,134 @@
+//go:build linux
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// applyLimits rewrites the command to start through the resource limit helper,
+// which sets the limits with setrlimit in the child only and then execs the
+// original program
+func applyLimits(cmd *exec.Cmd, limits ResourceLimits) error {
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable for resource limit helper: %v", err)
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(environWithout(env, limitsEnv), limitsEnv+"="+limits.String())
+	cmd.Args = append([]string{self, limitsHelperArg, cmd.Path}, cmd.Args...)
+	cmd.Path = self
+	return nil
+}
+
+// execWithLimits sets the resource limits of the current process and replaces
+// it with the program at path (argv includes the program name)
+func execWithLimits(limits ResourceLimits, path string, argv []string) error {
+	resources := map[string]int{
+		LimitCPU:          syscall.RLIMIT_CPU,
+		LimitAddressSpace: syscall.RLIMIT_AS,
+		LimitOpenFiles:    syscall.RLIMIT_NOFILE,
+		LimitProcesses:    rlimitNproc,
+		LimitFileSize:     syscall.RLIMIT_FSIZE,
+	}
+
+	for _, limit := range limits.values() {
+		if limit.value == 0 {
+			continue
+		}
+		rlimit := syscall.Rlimit{Cur: limit.value, Max: limit.value}
+		if limit.name == LimitCPU {
+			// Soft limit sends SIGXCPU; the hard limit one second later sends SIGKILL
+			rlimit.Max = limit.value + 1
+		}
+		if err := syscall.Setrlimit(resources[limit.name], &rlimit); err != nil {
+			return fmt.Errorf("setrlimit %s=%d: %v", limit.name, limit.value, err)
+		}
+	}
+
+	return syscall.Exec(path, argv, environWithout(os.Environ(), limitsEnv))
+}
+
+// forkFailures are the messages shells and runtimes print when fork fails,
+// lower-cased (e.g. "sh: 1: Cannot fork", "bash: fork: retry: Resource
+// temporarily unavailable")
+var forkFailures = []string{
+	"cannot fork",
+	"can't fork",
+	"unable to fork",
+	"failed to fork",
+	"fork failed",
+	"fork: retry",
+	"fork: resource temporarily unavailable",
+}
+
+// containsAny checks if text contains any of the substrings
+func containsAny(text string, substrings []string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(text, substring) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectLimitExceeded guesses which resource limit stopped a command, from the
+// signal that ended it and, for limits that make system calls fail instead of
+// sending a signal, from the error messages on stderr
+func detectLimitExceeded(state *os.ProcessState, attempt *AttemptResult, limits ResourceLimits) string {
+	if !limits.IsSet() || state == nil {
+		return ""
+	}
+
+	signal := terminatingSignal(state, attempt.ExitCode)
+	switch {
+	case limits.CPUSeconds > 0 && signal == syscall.SIGXCPU:
+		return LimitCPU
+	case limits.CPUSeconds > 0 && signal == syscall.SIGKILL &&
+		uint64((state.UserTime()+state.SystemTime()).Seconds()) >= limits.CPUSeconds:
+		return LimitCPU
+	case limits.FileSize > 0 && signal == syscall.SIGXFSZ:
+		return LimitFileSize
+	}
+
+	if attempt.ExitCode == 0 {
+		return ""
+	}
+	stderr := strings.ToLower(attempt.Stderr)
+	switch {
+	case limits.FileSize > 0 && strings.Contains(stderr, "file too large"):
+		return LimitFileSize
+	case limits.OpenFiles > 0 && strings.Contains(stderr, "too many open files"):
+		return LimitOpenFiles
+	case limits.Processes > 0 && containsAny(stderr, forkFailures):
+		return LimitProcesses
+	case limits.AddressSpace > 0 && (strings.Contains(stderr, "cannot allocate memory") ||
+		strings.Contains(stderr, "out of memory") || strings.Contains(stderr, "memory exhausted") ||
+		strings.Contains(stderr, "bad_alloc") || strings.Contains(stderr, "memoryerror")):
+		return LimitAddressSpace
+	}
+	return ""
+}
+
+// terminatingSignal returns the signal that ended the command, either directly
+// or as reported by the shell through an exit code of 128+signal
+func terminatingSignal(state *os.ProcessState, exitCode int) syscall.Signal {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return status.Signal()
+	}
+	if exitCode > 128 && exitCode < 128+65 {
+		return syscall.Signal(exitCode - 128)
+	}
+	return 0
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// applyLimits is not supported outside Linux
+func applyLimits(cmd *exec.Cmd, limits ResourceLimits) error {
+	return fmt.Errorf("resource limits are only supported on Linux")
+}
+
+// execWithLimits is not supported outside Linux
+func execWithLimits(limits ResourceLimits, path string, argv []string) error {
+	return fmt.Errorf("resource limits are only supported on Linux")
+}
+
+// detectLimitExceeded always reports no limit outside Linux
+func detectLimitExceeded(state *os.ProcessState, attempt *AttemptResult, limits ResourceLimits) string {
+	return ""
+}
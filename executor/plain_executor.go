@@ -2,8 +2,9 @@ package executor
 
 import (
 	"fmt"
-	"os"
+	"os/exec"
 
+	"execute_command/redact"
 	"execute_command/utils"
 )
 
@@ -12,12 +13,13 @@ type PlainExecutor struct {
 	logger         *utils.ModuleLogger
 	shellType      ShellType
 	defaultCommand string
+	options        ExecutionOptions
 }
 
 // NewPlainExecutor creates a new PlainExecutor instance
 func NewPlainExecutor() CommandExecutor {
 	return &PlainExecutor{
-		logger:         utils.GetModuleLogger("executor.plain"),
+		logger:         executorLogger(PlainType, AutoShell, ExecutionOptions{}),
 		shellType:      AutoShell,
 		defaultCommand: getDefaultPlainCommand(),
 	}
@@ -26,7 +28,7 @@ func NewPlainExecutor() CommandExecutor {
 // NewPlainExecutorWithShell creates a new PlainExecutor instance with specific shell
 func NewPlainExecutorWithShell(shellType ShellType) CommandExecutor {
 	return &PlainExecutor{
-		logger:         utils.GetModuleLogger("executor.plain"),
+		logger:         executorLogger(PlainType, shellType, ExecutionOptions{}),
 		shellType:      shellType,
 		defaultCommand: getDefaultPlainCommand(),
 	}
@@ -35,10 +37,28 @@ func NewPlainExecutorWithShell(shellType ShellType) CommandExecutor {
 // SetShellType sets the shell type for the executor
 func (pe *PlainExecutor) SetShellType(shellType ShellType) {
 	pe.shellType = shellType
+	pe.logger = executorLogger(PlainType, shellType, pe.options)
+}
+
+// redactor returns the redactor that masks secrets in results
+func (pe *PlainExecutor) redactor() *redact.Redactor {
+	return pe.options.redactor()
+}
+
+// SetOptions sets the execution options (timeout, retries) for the executor
+func (pe *PlainExecutor) SetOptions(options ExecutionOptions) {
+	pe.options = options
+	pe.logger = executorLogger(PlainType, pe.shellType, options)
 }
 
 // ExecuteCommand executes a plaintext command directly
 func (pe *PlainExecutor) ExecuteCommand(command string) error {
+	_, err := pe.Execute(command)
+	return err
+}
+
+// Execute executes a plaintext command directly and returns the result of every attempt
+func (pe *PlainExecutor) Execute(command string) (*ExecutionResult, error) {
 	// Use default command if no command provided
 	if command == "" {
 		command = pe.defaultCommand
@@ -62,25 +82,34 @@ func (pe *PlainExecutor) DecodeCommand(command string) (string, error) {
 }
 
 // executeCommand executes the command using the appropriate shell
-func (pe *PlainExecutor) executeCommand(command string) error {
+func (pe *PlainExecutor) executeCommand(command string) (*ExecutionResult, error) {
 	pe.logger.Debug("Executing: %s (shell: %s)", command, pe.shellType.String())
 
-	cmd := GetShellCommand(command, pe.shellType)
+	if err := ValidateCommandWith(command, PlainType, pe.shellType, pe.options); err != nil {
+		pe.logger.Error("Command rejected: %v", err)
+		return nil, err
+	}
+
+	result := &ExecutionResult{
+		Command:  command,
+		Executor: PlainType.String(),
+		Shell:    pe.shellType.String(),
+	}
 
-	// Set output to current process stdout/stderr
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
+	// Build a fresh command for every attempt
+	runWithRetry(pe.logger, pe.options, result, func() *exec.Cmd {
+		return GetShellCommand(command, pe.shellType)
+	})
+	redactResult(result, pe.options.redactor(), nil)
 
-	// Execute the command
-	err := cmd.Run()
-	if err != nil {
+	if !result.Succeeded() {
+		err := attemptError(result.LastAttempt())
 		pe.logger.Error("Command execution failed: %v", err)
-		return fmt.Errorf("command execution failed: %v", err)
+		return result, fmt.Errorf("command execution failed: %v", err)
 	}
 
 	pe.logger.Info("Command executed successfully")
-	return nil
+	return result, nil
 }
 
 // getDefaultPlainCommand returns the default plaintext command based on OS
@@ -0,0 +1,55 @@
+package executor
+
+import (
+	"sync"
+
+	"execute_command/policy"
+)
+
+var (
+	policyMu     sync.RWMutex
+	activePolicy *policy.Policy
+)
+
+// SetPolicy sets the command policy enforced by every executor (nil disables enforcement)
+func SetPolicy(p *policy.Policy) {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	activePolicy = p
+}
+
+// GetPolicy returns the active command policy, or nil if none is set
+func GetPolicy() *policy.Policy {
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+	return activePolicy
+}
+
+// EvaluatePolicy evaluates a plaintext command against the active policy for
+// an executor and shell, on behalf of the current user
+func EvaluatePolicy(command string, executorType ExecutorType, shellType ShellType) policy.Decision {
+	return EvaluatePolicyAs(command, executorType, shellType, nil)
+}
+
+// EvaluatePolicyAs evaluates a plaintext command against the active policy
+// for an executor, shell and caller (nil = the current user). Without an
+// active policy every command is allowed
+func EvaluatePolicyAs(command string, executorType ExecutorType, shellType ShellType, caller *policy.Caller) policy.Decision {
+	return evaluatePolicy(GetPolicy(), command, executorType, shellType, caller)
+}
+
+// evaluatePolicy evaluates a command against a policy (nil allows everything)
+func evaluatePolicy(p *policy.Policy, command string, executorType ExecutorType, shellType ShellType, caller *policy.Caller) policy.Decision {
+	if p == nil {
+		return policy.Decision{Allowed: true, Reason: "allowed (no policy configured)"}
+	}
+	if caller == nil {
+		caller = policy.CurrentCaller()
+	}
+	return p.Evaluate(policy.Request{
+		Command:  command,
+		Executor: executorType.String(),
+		Shell:    ResolveShellType(shellType).String(),
+		Caller:   caller,
+	})
+}
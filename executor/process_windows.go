@@ -0,0 +1,18 @@
+//go:build windows
+
+package executor
+
+import (
+	"os/exec"
+)
+
+// prepareProcess is a no-op on Windows
+func prepareProcess(cmd *exec.Cmd) {}
+
+// killProcessTree kills the command (child processes are not tracked on Windows)
+func killProcessTree(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,59 @@
+package executor
+
+import (
+	"execute_command/redact"
+)
+
+// redactResult masks the secrets in a result's commands, output and errors
+// and records the rules that fired. A command with a decoded form is
+// re-encoded from the masked decoded command, so the secret doesn't survive
+// in the payload
+func redactResult(result *ExecutionResult, redactor *redact.Redactor, encode func(string) string) {
+	var rules []string
+	mask := func(text *string) bool {
+		var fired []string
+		*text, fired = redactor.RedactWithRules(*text)
+		rules = redact.Merge(rules, fired...)
+		return len(fired) > 0
+	}
+
+	mask(&result.Command)
+	if result.DecodedCommand != "" && mask(&result.DecodedCommand) && encode != nil {
+		result.Command = encode(result.DecodedCommand)
+	}
+	for i := range result.Attempts {
+		attempt := &result.Attempts[i]
+		mask(&attempt.Stdout)
+		mask(&attempt.Stderr)
+		mask(&attempt.Error)
+		for j := range attempt.ExpectationFailures {
+			mask(&attempt.ExpectationFailures[j])
+		}
+	}
+	result.Redactions = rules
+}
+
+// Redactor returns the redactor an executor masks secrets with: the one in
+// its options, or redact.Default
+func Redactor(cmdExecutor CommandExecutor) *redact.Redactor {
+	if e, ok := cmdExecutor.(interface{ redactor() *redact.Redactor }); ok {
+		return e.redactor()
+	}
+	return redact.Default
+}
+
+// RedactCommand masks the secrets in a command that may never have run, e.g.
+// one denied by the policy. The command is decoded with the executor, and
+// re-encoded from its masked form if that had a secret
+func RedactCommand(cmdExecutor CommandExecutor, command string) (string, []string) {
+	redactor := Redactor(cmdExecutor)
+	decoded, err := cmdExecutor.DecodeCommand(command)
+	if err != nil || decoded == command {
+		return redactor.RedactWithRules(command)
+	}
+	masked, rules := redactor.RedactWithRules(decoded)
+	if len(rules) == 0 {
+		return command, nil
+	}
+	return cmdExecutor.EncodeCommand(masked), rules
+}
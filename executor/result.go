@@ -0,0 +1,87 @@
+package executor
+
+import (
+	"time"
+)
+
+// AttemptResult holds the outcome of a single run of a command
+type AttemptResult struct {
+	Number    int           `json:"number"`
+	ExitCode  int           `json:"exit_code"`
+	Stdout    string        `json:"stdout,omitempty"`
+	Stderr    string        `json:"stderr,omitempty"`
+	StartTime time.Time     `json:"start_time"`
+	Duration  time.Duration `json:"duration"`
+	TimedOut  bool          `json:"timed_out,omitempty"`
+	Canceled  bool          `json:"canceled,omitempty"`
+	Error     string        `json:"error,omitempty"`
+
+	LimitExceeded string       `json:"limit_exceeded,omitempty"` // Resource limit that stopped the command
+	Cgroup        *CgroupUsage `json:"cgroup,omitempty"`         // Usage of the whole process tree (cgroup v2)
+
+	Usage *ResourceUsage `json:"usage,omitempty"` // rusage of the command (nil if it never started)
+
+	OutputTruncated bool `json:"output_truncated,omitempty"` // Only the end of stdout or stderr was kept (see MaxOutput)
+
+	Status              ExecutionStatus `json:"status"`
+	ExpectationFailures []string        `json:"expectation_failures,omitempty"`
+}
+
+// Succeeded reports whether the attempt met its success criteria
+func (ar *AttemptResult) Succeeded() bool {
+	return ar.Status == StatusSuccess
+}
+
+// ExecutionResult holds the outcome of an execution, including every attempt made
+type ExecutionResult struct {
+	Command        string          `json:"command"`
+	DecodedCommand string          `json:"decoded_command,omitempty"` // Plaintext of a base64 payload
+	Executor       string          `json:"executor"`
+	Shell          string          `json:"shell"`
+	User           string          `json:"user,omitempty"` // User the command ran as
+	UID            int             `json:"uid"`            // Effective uid of the command (-1 on Windows)
+	GID            int             `json:"gid"`            // Effective gid of the command (-1 on Windows)
+	Attempts       []AttemptResult `json:"attempts"`
+	Redactions     []string        `json:"redactions,omitempty"` // Rules that masked secrets in the command or output
+}
+
+// LastAttempt returns the final attempt, or nil if nothing was run
+func (er *ExecutionResult) LastAttempt() *AttemptResult {
+	if len(er.Attempts) == 0 {
+		return nil
+	}
+	return &er.Attempts[len(er.Attempts)-1]
+}
+
+// Succeeded reports whether the final attempt succeeded
+func (er *ExecutionResult) Succeeded() bool {
+	last := er.LastAttempt()
+	return last != nil && last.Succeeded()
+}
+
+// Status returns the status of the final attempt
+func (er *ExecutionResult) Status() ExecutionStatus {
+	last := er.LastAttempt()
+	if last == nil {
+		return StatusFailed
+	}
+	return last.Status
+}
+
+// ExitCode returns the exit code of the final attempt (-1 if nothing was run)
+func (er *ExecutionResult) ExitCode() int {
+	last := er.LastAttempt()
+	if last == nil {
+		return -1
+	}
+	return last.ExitCode
+}
+
+// TotalDuration returns the summed duration of all attempts
+func (er *ExecutionResult) TotalDuration() time.Duration {
+	var total time.Duration
+	for _, attempt := range er.Attempts {
+		total += attempt.Duration
+	}
+	return total
+}
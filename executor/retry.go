@@ -0,0 +1,143 @@
+package executor
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryConditionType represents the kind of failure a retry condition matches
+type RetryConditionType int
+
+const (
+	RetryOnExitCode RetryConditionType = iota // Retry on specific exit codes
+	RetryOnTimeout                            // Retry when the attempt timed out
+	RetryOnStderr                             // Retry when stderr matches a regex
+)
+
+// RetryCondition describes a failure that should trigger another attempt
+type RetryCondition struct {
+	Type      RetryConditionType
+	ExitCodes []int
+	Pattern   *regexp.Regexp
+}
+
+// String returns the string representation of RetryCondition
+func (rc RetryCondition) String() string {
+	switch rc.Type {
+	case RetryOnExitCode:
+		codes := make([]string, len(rc.ExitCodes))
+		for i, code := range rc.ExitCodes {
+			codes[i] = strconv.Itoa(code)
+		}
+		return "exit=" + strings.Join(codes, ",")
+	case RetryOnTimeout:
+		return "timeout"
+	case RetryOnStderr:
+		return "stderr=" + rc.Pattern.String()
+	default:
+		return "unknown"
+	}
+}
+
+// Matches checks if a failed attempt satisfies the condition
+func (rc RetryCondition) Matches(attempt *AttemptResult) bool {
+	switch rc.Type {
+	case RetryOnExitCode:
+		for _, code := range rc.ExitCodes {
+			if attempt.ExitCode == code {
+				return true
+			}
+		}
+		return false
+	case RetryOnTimeout:
+		return attempt.TimedOut
+	case RetryOnStderr:
+		return rc.Pattern.MatchString(attempt.Stderr)
+	default:
+		return false
+	}
+}
+
+// ParseRetryCondition parses a retry condition of the form
+// "exit=1,2,137", "timeout" or "stderr=<regex>"
+func ParseRetryCondition(condition string) (RetryCondition, error) {
+	kind, value, _ := strings.Cut(condition, "=")
+	switch strings.ToLower(strings.TrimSpace(kind)) {
+	case "exit":
+		var codes []int
+		for _, field := range strings.Split(value, ",") {
+			code, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return RetryCondition{}, fmt.Errorf("invalid exit code %q in retry condition", field)
+			}
+			codes = append(codes, code)
+		}
+		return RetryCondition{Type: RetryOnExitCode, ExitCodes: codes}, nil
+	case "timeout":
+		return RetryCondition{Type: RetryOnTimeout}, nil
+	case "stderr":
+		pattern, err := regexp.Compile(value)
+		if err != nil {
+			return RetryCondition{}, fmt.Errorf("invalid stderr pattern in retry condition: %v", err)
+		}
+		return RetryCondition{Type: RetryOnStderr, Pattern: pattern}, nil
+	default:
+		return RetryCondition{}, fmt.Errorf("unknown retry condition: %s (use exit=<codes>, timeout or stderr=<regex>)", condition)
+	}
+}
+
+// RetryPolicy controls how failed attempts are retried
+type RetryPolicy struct {
+	MaxRetries int              // Number of retries after the first attempt
+	Delay      time.Duration    // Base delay before the first retry
+	MaxDelay   time.Duration    // Upper bound for the backoff delay (0 = no limit)
+	Conditions []RetryCondition // Failures that trigger a retry (empty = any failure)
+}
+
+// ShouldRetry checks if a failed attempt qualifies for another try under the policy
+func (rp RetryPolicy) ShouldRetry(attempt *AttemptResult) bool {
+	if attempt.Succeeded() || attempt.Status == StatusCanceled || attempt.Number > rp.MaxRetries {
+		return false
+	}
+	if len(rp.Conditions) == 0 {
+		return true
+	}
+	for _, condition := range rp.Conditions {
+		if condition.Matches(attempt) {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns the delay before the given retry (1-based) using exponential
+// backoff with jitter: half of the delay is fixed, the other half is random
+func (rp RetryPolicy) Backoff(retry int) time.Duration {
+	if rp.Delay <= 0 {
+		return 0
+	}
+
+	delay := rp.Delay
+	for i := 1; i < retry; i++ {
+		// Without a MaxDelay, stop doubling before the delay overflows
+		if delay > math.MaxInt64/2 {
+			break
+		}
+		delay *= 2
+		if rp.MaxDelay > 0 && delay >= rp.MaxDelay {
+			delay = rp.MaxDelay
+			break
+		}
+	}
+	if rp.MaxDelay > 0 && delay > rp.MaxDelay {
+		delay = rp.MaxDelay
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
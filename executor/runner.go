@@ -0,0 +1,310 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"execute_command/policy"
+	"execute_command/redact"
+	"execute_command/tracing"
+	"execute_command/utils"
+)
+
+// ExecutionOptions controls how an executor runs commands
+type ExecutionOptions struct {
+	Timeout time.Duration  // Per-attempt timeout (0 = no timeout)
+	Retry   RetryPolicy    // Retry policy for failed attempts
+	Expect  Expectations   // Success criteria for each attempt
+	Limits  ResourceLimits // Resource limits for the command (Linux only)
+	Cgroup  CgroupOptions  // Transient cgroup v2 for the command's process tree (Linux only)
+	RunAs   RunAs          // User and group to run the command as (Linux only)
+	Caller  *policy.Caller // Who asked for the command, for the policy (nil = the current user)
+	Policy  *policy.Policy // Policy for this execution instead of the active one (nil = the active policy)
+	Trace   *tracing.Span  // Parent of the execution's spans (nil = not traced)
+
+	LogFields utils.Fields // Context added to the executor's log lines (e.g. job_id, request_id)
+
+	Context context.Context // Cancels the execution when done (nil = never canceled)
+	Stdin   io.Reader       // The command's stdin (default: os.Stdin; retries read what is left)
+	Stdout  io.Writer       // Receives the command's stdout (default: os.Stdout)
+	Stderr  io.Writer       // Receives the command's stderr (default: os.Stderr)
+	Env     []string        // Extra KEY=VALUE variables added to the command's environment
+
+	MaxOutput int              // Bytes of each stream kept in the result, the last ones (0 = all)
+	Redactor  *redact.Redactor // Masks secrets in the result (nil = redact.Default)
+}
+
+// redactor returns the redactor that masks secrets in the result
+func (eo ExecutionOptions) redactor() *redact.Redactor {
+	if eo.Redactor == nil {
+		return redact.Default
+	}
+	return eo.Redactor
+}
+
+// canceled returns a channel closed when the execution is canceled, or nil
+func (eo ExecutionOptions) canceled() <-chan struct{} {
+	if eo.Context == nil {
+		return nil
+	}
+	return eo.Context.Done()
+}
+
+// outputs returns the writers for the command's stdout and stderr
+func (eo ExecutionOptions) outputs() (io.Writer, io.Writer) {
+	stdout, stderr := io.Writer(os.Stdout), io.Writer(os.Stderr)
+	if eo.Stdout != nil {
+		stdout = eo.Stdout
+	}
+	if eo.Stderr != nil {
+		stderr = eo.Stderr
+	}
+	return stdout, stderr
+}
+
+// commandBuilder builds a fresh *exec.Cmd for every attempt
+type commandBuilder func() *exec.Cmd
+
+// runWithRetry runs a command, retrying failed attempts according to the
+// options, and records every attempt and the identity it ran as in the result
+func runWithRetry(logger *utils.ModuleLogger, options ExecutionOptions, result *ExecutionResult, build commandBuilder) {
+	identity, err := resolveIdentity(options.RunAs)
+	if err != nil {
+		logger.Error("Refusing to run command: %v", err)
+		result.Attempts = []AttemptResult{{
+			Number:    1,
+			ExitCode:  -1,
+			StartTime: time.Now(),
+			Error:     err.Error(),
+			Status:    StatusFailed,
+		}}
+		return
+	}
+	result.User = identity.User
+	result.UID = identity.UID
+	result.GID = identity.GID
+
+	for number := 1; ; number++ {
+		span := options.Trace.Child("attempt")
+		span.SetAttribute("attempt.number", number)
+		attempt := runAttempt(logger, options, identity, build(), number, span)
+		span.SetAttribute("process.exit_code", attempt.ExitCode)
+		span.SetAttribute("execution.status", attempt.Status.String())
+		if !attempt.Succeeded() {
+			span.RecordError(attemptError(&attempt))
+		}
+		span.End()
+		result.Attempts = append(result.Attempts, attempt)
+
+		if !options.Retry.ShouldRetry(&attempt) {
+			return
+		}
+
+		delay := options.Retry.Backoff(number)
+		logger.Warn("Attempt %d failed (status: %s, exit code: %d), retrying in %v",
+			number, attempt.Status.String(), attempt.ExitCode, delay)
+		select {
+		case <-time.After(delay):
+		case <-options.canceled():
+			logger.Warn("Execution canceled, not retrying")
+			return
+		}
+	}
+}
+
+// runAttempt runs a single attempt of a command and records its outcome.
+// The command gets the attempt's span as its TRACEPARENT
+func runAttempt(logger *utils.ModuleLogger, options ExecutionOptions, identity *processIdentity, cmd *exec.Cmd, number int, span *tracing.Span) AttemptResult {
+	stdout := &tailBuffer{max: options.MaxOutput}
+	stderr := &tailBuffer{max: options.MaxOutput}
+
+	// Stream output to the current process while capturing it for the result
+	stdoutSink, stderrSink := options.outputs()
+	cmd.Stdout = io.MultiWriter(stdoutSink, stdout)
+	cmd.Stderr = io.MultiWriter(stderrSink, stderr)
+	cmd.Stdin = os.Stdin
+	if options.Stdin != nil {
+		cmd.Stdin = options.Stdin
+	}
+	if len(options.Env) > 0 {
+		cmd.Env = append(cmd.Environ(), options.Env...)
+	}
+	if traceparent := span.Traceparent(); traceparent != "" {
+		cmd.Env = append(cmd.Environ(), tracing.TraceparentEnv+"="+traceparent)
+	}
+
+	attempt := AttemptResult{
+		Number:    number,
+		ExitCode:  -1,
+		StartTime: time.Now(),
+	}
+
+	spawn := span.Child("spawn")
+
+	// Credentials first: the limit helper copies the environment they set
+	identity.apply(cmd)
+
+	if options.Limits.IsSet() {
+		if err := applyLimits(cmd, options.Limits); err != nil {
+			attempt.Error = err.Error()
+			attempt.Status = StatusFailed
+			logger.Error("Failed to apply resource limits: %v", err)
+			spawn.RecordError(err)
+			spawn.End()
+			return attempt
+		}
+	}
+
+	kill := func() { killProcessTree(cmd) }
+	var cgroup *transientCgroup
+	if options.Cgroup.IsSet() {
+		var err error
+		if cgroup, err = createCgroup(options.Cgroup); err != nil {
+			attempt.Error = err.Error()
+			attempt.Status = StatusFailed
+			logger.Error("Failed to create cgroup: %v", err)
+			spawn.RecordError(err)
+			spawn.End()
+			return attempt
+		}
+		defer func() {
+			if err := cgroup.remove(); err != nil {
+				logger.Warn("%v", err)
+			}
+		}()
+		cgroup.attach(cmd)
+
+		// Kill the whole tree atomically through the cgroup
+		kill = func() {
+			if err := cgroup.kill(); err != nil {
+				killProcessTree(cmd)
+			}
+		}
+	}
+
+	// Don't block on grandchildren still holding the output pipes after a kill
+	if options.Timeout > 0 || options.Context != nil {
+		prepareProcess(cmd)
+		cmd.WaitDelay = time.Second
+	}
+
+	err := cmd.Start()
+	spawn.RecordError(err)
+	if cmd.Process != nil {
+		spawn.SetAttribute("process.pid", cmd.Process.Pid)
+	}
+	spawn.End()
+	if err == nil {
+		// Don't leave the command running if the process exits early, e.g. on a fatal error
+		unregister := utils.RegisterExitHook(kill)
+		run := span.Child("run")
+		err = waitWithTimeout(cmd, options.Timeout, options.canceled(), &attempt, kill)
+		unregister()
+		run.SetAttribute("timed_out", attempt.TimedOut)
+		run.SetAttribute("canceled", attempt.Canceled)
+		run.End()
+	}
+
+	output := span.Child("output")
+	defer output.End()
+	attempt.Duration = time.Since(attempt.StartTime)
+	attempt.Stdout = stdout.String()
+	attempt.Stderr = stderr.String()
+	attempt.OutputTruncated = stdout.Truncated() || stderr.Truncated()
+	if cmd.ProcessState != nil {
+		attempt.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	attempt.Usage = resourceUsage(cmd.ProcessState, attempt.Duration)
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		attempt.Error = err.Error()
+	}
+	if attempt.TimedOut {
+		attempt.Error = "command timed out after " + options.Timeout.String()
+	}
+	if attempt.Canceled {
+		attempt.Error = "command canceled"
+	}
+	attempt.LimitExceeded = detectLimitExceeded(cmd.ProcessState, &attempt, options.Limits)
+	if cgroup != nil {
+		attempt.Cgroup = cgroup.usage()
+		if attempt.LimitExceeded == "" && attempt.ExitCode != 0 {
+			attempt.LimitExceeded = cgroupLimitExceeded(attempt.Cgroup)
+		}
+	}
+	attempt.Status = evaluateStatus(&attempt, options.Expect)
+	output.SetAttribute("output.stdout_bytes", len(attempt.Stdout))
+	output.SetAttribute("output.stderr_bytes", len(attempt.Stderr))
+
+	logger.Debug("Attempt %d finished (status: %s, exit code: %d, duration: %v)",
+		number, attempt.Status.String(), attempt.ExitCode, attempt.Duration)
+	return attempt
+}
+
+// waitWithTimeout waits for a started command, killing it if the timeout
+// expires or the execution is canceled
+func waitWithTimeout(cmd *exec.Cmd, timeout time.Duration, canceled <-chan struct{}, attempt *AttemptResult, kill func()) error {
+	if timeout <= 0 && canceled == nil {
+		return cmd.Wait()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-expired:
+		attempt.TimedOut = true
+		kill()
+		return <-done
+	case <-canceled:
+		attempt.Canceled = true
+		kill()
+		return <-done
+	}
+}
+
+// cgroupLimitExceeded reports which cgroup limit stopped a command, if any
+func cgroupLimitExceeded(usage *CgroupUsage) string {
+	switch {
+	case usage == nil:
+		return ""
+	case usage.OOMKills > 0:
+		return LimitCgroupMemory
+	case usage.PidsLimited > 0:
+		return LimitCgroupPids
+	default:
+		return ""
+	}
+}
+
+// attemptError describes why an attempt failed
+func attemptError(attempt *AttemptResult) error {
+	if attempt.Error != "" {
+		return errors.New(attempt.Error)
+	}
+	if attempt.LimitExceeded != "" {
+		return fmt.Errorf("resource limit exceeded: %s (exit code %d)", attempt.LimitExceeded, attempt.ExitCode)
+	}
+	if attempt.Status == StatusExpectationFailed {
+		return fmt.Errorf("expectations not met: %s", strings.Join(attempt.ExpectationFailures, "; "))
+	}
+	return fmt.Errorf("exit status %d", attempt.ExitCode)
+}
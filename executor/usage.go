@@ -0,0 +1,19 @@
+package executor
+
+import (
+	"time"
+)
+
+// ResourceUsage is the resource usage of a finished command, as reported by
+// the operating system when it was reaped. Fields other than the times are
+// only available on Linux
+type ResourceUsage struct {
+	WallTime          time.Duration `json:"wall_time"`          // Elapsed wall-clock time
+	UserTime          time.Duration `json:"user_time"`          // CPU time spent in user mode
+	SystemTime        time.Duration `json:"system_time"`        // CPU time spent in the kernel
+	MaxRSS            uint64        `json:"max_rss"`            // Peak resident set size in bytes
+	VoluntarySwitches int64         `json:"voluntary_switches"` // Context switches from waiting (I/O, sleep)
+	ForcedSwitches    int64         `json:"forced_switches"`    // Context switches from preemption
+	BlockInputs       int64         `json:"block_inputs"`       // Block input operations
+	BlockOutputs      int64         `json:"block_outputs"`      // Block output operations
+}
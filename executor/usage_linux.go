@@ -0,0 +1,30 @@
+//go:build linux
+
+package executor
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// resourceUsage reads the rusage of a reaped process. It covers the process
+// and the descendants it waited for
+func resourceUsage(state *os.ProcessState, wall time.Duration) *ResourceUsage {
+	if state == nil {
+		return nil
+	}
+	usage := &ResourceUsage{
+		WallTime:   wall,
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+	}
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok && rusage != nil {
+		usage.MaxRSS = uint64(rusage.Maxrss) * 1024 // Reported in kilobytes
+		usage.VoluntarySwitches = int64(rusage.Nvcsw)
+		usage.ForcedSwitches = int64(rusage.Nivcsw)
+		usage.BlockInputs = int64(rusage.Inblock)
+		usage.BlockOutputs = int64(rusage.Oublock)
+	}
+	return usage
+}
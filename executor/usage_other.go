@@ -0,0 +1,20 @@
+//go:build !linux
+
+package executor
+
+import (
+	"os"
+	"time"
+)
+
+// resourceUsage reports the wall-clock and CPU times of a reaped process
+func resourceUsage(state *os.ProcessState, wall time.Duration) *ResourceUsage {
+	if state == nil {
+		return nil
+	}
+	return &ResourceUsage{
+		WallTime:   wall,
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+	}
+}
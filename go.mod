@@ -1,3 +1,3 @@
 module execute_command
 
-go 1.18
+go 1.20
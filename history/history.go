@@ -0,0 +1,336 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"execute_command/utils"
+)
+
+// Files in a history directory
+const (
+	indexFile = "history.jsonl"
+	lockFile  = "history.lock"
+	outputDir = "output"
+)
+
+// Entry is the record of one execution. Entries are kept in an index file
+// (JSON lines) and the full output of each is stored in its own file
+type Entry struct {
+	ID             int64     `json:"id"`
+	Time           time.Time `json:"time"`
+	User           string    `json:"user"`
+	WorkDir        string    `json:"work_dir"`
+	Command        string    `json:"command"`
+	DecodedCommand string    `json:"decoded_command,omitempty"`
+	Executor       string    `json:"executor"`
+	Shell          string    `json:"shell"`
+	Status         string    `json:"status"`
+	ExitCode       int       `json:"exit_code"`
+	DurationMs     int64     `json:"duration_ms"`
+	Attempts       int       `json:"attempts"`
+	Error          string    `json:"error,omitempty"`
+	OutputBytes    int64     `json:"output_bytes"`         // Size of the stored output file
+	RerunOf        int64     `json:"rerun_of,omitempty"`   // Entry this execution repeated
+	JobID          string    `json:"job_id,omitempty"`     // Background job that ran the command
+	Redactions     []string  `json:"redactions,omitempty"` // Rules that masked secrets in the command or output
+}
+
+// DisplayCommand returns the decoded command if there is one, otherwise the command
+func (e *Entry) DisplayCommand() string {
+	if e.DecodedCommand != "" {
+		return e.DecodedCommand
+	}
+	return e.Command
+}
+
+// Output is the captured output of an execution's final attempt
+type Output struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+}
+
+// Retention caps the size of the history. The oldest entries are removed
+// first; the newest entry is always kept
+type Retention struct {
+	MaxEntries int   // Maximum number of entries (0 = unlimited)
+	MaxBytes   int64 // Maximum size of the index and output files (0 = unlimited)
+}
+
+// Filter selects entries when listing the history
+type Filter struct {
+	Since  time.Time // Only entries at or after this time (zero = no limit)
+	Until  time.Time // Only entries before this time (zero = no limit)
+	Status string    // Only entries with this status
+	Text   string    // Only entries whose command contains this text (case-insensitive)
+	Limit  int       // Only the most recent entries (0 = all)
+}
+
+// Matches reports whether an entry passes the filter (ignoring Limit)
+func (f Filter) Matches(entry *Entry) bool {
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !entry.Time.Before(f.Until) {
+		return false
+	}
+	if f.Status != "" && !strings.EqualFold(entry.Status, f.Status) {
+		return false
+	}
+	if f.Text != "" {
+		text := strings.ToLower(f.Text)
+		if !strings.Contains(strings.ToLower(entry.Command), text) &&
+			!strings.Contains(strings.ToLower(entry.DecodedCommand), text) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseTime parses a filter time: a duration before now (e.g. 2h, 30m), a
+// date (2006-01-02) or an RFC 3339 timestamp
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if strings.HasSuffix(value, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (use a duration like 2h or 7d, a date like 2006-01-02, or RFC 3339)", value)
+}
+
+// Store is a file-based execution history
+type Store struct {
+	dir       string
+	retention Retention
+	mu        sync.Mutex
+	logger    *utils.ModuleLogger
+}
+
+// NewStore creates a history store in dir
+func NewStore(dir string, retention Retention) *Store {
+	return &Store{
+		dir:       dir,
+		retention: retention,
+		logger:    utils.GetModuleLogger("history"),
+	}
+}
+
+// DefaultDir returns the default history directory in the state directory
+func DefaultDir() (string, error) {
+	dir, err := utils.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history"), nil
+}
+
+// Add assigns the next ID to an entry, stores it with its output and
+// removes old entries beyond the retention limits
+func (s *Store) Add(entry *Entry, output Output) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Join(s.dir, outputDir), 0700); err != nil {
+		return fmt.Errorf("failed to create history directory: %v", err)
+	}
+
+	// Serialize writers from other processes; the index itself is replaced when pruning
+	lock, err := os.OpenFile(filepath.Join(s.dir, lockFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open history lock: %v", err)
+	}
+	defer lock.Close()
+	if err := utils.LockFile(lock); err != nil {
+		return fmt.Errorf("failed to lock history: %v", err)
+	}
+	defer utils.UnlockFile(lock)
+
+	entries, err := s.readIndex()
+	if err != nil {
+		return err
+	}
+	entry.ID = 1
+	if len(entries) > 0 {
+		entry.ID = entries[len(entries)-1].ID + 1
+	}
+
+	data, err := json.Marshal(output)
+	if err != nil {
+		return fmt.Errorf("failed to encode output: %v", err)
+	}
+	if err := os.WriteFile(s.outputPath(entry.ID), data, 0600); err != nil {
+		return fmt.Errorf("failed to write history output: %v", err)
+	}
+	entry.OutputBytes = int64(len(data))
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode history entry: %v", err)
+	}
+	file, err := os.OpenFile(filepath.Join(s.dir, indexFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %v", err)
+	}
+	_, err = file.Write(append(line, '\n'))
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write history entry: %v", err)
+	}
+	s.logger.Debug("Recorded history entry %d", entry.ID)
+
+	return s.prune(append(entries, entry))
+}
+
+// prune removes the oldest entries until the history fits the retention limits
+func (s *Store) prune(entries []*Entry) error {
+	size := int64(0)
+	for _, entry := range entries {
+		size += entry.OutputBytes
+	}
+	if info, err := os.Stat(filepath.Join(s.dir, indexFile)); err == nil {
+		size += info.Size()
+	}
+
+	drop := 0
+	for drop < len(entries)-1 {
+		overCount := s.retention.MaxEntries > 0 && len(entries)-drop > s.retention.MaxEntries
+		overSize := s.retention.MaxBytes > 0 && size > s.retention.MaxBytes
+		if !overCount && !overSize {
+			break
+		}
+		size -= entries[drop].OutputBytes
+		drop++
+	}
+	if drop == 0 {
+		return nil
+	}
+
+	// Rewrite the index without the dropped entries, then remove their output
+	var buf strings.Builder
+	for _, entry := range entries[drop:] {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode history entry: %v", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	path := filepath.Join(s.dir, indexFile)
+	if err := os.WriteFile(path+".tmp", []byte(buf.String()), 0600); err != nil {
+		return fmt.Errorf("failed to rewrite history: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to rewrite history: %v", err)
+	}
+	for _, entry := range entries[:drop] {
+		os.Remove(s.outputPath(entry.ID))
+	}
+
+	s.logger.Debug("Removed %d old history entries", drop)
+	return nil
+}
+
+// List returns the entries matching the filter, oldest first
+func (s *Store) List(filter Filter) ([]*Entry, error) {
+	entries, err := s.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []*Entry
+	for _, entry := range entries {
+		if filter.Matches(entry) {
+			matched = append(matched, entry)
+		}
+	}
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[len(matched)-filter.Limit:]
+	}
+	return matched, nil
+}
+
+// Get returns the entry with the given ID
+func (s *Store) Get(id int64) (*Entry, error) {
+	entries, err := s.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return nil, fmt.Errorf("no such history entry: %d", id)
+}
+
+// Output returns the stored output of an entry
+func (s *Store) Output(id int64) (*Output, error) {
+	data, err := os.ReadFile(s.outputPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Output{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history output: %v", err)
+	}
+	output := &Output{}
+	if err := json.Unmarshal(data, output); err != nil {
+		return nil, fmt.Errorf("corrupt history output for entry %d: %v", id, err)
+	}
+	return output, nil
+}
+
+// outputPath returns the path of an entry's output file
+func (s *Store) outputPath(id int64) string {
+	return filepath.Join(s.dir, outputDir, strconv.FormatInt(id, 10)+".json")
+}
+
+// readIndex reads every entry in the index. Unreadable lines (e.g. a write
+// cut short by a crash) are skipped
+func (s *Store) readIndex() ([]*Entry, error) {
+	file, err := os.Open(filepath.Join(s.dir, indexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history: %v", err)
+	}
+	defer file.Close()
+
+	var entries []*Entry
+	reader := bufio.NewReader(file)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			entry := &Entry{}
+			if parseErr := json.Unmarshal(line, entry); parseErr != nil {
+				s.logger.Warn("Skipping unreadable history line %d: %v", lineNumber, parseErr)
+			} else {
+				entries = append(entries, entry)
+			}
+		}
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read history: %v", err)
+		}
+	}
+}
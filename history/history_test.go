@@ -0,0 +1,147 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addEntries adds n entries, each with output of the given size, and returns the store
+func addEntries(t *testing.T, retention Retention, n, outputSize int) *Store {
+	t.Helper()
+	store := NewStore(t.TempDir(), retention)
+	for i := 0; i < n; i++ {
+		entry := &Entry{Time: time.Now(), Command: "echo " + strings.Repeat("x", i), Status: "success"}
+		if err := store.Add(entry, Output{Stdout: strings.Repeat("o", outputSize)}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	return store
+}
+
+// entryIDs returns the IDs of every entry in the store
+func entryIDs(t *testing.T, store *Store) []int64 {
+	t.Helper()
+	entries, err := store.List(Filter{})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	ids := make([]int64, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	return ids
+}
+
+// diskSize returns the size of the index and output files
+func diskSize(t *testing.T, store *Store) int64 {
+	t.Helper()
+	var size int64
+	paths, _ := filepath.Glob(filepath.Join(store.dir, outputDir, "*.json"))
+	for _, path := range append(paths, filepath.Join(store.dir, indexFile)) {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += info.Size()
+	}
+	return size
+}
+
+func TestPruneMaxEntries(t *testing.T) {
+	store := addEntries(t, Retention{MaxEntries: 3}, 5, 10)
+	ids := entryIDs(t, store)
+	if len(ids) != 3 || ids[0] != 3 || ids[2] != 5 {
+		t.Fatalf("entries = %v, want [3 4 5]", ids)
+	}
+	for _, id := range []int64{1, 2} {
+		if _, err := os.Stat(store.outputPath(id)); !os.IsNotExist(err) {
+			t.Errorf("output of pruned entry %d still exists (%v)", id, err)
+		}
+	}
+	output, err := store.Output(5)
+	if err != nil || output.Stdout != strings.Repeat("o", 10) {
+		t.Fatalf("Output(5) = %+v, %v", output, err)
+	}
+}
+
+func TestPruneMaxBytes(t *testing.T) {
+	const maxBytes = 5000
+	store := addEntries(t, Retention{MaxBytes: maxBytes}, 10, 1000)
+	ids := entryIDs(t, store)
+	if len(ids) == 0 || len(ids) >= 10 || ids[len(ids)-1] != 10 {
+		t.Fatalf("entries = %v, want the newest entries only", ids)
+	}
+	if size := diskSize(t, store); size > maxBytes {
+		t.Fatalf("history uses %d bytes, more than %d", size, maxBytes)
+	}
+}
+
+func TestPruneKeepsNewestEntry(t *testing.T) {
+	store := addEntries(t, Retention{MaxBytes: 100}, 3, 1000)
+	if ids := entryIDs(t, store); len(ids) != 1 || ids[0] != 3 {
+		t.Fatalf("entries = %v, want [3]", ids)
+	}
+}
+
+func TestNoRetentionKeepsEverything(t *testing.T) {
+	store := addEntries(t, Retention{}, 5, 100)
+	if ids := entryIDs(t, store); len(ids) != 5 {
+		t.Fatalf("entries = %v, want 5 entries", ids)
+	}
+}
+
+func TestIDsContinueAfterPruning(t *testing.T) {
+	store := addEntries(t, Retention{MaxEntries: 1}, 3, 10)
+	entry := &Entry{Time: time.Now(), Command: "ls", Status: "success"}
+	if err := store.Add(entry, Output{}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if entry.ID != 4 {
+		t.Fatalf("ID = %d, want 4", entry.ID)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	entry := &Entry{Time: now, Command: "systemctl restart nginx", Status: "failed"}
+	tests := []struct {
+		filter Filter
+		want   bool
+	}{
+		{Filter{}, true},
+		{Filter{Since: now}, true},
+		{Filter{Since: now.Add(time.Second)}, false},
+		{Filter{Until: now}, false},
+		{Filter{Until: now.Add(time.Second)}, true},
+		{Filter{Status: "FAILED"}, true},
+		{Filter{Status: "success"}, false},
+		{Filter{Text: "NGINX"}, true},
+		{Filter{Text: "apache"}, false},
+	}
+	for _, test := range tests {
+		if got := test.filter.Matches(entry); got != test.want {
+			t.Errorf("%+v.Matches = %v, want %v", test.filter, got, test.want)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	tests := map[string]time.Time{
+		"2h":                   now.Add(-2 * time.Hour),
+		"7d":                   now.AddDate(0, 0, -7),
+		"2026-01-10T08:00:00Z": time.Date(2026, 1, 10, 8, 0, 0, 0, time.UTC),
+	}
+	for value, want := range tests {
+		got, err := ParseTime(value, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("ParseTime(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+	if _, err := ParseTime("yesterday", now); err == nil {
+		t.Error("ParseTime(yesterday) succeeded")
+	}
+}
@@ -0,0 +1,288 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"execute_command/utils"
+)
+
+// State is the lifecycle state of a background job
+type State int
+
+const (
+	StateRunning  State = iota // Supervisor is running the command
+	StateFinished              // Command finished (see Status for the outcome)
+	StateKilled                // Command was killed with the kill action
+	StateLost                  // Supervisor exited without recording the outcome
+)
+
+// String returns the string representation of State
+func (s State) String() string {
+	switch s {
+	case StateRunning:
+		return "running"
+	case StateFinished:
+		return "finished"
+	case StateKilled:
+		return "killed"
+	case StateLost:
+		return "lost"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the state as its string representation
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a state from its string representation
+func (s *State) UnmarshalText(text []byte) error {
+	for _, state := range []State{StateRunning, StateFinished, StateKilled, StateLost} {
+		if state.String() == string(text) {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown job state: %s", text)
+}
+
+// Files in a job directory
+const (
+	jobFile        = "job.json"
+	stdoutFile     = "stdout.log"
+	stderrFile     = "stderr.log"
+	supervisorFile = "supervisor.log"
+)
+
+// Job is a command running, or that ran, in the background. It is stored as
+// job.json in its own directory next to the command's output logs
+type Job struct {
+	ID             string     `json:"id"`
+	PID            int        `json:"pid"` // Supervisor process that owns the command
+	State          State      `json:"state"`
+	Command        string     `json:"command"`
+	DecodedCommand string     `json:"decoded_command,omitempty"`
+	Executor       string     `json:"executor"`
+	Shell          string     `json:"shell"`
+	WorkDir        string     `json:"work_dir"`
+	StartTime      time.Time  `json:"start_time"`
+	EndTime        *time.Time `json:"end_time,omitempty"`
+	Status         string     `json:"status,omitempty"` // Execution status once finished
+	ExitCode       int        `json:"exit_code"`
+	Error          string     `json:"error,omitempty"`
+
+	dir string
+}
+
+// Running reports whether the job has not finished yet
+func (j *Job) Running() bool {
+	return j.State == StateRunning
+}
+
+// Duration returns how long the job ran, or has been running
+func (j *Job) Duration() time.Duration {
+	if j.EndTime != nil {
+		return j.EndTime.Sub(j.StartTime)
+	}
+	return time.Since(j.StartTime)
+}
+
+// Dir returns the job's directory
+func (j *Job) Dir() string {
+	return j.dir
+}
+
+// StdoutPath returns the path of the command's stdout log
+func (j *Job) StdoutPath() string {
+	return filepath.Join(j.dir, stdoutFile)
+}
+
+// StderrPath returns the path of the command's stderr log
+func (j *Job) StderrPath() string {
+	return filepath.Join(j.dir, stderrFile)
+}
+
+// SupervisorLogPath returns the path of the supervisor's own log
+func (j *Job) SupervisorLogPath() string {
+	return filepath.Join(j.dir, supervisorFile)
+}
+
+// save atomically replaces job.json
+func (j *Job) save() error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode job %s: %v", j.ID, err)
+	}
+	path := filepath.Join(j.dir, jobFile)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("failed to write job %s: %v", j.ID, err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// finish records the end of the job
+func (j *Job) finish(state State, status string, exitCode int, errText string) error {
+	now := time.Now().UTC()
+	j.State = state
+	j.EndTime = &now
+	j.Status = status
+	j.ExitCode = exitCode
+	j.Error = errText
+	return j.save()
+}
+
+// Store keeps background jobs in a directory, one subdirectory per job
+type Store struct {
+	dir    string
+	logger *utils.ModuleLogger
+}
+
+// NewStore creates a job store in dir
+func NewStore(dir string) *Store {
+	return &Store{
+		dir:    dir,
+		logger: utils.GetModuleLogger("jobs"),
+	}
+}
+
+// DefaultDir returns the default job directory in the state directory
+func DefaultDir() (string, error) {
+	dir, err := utils.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "jobs"), nil
+}
+
+// create assigns an ID to a new job and creates its directory and job.json
+func (s *Store) create(job *Job) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create job directory: %v", err)
+	}
+	for {
+		id, err := newID()
+		if err != nil {
+			return err
+		}
+		dir := filepath.Join(s.dir, id)
+		if err := os.Mkdir(dir, 0700); err != nil {
+			if errors.Is(err, os.ErrExist) {
+				continue
+			}
+			return fmt.Errorf("failed to create job directory: %v", err)
+		}
+		job.ID = id
+		job.dir = dir
+		return job.save()
+	}
+}
+
+// Get loads a job by ID or unique ID prefix
+func (s *Store) Get(id string) (*Job, error) {
+	if id == "" {
+		return nil, fmt.Errorf("no job ID given")
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read job directory: %v", err)
+	}
+
+	var matches []string
+	for _, entry := range entries {
+		if entry.Name() == id {
+			matches = []string{id}
+			break
+		}
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), id) {
+			matches = append(matches, entry.Name())
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no such job: %s", id)
+	case 1:
+		return s.load(matches[0])
+	default:
+		return nil, fmt.Errorf("job ID %s is ambiguous (matches %s)", id, strings.Join(matches, ", "))
+	}
+}
+
+// List returns every job, oldest first
+func (s *Store) List() ([]*Job, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job directory: %v", err)
+	}
+
+	var jobs []*Job
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		job, err := s.load(entry.Name())
+		if err != nil {
+			s.logger.Warn("Skipping job %s: %v", entry.Name(), err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(a, b int) bool {
+		return jobs[a].StartTime.Before(jobs[b].StartTime)
+	})
+	return jobs, nil
+}
+
+// load reads a job and detects supervisors that died without recording the outcome
+func (s *Store) load(id string) (*Job, error) {
+	job, err := readJob(filepath.Join(s.dir, id))
+	if err != nil {
+		return nil, err
+	}
+	if !job.Running() || job.PID == 0 || processAlive(job.PID) {
+		return job, nil
+	}
+
+	// The supervisor may have recorded the outcome just before exiting
+	if job, err = readJob(job.dir); err != nil || !job.Running() {
+		return job, err
+	}
+	s.logger.Warn("Supervisor of job %s (pid %d) is gone, marking the job as lost", job.ID, job.PID)
+	return job, job.finish(StateLost, "", -1, "supervisor exited without recording the outcome")
+}
+
+// readJob reads job.json from a job directory
+func readJob(dir string) (*Job, error) {
+	data, err := os.ReadFile(filepath.Join(dir, jobFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job: %v", err)
+	}
+	job := &Job{}
+	if err := json.Unmarshal(data, job); err != nil {
+		return nil, fmt.Errorf("corrupt job file in %s: %v", dir, err)
+	}
+	job.dir = dir
+	return job, nil
+}
+
+// newID returns a random 8 character job ID
+func newID() (string, error) {
+	var buf [4]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %v", err)
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
@@ -0,0 +1,235 @@
+package jobs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain lets the test binary act as the supervisor started by Start. Its
+// arguments are the store directory and what to do:
+//
+//	output  write to stdout and stderr, then finish successfully
+//	wait    write "ready" to stdout, wait to be killed, then finish
+//	exit    exit without recording the outcome
+func TestMain(m *testing.M) {
+	if !IsSupervisor(os.Args) {
+		os.Exit(m.Run())
+	}
+	id, args := SupervisorArgs(os.Args)
+	sv, err := NewStore(args[1]).Attach(id)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	switch args[2] {
+	case "output":
+		fmt.Fprint(sv.Stdout(), "to stdout")
+		fmt.Fprint(sv.Stderr(), "to stderr")
+		sv.Finish("success", 0, "")
+	case "wait":
+		fmt.Fprint(sv.Stdout(), "ready")
+		select {
+		case <-sv.Context().Done():
+			sv.Finish("failed", -1, "killed")
+		case <-time.After(time.Minute):
+			sv.Finish("timeout", -1, "never killed")
+		}
+	case "exit":
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// startJob starts a supervisor that does what is described in TestMain
+func startJob(t *testing.T, store *Store, action string) *Job {
+	t.Helper()
+	job := &Job{Command: "test " + action, Executor: "plain", Shell: "sh"}
+	if err := store.Start(job, []string{store.dir, action}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	return job
+}
+
+// waitJob waits for a job to stop running, for at most a few seconds
+func waitJob(t *testing.T, store *Store, job *Job) *Job {
+	t.Helper()
+	id := job.ID
+	done := make(chan struct{})
+	var err error
+	go func() {
+		job, err = store.Wait(job)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("job %s did not stop", id)
+	}
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	return job
+}
+
+// writeJob stores a job under a chosen ID
+func writeJob(t *testing.T, store *Store, id string, job *Job) {
+	t.Helper()
+	job.ID = id
+	job.dir = filepath.Join(store.dir, id)
+	if err := os.MkdirAll(job.dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// deadPID returns the PID of a process that has exited
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestStateText(t *testing.T) {
+	for _, state := range []State{StateRunning, StateFinished, StateKilled, StateLost} {
+		text, err := state.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got State
+		if err := got.UnmarshalText(text); err != nil || got != state {
+			t.Errorf("UnmarshalText(%s) = %v, %v", text, got, err)
+		}
+	}
+	var state State
+	if err := state.UnmarshalText([]byte("paused")); err == nil {
+		t.Error("UnmarshalText accepted an unknown state")
+	}
+}
+
+func TestSupervisorArgs(t *testing.T) {
+	args := []string{"execute_command", supervisorArg, "0a1b2c3d", "-command", "ls"}
+	if !IsSupervisor(args) {
+		t.Fatal("IsSupervisor = false")
+	}
+	id, rest := SupervisorArgs(args)
+	if id != "0a1b2c3d" || !reflect.DeepEqual(rest, []string{"execute_command", "-command", "ls"}) {
+		t.Errorf("SupervisorArgs = %s, %q", id, rest)
+	}
+	if IsSupervisor([]string{"execute_command", "-command", "ls"}) || IsSupervisor([]string{"execute_command", supervisorArg}) {
+		t.Error("IsSupervisor = true for a normal invocation")
+	}
+}
+
+func TestGet(t *testing.T) {
+	store := NewStore(t.TempDir())
+	for _, id := range []string{"aa11", "aa22", "bb33"} {
+		writeJob(t, store, id, &Job{State: StateFinished, StartTime: time.Now()})
+	}
+	for id, want := range map[string]string{"aa11": "aa11", "aa2": "aa22", "b": "bb33"} {
+		if job, err := store.Get(id); err != nil || job.ID != want {
+			t.Errorf("Get(%s) = %v, %v; want job %s", id, job, err, want)
+		}
+	}
+	for id, want := range map[string]string{"aa": "ambiguous", "cc": "no such job", "": "no job ID"} {
+		if _, err := store.Get(id); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Get(%q) error = %v, want %q", id, err, want)
+		}
+	}
+}
+
+func TestListOrdersByStartTime(t *testing.T) {
+	store := NewStore(t.TempDir())
+	now := time.Now()
+	writeJob(t, store, "ff", &Job{State: StateFinished, StartTime: now})
+	writeJob(t, store, "00", &Job{State: StateFinished, StartTime: now.Add(time.Second)})
+	jobs, err := store.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != "ff" || jobs[1].ID != "00" {
+		t.Fatalf("List = %v, want [ff 00]", jobs)
+	}
+	if jobs, err := NewStore(filepath.Join(t.TempDir(), "missing")).List(); err != nil || len(jobs) > 0 {
+		t.Errorf("List of a missing directory = %v, %v", jobs, err)
+	}
+}
+
+func TestLoadMarksDeadSupervisorLost(t *testing.T) {
+	store := NewStore(t.TempDir())
+	writeJob(t, store, "aa11", &Job{State: StateRunning, PID: deadPID(t), StartTime: time.Now()})
+	job, err := store.Get("aa11")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if job.State != StateLost || job.EndTime == nil || job.Error == "" {
+		t.Fatalf("job = %+v, want it lost", job)
+	}
+	if job, _ := readJob(job.Dir()); job.State != StateLost {
+		t.Errorf("stored state = %s, want lost", job.State)
+	}
+}
+
+func TestStartRecordsOutcome(t *testing.T) {
+	store := NewStore(t.TempDir())
+	job := waitJob(t, store, startJob(t, store, "output"))
+	if job.State != StateFinished || job.Status != "success" || job.ExitCode != 0 || job.EndTime == nil {
+		t.Fatalf("job = %+v, want it finished successfully", job)
+	}
+	var stdout, stderr bytes.Buffer
+	if err := store.CopyLogs(job, &stdout, &stderr, true); err != nil {
+		t.Fatalf("CopyLogs: %v", err)
+	}
+	if stdout.String() != "to stdout" || stderr.String() != "to stderr" {
+		t.Errorf("logs = %q, %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestKill(t *testing.T) {
+	store := NewStore(t.TempDir())
+	job := startJob(t, store, "wait")
+	// Kill once the supervisor is listening for the signal, so it records
+	// the outcome itself
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if data, _ := os.ReadFile(job.StdoutPath()); string(data) == "ready" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("supervisor %d did not start", job.PID)
+		}
+	}
+	job, err := store.Kill(job, 10*time.Second)
+	if err != nil {
+		t.Fatalf("Kill: %v", err)
+	}
+	if job.State != StateKilled {
+		t.Fatalf("state = %s, want killed", job.State)
+	}
+	// Windows has no signal the supervisor can handle, so Kill records it
+	if runtime.GOOS != "windows" && (job.Status != "failed" || job.Error != "killed") {
+		t.Errorf("job = %+v, want the outcome recorded by its supervisor", job)
+	}
+	if _, err := store.Kill(job, time.Second); err == nil {
+		t.Error("Kill of a stopped job succeeded")
+	}
+}
+
+func TestSupervisorExitWithoutOutcome(t *testing.T) {
+	store := NewStore(t.TempDir())
+	job := waitJob(t, store, startJob(t, store, "exit"))
+	if job.State != StateLost || job.ExitCode != -1 {
+		t.Fatalf("job = %+v, want it lost", job)
+	}
+}
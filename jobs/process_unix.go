@@ -0,0 +1,42 @@
+//go:build !windows
+
+package jobs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detachProcess starts the supervisor in a new session, so it is not killed
+// with the terminal or process group of the launcher
+func detachProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+// processAlive checks if a process exists and has not exited
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	if err != nil && !errors.Is(err, syscall.EPERM) {
+		return false
+	}
+
+	// A zombie has exited but not been reaped by its new parent yet. The state
+	// follows the command name in /proc/<pid>/stat, where /proc is available
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	if end := bytes.LastIndexByte(stat, ')'); end >= 0 && end+2 < len(stat) {
+		return stat[end+2] != 'Z'
+	}
+	return true
+}
+
+// terminateProcess asks a supervisor to kill its command and exit
+func terminateProcess(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
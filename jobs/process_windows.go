@@ -0,0 +1,44 @@
+//go:build windows
+
+package jobs
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const (
+	detachedProcess = 0x00000008 // DETACHED_PROCESS
+	stillActive     = 259        // STILL_ACTIVE
+)
+
+// detachProcess starts the supervisor without a console and in its own
+// process group, so it survives the launcher's console closing
+func detachProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | detachedProcess,
+	}
+}
+
+// processAlive checks if a process exists and has not exited
+func processAlive(pid int) bool {
+	handle, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(handle)
+
+	var code uint32
+	return syscall.GetExitCodeProcess(handle, &code) == nil && code == stillActive
+}
+
+// terminateProcess kills the supervisor. Windows has no termination signal,
+// so the supervisor cannot record the outcome itself
+func terminateProcess(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Kill()
+}
@@ -0,0 +1,234 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// supervisorArg is the hidden first argument that makes the program run a
+// job's command as its detached supervisor
+const supervisorArg = "__job-supervisor"
+
+// pollInterval is how often job state and logs are checked while waiting
+const pollInterval = 200 * time.Millisecond
+
+// IsSupervisor checks if the program was started as a job supervisor
+func IsSupervisor(args []string) bool {
+	return len(args) > 2 && args[1] == supervisorArg
+}
+
+// SupervisorArgs returns the job ID of a supervisor and its arguments with
+// the supervisor marker removed, so they can be parsed like a normal invocation
+func SupervisorArgs(args []string) (string, []string) {
+	return args[2], append([]string{args[0]}, args[3:]...)
+}
+
+// Start records a new job and launches a detached supervisor that runs the
+// program again with args. The supervisor outlives the calling process
+func (s *Store) Start(job *Job, args []string) error {
+	job.State = StateRunning
+	job.StartTime = time.Now().UTC()
+	job.WorkDir, _ = os.Getwd()
+	if err := s.create(job); err != nil {
+		return err
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %v", err)
+	}
+	logFile, err := os.OpenFile(job.SupervisorLogPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create supervisor log: %v", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(self, append([]string{supervisorArg, job.ID}, args...)...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	release, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	detachProcess(cmd)
+
+	if err := cmd.Start(); err != nil {
+		job.finish(StateLost, "", -1, err.Error())
+		return fmt.Errorf("failed to start supervisor: %v", err)
+	}
+
+	// The supervisor waits for stdin to close, so its PID is recorded before
+	// it can record anything itself
+	job.PID = cmd.Process.Pid
+	if err := job.save(); err != nil {
+		cmd.Process.Kill()
+		return err
+	}
+	release.Close()
+
+	s.logger.Debug("Started supervisor %d for job %s", job.PID, job.ID)
+	return cmd.Process.Release()
+}
+
+// Supervisor runs a job's command in the background and records its outcome
+type Supervisor struct {
+	job    *Job
+	ctx    context.Context
+	stop   context.CancelFunc
+	stdout *os.File
+	stderr *os.File
+}
+
+// Attach is called by a supervisor process to take over its job. It waits
+// until the launcher has recorded the supervisor, opens the output logs and
+// starts listening for the termination signal sent by the kill action
+func (s *Store) Attach(id string) (*Supervisor, error) {
+	io.Copy(io.Discard, os.Stdin)
+
+	job, err := readJob(filepath.Join(s.dir, id))
+	if err != nil {
+		return nil, err
+	}
+	if job.PID != os.Getpid() {
+		return nil, fmt.Errorf("job %s belongs to supervisor %d", job.ID, job.PID)
+	}
+
+	sv := &Supervisor{job: job}
+	if sv.stdout, err = os.OpenFile(job.StdoutPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return nil, fmt.Errorf("failed to create stdout log: %v", err)
+	}
+	if sv.stderr, err = os.OpenFile(job.StderrPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		sv.stdout.Close()
+		return nil, fmt.Errorf("failed to create stderr log: %v", err)
+	}
+	sv.ctx, sv.stop = signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	return sv, nil
+}
+
+// Job returns the supervised job
+func (sv *Supervisor) Job() *Job {
+	return sv.job
+}
+
+// Context is canceled when the job is killed
+func (sv *Supervisor) Context() context.Context {
+	return sv.ctx
+}
+
+// Stdout returns the log receiving the command's stdout
+func (sv *Supervisor) Stdout() io.Writer {
+	return sv.stdout
+}
+
+// Stderr returns the log receiving the command's stderr
+func (sv *Supervisor) Stderr() io.Writer {
+	return sv.stderr
+}
+
+// Finish closes the output logs and records the outcome of the command
+func (sv *Supervisor) Finish(status string, exitCode int, errText string) error {
+	sv.stdout.Close()
+	sv.stderr.Close()
+
+	state := StateFinished
+	if sv.ctx.Err() != nil {
+		state = StateKilled
+	}
+	sv.stop()
+	return sv.job.finish(state, status, exitCode, errText)
+}
+
+// Kill asks the supervisor of a running job to kill its command and waits
+// up to timeout for the job to stop
+func (s *Store) Kill(job *Job, timeout time.Duration) (*Job, error) {
+	if !job.Running() {
+		return job, fmt.Errorf("job %s is not running (%s)", job.ID, job.State)
+	}
+	if err := terminateProcess(job.PID); err != nil {
+		return job, fmt.Errorf("failed to signal supervisor %d: %v", job.PID, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		current, err := s.load(job.ID)
+		if err != nil {
+			return job, err
+		}
+		if current.State == StateLost {
+			// Killed before it could record the outcome (always the case on Windows)
+			return current, current.finish(StateKilled, "", -1, "killed")
+		}
+		if !current.Running() {
+			return current, nil
+		}
+		if time.Now().After(deadline) {
+			return current, fmt.Errorf("job %s is still running after %v", job.ID, timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// Wait blocks until a job stops running
+func (s *Store) Wait(job *Job) (*Job, error) {
+	for job.Running() {
+		time.Sleep(pollInterval)
+		current, err := s.load(job.ID)
+		if err != nil {
+			return job, err
+		}
+		job = current
+	}
+	return job, nil
+}
+
+// CopyLogs writes a job's output logs to stdout and stderr. With follow, it
+// keeps copying new output until the job stops running
+func (s *Store) CopyLogs(job *Job, stdout, stderr io.Writer, follow bool) error {
+	var stdoutOffset, stderrOffset int64
+	for {
+		running := job.Running()
+
+		var err error
+		if stdoutOffset, err = copyFrom(job.StdoutPath(), stdoutOffset, stdout); err != nil {
+			return err
+		}
+		if stderrOffset, err = copyFrom(job.StderrPath(), stderrOffset, stderr); err != nil {
+			return err
+		}
+		if !follow || !running {
+			return nil
+		}
+
+		time.Sleep(pollInterval)
+		if job, err = s.load(job.ID); err != nil {
+			return err
+		}
+	}
+}
+
+// copyFrom copies a file from offset to w and returns the new offset. A file
+// that does not exist yet is treated as empty
+func copyFrom(path string, offset int64, w io.Writer) (int64, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return offset, nil
+	}
+	if err != nil {
+		return offset, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+	n, err := io.Copy(w, file)
+	return offset + n, err
+}
@@ -1,15 +1,60 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"execute_command/audit"
+	"execute_command/client"
 	"execute_command/executor"
+	"execute_command/history"
+	"execute_command/jobs"
+	"execute_command/metrics"
 	"execute_command/parser"
+	"execute_command/policy"
+	"execute_command/redact"
+	"execute_command/secrets"
+	"execute_command/server"
+	"execute_command/signing"
+	"execute_command/templating"
+	"execute_command/tracing"
 	"execute_command/utils"
 )
 
+// Process exit codes
+const (
+	exitCodeError             = 1 // Command failed, timed out or could not be run
+	exitCodeExpectationFailed = 2 // Command ran but did not meet its expectations
+	exitCodePolicyDenied      = 3 // Command was denied by the policy
+)
+
 func main() {
+	started := time.Now()
+
+	// Act as the resource limit helper when re-executed by an executor
+	if executor.IsLimitsHelper(os.Args) {
+		executor.RunLimitsHelper(os.Args)
+	}
+
+	// Act as the supervisor of a background job when started by execute -detach
+	var jobID string
+	if jobs.IsSupervisor(os.Args) {
+		jobID, os.Args = jobs.SupervisorArgs(os.Args)
+	}
+
 	// Parse command line configuration
 	config, err := parser.ParseConfig()
 	if err != nil {
@@ -19,9 +64,28 @@ func main() {
 	}
 
 	// Initialize global logger
-	utils.InitGlobalLogger(config.LogLevel)
+	var sinks []utils.Sink
+	for _, sinkConfig := range config.LogOutputs {
+		sink, err := utils.OpenSink(sinkConfig)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		sinks = append(sinks, sink)
+	}
+	utils.InitGlobalLoggerWithSinks(config.LogLevel, sinks)
+	defer utils.CloseGlobalLogger()
+	for pattern, level := range config.ModuleLevels {
+		utils.SetModuleLevel(pattern, level)
+	}
 	logger := utils.GetModuleLogger("main")
 
+	// Mask secrets before anything is logged or recorded
+	if err := setupRedaction(config); err != nil {
+		logger.Error("%v", err)
+		utils.Exit(1)
+	}
+
 	// Show help if requested
 	if config.Help {
 		parser.PrintUsage()
@@ -32,11 +96,106 @@ func main() {
 	if err := config.ValidateAction(); err != nil {
 		logger.Error("%v", err)
 		parser.PrintUsage()
-		os.Exit(1)
+		utils.Exit(1)
 	}
 
+	parsed := time.Now()
 	logger.Info("Starting Command Executor")
 
+	// Load the command policy enforced by every executor
+	if config.PolicyFile != "" {
+		commandPolicy, err := policy.LoadPolicy(config.PolicyFile)
+		if err != nil {
+			logger.Error("%v", err)
+			utils.Exit(exitCodeError)
+		}
+		executor.SetPolicy(commandPolicy)
+		logger.Info("Loaded policy with %d rules (default: %s)", len(commandPolicy.Rules), commandPolicy.Default)
+	}
+
+	// Expose metrics while serving or running a command
+	if config.MetricsListen != "" {
+		startMetrics(config)
+	}
+
+	// Rerun a past execution with its command, executor and shell
+	var rerunOf int64
+	if config.Action == "rerun" {
+		entry, err := rerunEntry(config)
+		if err != nil {
+			logger.Error("%v", err)
+			utils.Exit(exitCodeError)
+		}
+		if len(entry.Redactions) > 0 {
+			logger.Error("History entry %d had secrets masked (%s) and can't be rerun", entry.ID, strings.Join(entry.Redactions, ", "))
+			utils.Exit(exitCodeError)
+		}
+		if strings.Contains(entry.DisplayCommand(), secrets.EnvPrefix) {
+			logger.Error("History entry %d references secrets and can't be rerun; run its template again with the secrets", entry.ID)
+			utils.Exit(exitCodeError)
+		}
+		logger.Info("Rerunning history entry %d: %s", entry.ID, entry.DisplayCommand())
+		rerunOf = entry.ID
+		config.Action = "execute"
+		config.Args = []string{"execute", entry.Command}
+		config.ExecutorType = executor.ParseExecutorType(entry.Executor)
+		config.ShellType = executor.ParseShellType(entry.Shell)
+		config.Template = false // Already rendered when it first ran
+	}
+
+	// Run a signed bundle's command with the executor and shell it was signed for
+	var signature *signing.SignatureInfo
+	if config.Action == "execute" && config.Remote == "" && (config.Bundle != "" || config.RequireSignature) {
+		signature = acceptBundle(config)
+	}
+
+	// Send the output of a background job to its logs
+	var supervisor *jobs.Supervisor
+	if jobID != "" {
+		store, err := jobStore()
+		if err == nil {
+			supervisor, err = store.Attach(jobID)
+		}
+		if err != nil {
+			logger.Error("Failed to attach to job %s: %v", jobID, err)
+			utils.Exit(exitCodeError)
+		}
+		config.Detach = false
+		logger = logger.With("job_id", jobID)
+		config.ExecOptions.LogFields = utils.Fields{"job_id": jobID}
+		config.ExecOptions.Context = supervisor.Context()
+		config.ExecOptions.Stdout = supervisor.Stdout()
+		config.ExecOptions.Stderr = supervisor.Stderr()
+	}
+
+	// Trace the execution, joining the caller's trace from TRACEPARENT
+	tracer := newTracer(config)
+	var trace *tracing.Span
+	if config.Action == "execute" && !config.Detach {
+		parent, err := tracing.EnvironmentParent()
+		if err != nil {
+			logger.Warn("Ignoring %s: %v", tracing.TraceparentEnv, err)
+		}
+		trace = tracer.StartAt("execute", parent, started)
+		trace.ChildAt("parse", started).EndAt(parsed)
+		trace.SetAttribute("executor.type", config.ExecutorType.String())
+		if supervisor != nil {
+			trace.SetAttribute("job.id", supervisor.Job().ID)
+		}
+		if rerunOf != 0 {
+			trace.SetAttribute("history.rerun_of", rerunOf)
+		}
+		config.ExecOptions.Trace = trace
+		if trace != nil {
+			traceID := trace.Context().TraceID.String()
+			logger = logger.With("trace_id", traceID)
+			if config.ExecOptions.LogFields == nil {
+				config.ExecOptions.LogFields = utils.Fields{}
+			}
+			config.ExecOptions.LogFields["trace_id"] = traceID
+		}
+	}
+
 	// Create executor factory and get executor with specified type and shell
 	factory := executor.NewExecutorFactory()
 
@@ -46,13 +205,14 @@ func main() {
 		shellType = executor.PowerShellShell
 	}
 
-	cmdExecutor := factory.CreateExecutorWithShell(config.ExecutorType, shellType)
+	cmdExecutor := factory.CreateExecutorWithOptions(config.ExecutorType, shellType, config.ExecOptions)
 
 	// Display system information
 	sysInfo := executor.GetSystemInfo()
 	logger.Info("Running on %s/%s", sysInfo.OS, sysInfo.Arch)
 	logger.Info("Using shell: %s", shellType.String())
 	logger.Info("Using executor: %s", config.ExecutorType.String())
+	trace.SetAttribute("shell", executor.ResolveShellType(shellType).String())
 
 	// Execute action
 	action := config.Action
@@ -60,11 +220,81 @@ func main() {
 	switch action {
 	case "execute":
 		command := config.GetCommand()
+		resolver := secretResolver(config)
+		if config.Template {
+			span := trace.Child("template")
+			rendered, err := renderCommand(config, command, shellType, func(name string) (string, error) {
+				if _, err := resolver.Resolve(name); err != nil {
+					return "", err
+				}
+				return secrets.Reference(name, shellType), nil
+			})
+			span.RecordError(err)
+			span.End()
+			if err != nil {
+				logger.Error("%v", err)
+				trace.RecordError(err)
+				trace.End()
+				utils.Exit(exitCodeError)
+			}
+			command = rendered
+		}
+		if err := injectSecrets(config, resolver); err != nil {
+			logger.Error("%v", err)
+			trace.RecordError(err)
+			trace.End()
+			utils.Exit(exitCodeError)
+		}
+		if len(config.ExecOptions.Env) > 0 || config.SecretStdin != "" {
+			cmdExecutor = factory.CreateExecutorWithOptions(config.ExecutorType, shellType, config.ExecOptions)
+		}
+		if config.Detach {
+			startJob(config, cmdExecutor, command, shellType)
+			return
+		}
+		if config.Remote != "" {
+			runRemote(config, command, trace)
+			return
+		}
 		logger.Debug("Executing command: %s", command)
-		err := cmdExecutor.ExecuteCommand(command)
+		metrics.ExecutionStarted()
+		result, err := cmdExecutor.Execute(command)
+		metrics.ExecutionEnded()
+		metrics.ObserveExecution(config.ExecutorType.String(), executor.ResolveShellType(shellType).String(),
+			executionStatus(result, err), result)
+		if result != nil && len(result.Redactions) > 0 {
+			logger.Info("Masked secrets in the command or output (rules: %s)", strings.Join(result.Redactions, ", "))
+		}
+		record := trace.Child("record")
+		auditExecution(config, cmdExecutor, command, shellType, nil, signature, result, err)
+		var jobID string
+		if supervisor != nil {
+			jobID = supervisor.Job().ID
+			finishJob(supervisor, result, err)
+		}
+		recordHistory(config, cmdExecutor, command, shellType, result, err, rerunOf, jobID)
+		record.End()
+		finishTrace(trace, result, err)
+		if result != nil && len(result.Attempts) > 1 {
+			printAttemptReport(result)
+		}
+		if result != nil && result.LastAttempt().Cgroup != nil {
+			printCgroupUsage(result.LastAttempt().Cgroup)
+		}
+		if config.Stats && result != nil && result.LastAttempt().Usage != nil {
+			printResourceUsage(result.LastAttempt().Usage)
+		}
 		if err != nil {
 			logger.Error("Error executing command: %v", err)
-			os.Exit(1)
+			var denied *policy.DeniedError
+			if errors.As(err, &denied) {
+				utils.Exit(exitCodePolicyDenied)
+			}
+			if result != nil && result.Status() == executor.StatusExpectationFailed {
+				printExpectationFailures(result.LastAttempt())
+				utils.Exit(exitCodeExpectationFailed)
+			}
+			utils.Exit(exitCodeError)
 		}
 
 	case "encode":
@@ -80,21 +310,1232 @@ func main() {
 		decoded, err := cmdExecutor.DecodeCommand(encoded)
 		if err != nil {
 			logger.Error("Error decoding: %v", err)
-			os.Exit(1)
+			utils.Exit(1)
 		}
 		fmt.Printf("Decoded command: %s\n", decoded)
 		logger.Info("Command decoded successfully")
 
+	case "explain":
+		command := config.GetCommand()
+		if config.Template {
+			// Secrets are left as references; explaining needs no values
+			rendered, err := renderCommand(config, command, shellType, func(name string) (string, error) {
+				return secrets.Reference(name, shellType), nil
+			})
+			if err != nil {
+				logger.Error("%v", err)
+				utils.Exit(exitCodeError)
+			}
+			command = rendered
+		}
+		if config.ExecutorType == executor.Base64Type {
+			decoded, err := cmdExecutor.DecodeCommand(command)
+			if err != nil {
+				logger.Error("Error decoding: %v", err)
+				utils.Exit(exitCodeError)
+			}
+			command = decoded
+		}
+		decision := executor.EvaluatePolicy(command, config.ExecutorType, shellType)
+		printPolicyDecision(command, decision)
+		if !decision.Allowed {
+			utils.Exit(exitCodePolicyDenied)
+		}
+
 	case "info":
 		logger.Info("Displaying system information")
 		printSystemInfo()
 
+	case "redact":
+		if err := runRedact(config); err != nil {
+			logger.Error("%v", err)
+			utils.Exit(exitCodeError)
+		}
+
+	case "secrets":
+		if err := runSecrets(config); err != nil {
+			logger.Error("%v", err)
+			utils.Exit(exitCodeError)
+		}
+
+	case "keygen":
+		keyID, err := signing.GenerateKey(config.Args[1])
+		if err != nil {
+			logger.Error("%v", err)
+			utils.Exit(exitCodeError)
+		}
+		fmt.Printf("Private key: %s\n", config.Args[1])
+		fmt.Printf("Public key:  %s.pub (copy it to the trusted keys directory of the hosts)\n", config.Args[1])
+		fmt.Printf("Key ID:      %s\n", keyID)
+
+	case "sign":
+		if err := runSign(config); err != nil {
+			logger.Error("%v", err)
+			utils.Exit(exitCodeError)
+		}
+
+	case "verify":
+		runVerify(config)
+
+	case "audit":
+		path, err := config.AuditLogPath()
+		if err != nil {
+			logger.Error("Failed to locate audit log: %v", err)
+			utils.Exit(exitCodeError)
+		}
+		if path == "" {
+			logger.Error("No audit log to verify: the audit log is disabled (-audit-log %s)", config.AuditLog)
+			utils.Exit(exitCodeError)
+		}
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			logger.Error("No audit log to verify: %s does not exist", path)
+			utils.Exit(exitCodeError)
+		}
+		report, err := audit.Verify(path)
+		if err != nil {
+			logger.Error("Error verifying audit log: %v", err)
+			utils.Exit(exitCodeError)
+		}
+		printAuditReport(path, report)
+		if !report.OK() {
+			utils.Exit(exitCodeError)
+		}
+
+	case "jobs", "status", "logs", "wait", "kill":
+		runJobAction(config)
+
+	case "history":
+		runHistoryAction(config)
+
+	case "serve":
+		runServer(config, shellType, tracer)
+
 	default:
 		logger.Warn("Unknown action: %s", action)
 		parser.PrintUsage()
 	}
 }
 
+// auditExecution appends a record of an execute action to the audit log. The
+// caller is set for commands submitted to the server (nil for local runs),
+// the signature for signed bundles
+func auditExecution(config *parser.Config, cmdExecutor executor.CommandExecutor, command string,
+	shellType executor.ShellType, caller *policy.Caller, signature *signing.SignatureInfo,
+	result *executor.ExecutionResult, execErr error) {
+	logger := utils.GetModuleLogger("main")
+
+	path, err := config.AuditLogPath()
+	if err != nil {
+		logger.Error("Failed to locate audit log: %v", err)
+		return
+	}
+	if path == "" {
+		return
+	}
+
+	record := audit.NewRecord("execute")
+	record.Executor = config.ExecutorType.String()
+	record.Shell = executor.ResolveShellType(shellType).String()
+	record.Command = command
+	record.ExitCode = -1
+	if caller != nil && caller.User != "" {
+		record.Caller = caller.User
+	}
+	if caller.Known() {
+		record.CallerUID = &caller.UID
+		record.CallerGID = &caller.GID
+		record.CallerPID = caller.PID
+	}
+	if signature != nil {
+		record.Signer = signature.Signer
+		record.SignerKeyID = signature.KeyID
+		record.BundleNonce = signature.Nonce
+	}
+
+	record.Status = executionStatus(result, execErr)
+	if result != nil {
+		record.Command = result.Command
+		record.DecodedCommand = result.DecodedCommand
+		record.Redactions = result.Redactions
+		record.ExitCode = result.ExitCode()
+		record.DurationMs = result.TotalDuration().Milliseconds()
+		record.Attempts = len(result.Attempts)
+		record.RunAsUser = result.User
+		if result.UID >= 0 {
+			record.RunAsUID = &result.UID
+			record.RunAsGID = &result.GID
+		}
+		if last := result.LastAttempt(); last != nil {
+			record.StdoutSHA256 = audit.HashOutput(last.Stdout)
+			record.StderrSHA256 = audit.HashOutput(last.Stderr)
+			record.LimitExceeded = last.LimitExceeded
+		}
+	} else if config.ExecutorType == executor.Base64Type {
+		// The command never ran (denied or undecodable); record what was requested
+		record.DecodedCommand, _ = cmdExecutor.DecodeCommand(command)
+	}
+	if result == nil {
+		var rules []string
+		record.Command, rules = executor.RedactCommand(cmdExecutor, command)
+		record.DecodedCommand, _ = executor.Redactor(cmdExecutor).RedactWithRules(record.DecodedCommand)
+		record.Redactions = rules
+	}
+	if execErr != nil {
+		record.Error = executor.Redactor(cmdExecutor).Redact(execErr.Error())
+	}
+
+	if err := audit.NewLogger(path).Append(record); err != nil {
+		logger.Error("Failed to write audit record: %v", err)
+	}
+}
+
+// executionStatus returns the status of an execution, or "denied" /
+// "rejected" / "error" if the command never ran
+func executionStatus(result *executor.ExecutionResult, execErr error) string {
+	if result != nil {
+		return result.Status().String()
+	}
+	var denied *policy.DeniedError
+	if errors.As(execErr, &denied) {
+		return "denied"
+	}
+	var rejected *signing.RejectedError
+	if errors.As(execErr, &rejected) {
+		return "rejected"
+	}
+	return "error"
+}
+
+// jobStore opens the background job store in the state directory
+func jobStore() (*jobs.Store, error) {
+	dir, err := jobs.DefaultDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate job directory: %v", err)
+	}
+	return jobs.NewStore(dir), nil
+}
+
+// startJob starts the command as a background job run by a detached supervisor
+func startJob(config *parser.Config, cmdExecutor executor.CommandExecutor, command string, shellType executor.ShellType) {
+	logger := utils.GetModuleLogger("main")
+
+	job := &jobs.Job{
+		Command:  command,
+		Executor: config.ExecutorType.String(),
+		Shell:    executor.ResolveShellType(shellType).String(),
+	}
+	if config.ExecutorType == executor.Base64Type && command != "" {
+		decoded, err := cmdExecutor.DecodeCommand(command)
+		if err != nil {
+			logger.Error("Error decoding: %v", err)
+			utils.Exit(exitCodeError)
+		}
+		job.DecodedCommand = decoded
+	}
+
+	// Report denials here rather than in the job; the supervisor checks again
+	if command != "" {
+		checked := command
+		if job.DecodedCommand != "" {
+			checked = job.DecodedCommand
+		}
+		if err := executor.ValidateCommandFor(checked, config.ExecutorType, shellType); err != nil {
+			auditExecution(config, cmdExecutor, command, shellType, nil, nil, nil, err)
+			recordHistory(config, cmdExecutor, command, shellType, nil, err, 0, "")
+			logger.Error("Error executing command: %v", err)
+			var denied *policy.DeniedError
+			if errors.As(err, &denied) {
+				utils.Exit(exitCodePolicyDenied)
+			}
+			utils.Exit(exitCodeError)
+		}
+	}
+
+	// The job's record shows the command masked; the supervisor gets it as given
+	job.Command, _ = executor.RedactCommand(cmdExecutor, command)
+	job.DecodedCommand = redact.Default.Redact(job.DecodedCommand)
+
+	store, err := jobStore()
+	if err == nil {
+		err = store.Start(job, os.Args[1:])
+	}
+	if err != nil {
+		logger.Error("Failed to start background job: %v", err)
+		utils.Exit(exitCodeError)
+	}
+
+	logger.Info("Started background job %s (supervisor pid %d)", job.ID, job.PID)
+	fmt.Printf("Job %s started (pid %d)\n", job.ID, job.PID)
+	fmt.Printf("  Output: %s\n", job.Dir())
+}
+
+// finishJob records the outcome of a background job run by this supervisor
+func finishJob(supervisor *jobs.Supervisor, result *executor.ExecutionResult, execErr error) {
+	exitCode := -1
+	if result != nil {
+		exitCode = result.ExitCode()
+	}
+	var errText string
+	if execErr != nil {
+		errText = redact.Default.Redact(execErr.Error())
+	}
+	if err := supervisor.Finish(executionStatus(result, execErr), exitCode, errText); err != nil {
+		utils.GetModuleLogger("main").Error("Failed to record job outcome: %v", err)
+	}
+}
+
+// runJobAction runs the jobs, status, logs, wait and kill actions
+func runJobAction(config *parser.Config) {
+	logger := utils.GetModuleLogger("main")
+
+	store, err := jobStore()
+	if err != nil {
+		logger.Error("%v", err)
+		utils.Exit(exitCodeError)
+	}
+
+	if config.Action == "jobs" {
+		list, err := store.List()
+		if err != nil {
+			logger.Error("%v", err)
+			utils.Exit(exitCodeError)
+		}
+		printJobs(list)
+		return
+	}
+
+	job, err := store.Get(config.Args[1])
+	if err != nil {
+		logger.Error("%v", err)
+		utils.Exit(exitCodeError)
+	}
+
+	switch config.Action {
+	case "status":
+		printJobStatus(job)
+	case "logs":
+		if err := store.CopyLogs(job, os.Stdout, os.Stderr, config.Follow); err != nil {
+			logger.Error("Failed to read job output: %v", err)
+			utils.Exit(exitCodeError)
+		}
+	case "wait":
+		if job, err = store.Wait(job); err != nil {
+			logger.Error("%v", err)
+			utils.Exit(exitCodeError)
+		}
+		printJobStatus(job)
+		utils.Exit(jobExitCode(job))
+	case "kill":
+		if job, err = store.Kill(job, 10*time.Second); err != nil {
+			logger.Error("%v", err)
+			utils.Exit(exitCodeError)
+		}
+		fmt.Printf("Job %s killed\n", job.ID)
+	}
+}
+
+// jobExitCode maps the outcome of a finished job to the exit code execute would have used
+func jobExitCode(job *jobs.Job) int {
+	return statusExitCode(job.Status)
+}
+
+// statusExitCode maps an execution status to the exit code of the execute action
+func statusExitCode(status string) int {
+	switch status {
+	case executor.StatusSuccess.String():
+		return 0
+	case executor.StatusExpectationFailed.String():
+		return exitCodeExpectationFailed
+	case "denied":
+		return exitCodePolicyDenied
+	default:
+		return exitCodeError
+	}
+}
+
+func printJobs(list []*jobs.Job) {
+	if len(list) == 0 {
+		fmt.Println("No background jobs")
+		return
+	}
+	fmt.Printf("%-8s  %-8s  %-7s  %-19s  %-10s  %-4s  %s\n", "ID", "STATE", "PID", "STARTED", "DURATION", "EXIT", "COMMAND")
+	for _, job := range list {
+		exitCode := "-"
+		if !job.Running() && job.Status != "" {
+			exitCode = fmt.Sprintf("%d", job.ExitCode)
+		}
+		fmt.Printf("%-8s  %-8s  %-7d  %-19s  %-10s  %-4s  %s\n",
+			job.ID, job.State.String(), job.PID, job.StartTime.Local().Format("2006-01-02 15:04:05"),
+			job.Duration().Round(time.Second).String(), exitCode, jobCommand(job))
+	}
+}
+
+func printJobStatus(job *jobs.Job) {
+	fmt.Printf("Job %s\n", job.ID)
+	fmt.Printf("  State:     %s\n", job.State.String())
+	fmt.Printf("  PID:       %d\n", job.PID)
+	fmt.Printf("  Command:   %s\n", jobCommand(job))
+	fmt.Printf("  Executor:  %s (%s)\n", job.Executor, job.Shell)
+	fmt.Printf("  Work dir:  %s\n", job.WorkDir)
+	fmt.Printf("  Started:   %s\n", job.StartTime.Local().Format(time.RFC3339))
+	if job.EndTime != nil {
+		fmt.Printf("  Ended:     %s\n", job.EndTime.Local().Format(time.RFC3339))
+	}
+	fmt.Printf("  Duration:  %v\n", job.Duration().Round(time.Millisecond))
+	if job.Status != "" {
+		fmt.Printf("  Status:    %s\n", job.Status)
+		fmt.Printf("  Exit code: %d\n", job.ExitCode)
+	}
+	if job.Error != "" {
+		fmt.Printf("  Error:     %s\n", job.Error)
+	}
+	fmt.Printf("  Output:    %s\n", job.Dir())
+}
+
+// jobCommand returns the command of a job for display
+func jobCommand(job *jobs.Job) string {
+	switch {
+	case job.DecodedCommand != "":
+		return job.DecodedCommand
+	case job.Command != "":
+		return job.Command
+	default:
+		return "(default command)"
+	}
+}
+
+// historyStore opens the execution history, or returns nil if it is disabled
+func historyStore(config *parser.Config) (*history.Store, error) {
+	dir, err := config.HistoryPath()
+	if err != nil || dir == "" {
+		return nil, err
+	}
+	return history.NewStore(dir, config.Retention), nil
+}
+
+// recordHistory stores an execute action and its output in the history
+func recordHistory(config *parser.Config, cmdExecutor executor.CommandExecutor, command string,
+	shellType executor.ShellType, result *executor.ExecutionResult, execErr error, rerunOf int64, jobID string) {
+	logger := utils.GetModuleLogger("main")
+
+	store, err := historyStore(config)
+	if err != nil {
+		logger.Error("Failed to locate history: %v", err)
+		return
+	}
+	if store == nil {
+		return
+	}
+
+	entry := &history.Entry{
+		Time:     time.Now().UTC(),
+		Command:  command,
+		Executor: config.ExecutorType.String(),
+		Shell:    executor.ResolveShellType(shellType).String(),
+		Status:   executionStatus(result, execErr),
+		ExitCode: -1,
+		RerunOf:  rerunOf,
+		JobID:    jobID,
+	}
+	if current, err := user.Current(); err == nil {
+		entry.User = current.Username
+	}
+	entry.WorkDir, _ = os.Getwd()
+
+	var output history.Output
+	if result != nil {
+		entry.Command = result.Command
+		entry.DecodedCommand = result.DecodedCommand
+		entry.Redactions = result.Redactions
+		entry.ExitCode = result.ExitCode()
+		entry.DurationMs = result.TotalDuration().Milliseconds()
+		entry.Attempts = len(result.Attempts)
+		if len(result.Attempts) > 0 {
+			entry.Time = result.Attempts[0].StartTime.UTC()
+		}
+		if last := result.LastAttempt(); last != nil {
+			output.Stdout = last.Stdout
+			output.Stderr = last.Stderr
+		}
+	} else if config.ExecutorType == executor.Base64Type {
+		entry.DecodedCommand, _ = cmdExecutor.DecodeCommand(command)
+	}
+	if result == nil {
+		entry.Command, entry.Redactions = executor.RedactCommand(cmdExecutor, command)
+		entry.DecodedCommand = executor.Redactor(cmdExecutor).Redact(entry.DecodedCommand)
+	}
+	if execErr != nil {
+		entry.Error = executor.Redactor(cmdExecutor).Redact(execErr.Error())
+	}
+
+	if err := store.Add(entry, output); err != nil {
+		logger.Error("Failed to record history: %v", err)
+	}
+}
+
+// rerunEntry loads the history entry named by the rerun action
+func rerunEntry(config *parser.Config) (*history.Entry, error) {
+	id, err := strconv.ParseInt(config.Args[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid history ID: %s", config.Args[1])
+	}
+	store, err := historyStore(config)
+	if err != nil {
+		return nil, err
+	}
+	if store == nil {
+		return nil, fmt.Errorf("history is disabled")
+	}
+	return store.Get(id)
+}
+
+// runHistoryAction lists past executions or shows one with its output
+func runHistoryAction(config *parser.Config) {
+	logger := utils.GetModuleLogger("main")
+
+	store, err := historyStore(config)
+	if err == nil && store == nil {
+		err = fmt.Errorf("history is disabled")
+	}
+	if err != nil {
+		logger.Error("%v", err)
+		utils.Exit(exitCodeError)
+	}
+
+	if len(config.Args) == 1 {
+		entries, err := store.List(config.Filter)
+		if err != nil {
+			logger.Error("%v", err)
+			utils.Exit(exitCodeError)
+		}
+		printHistory(entries)
+		return
+	}
+
+	id, err := strconv.ParseInt(config.Args[2], 10, 64)
+	if err != nil {
+		logger.Error("Invalid history ID: %s", config.Args[2])
+		utils.Exit(exitCodeError)
+	}
+	entry, err := store.Get(id)
+	if err != nil {
+		logger.Error("%v", err)
+		utils.Exit(exitCodeError)
+	}
+	output, err := store.Output(id)
+	if err != nil {
+		logger.Error("%v", err)
+		utils.Exit(exitCodeError)
+	}
+	printHistoryEntry(entry, output)
+}
+
+func printHistory(entries []*history.Entry) {
+	if len(entries) == 0 {
+		fmt.Println("No executions found")
+		return
+	}
+	fmt.Printf("%-5s  %-19s  %-18s  %-4s  %-9s  %-13s  %s\n", "ID", "TIME", "STATUS", "EXIT", "DURATION", "EXECUTOR", "COMMAND")
+	for _, entry := range entries {
+		fmt.Printf("%-5d  %-19s  %-18s  %-4d  %-9s  %-13s  %s\n",
+			entry.ID, entry.Time.Local().Format("2006-01-02 15:04:05"), entry.Status, entry.ExitCode,
+			(time.Duration(entry.DurationMs) * time.Millisecond).String(), entry.Executor+"/"+entry.Shell,
+			entry.DisplayCommand())
+	}
+}
+
+func printHistoryEntry(entry *history.Entry, output *history.Output) {
+	fmt.Printf("Execution %d\n", entry.ID)
+	fmt.Printf("  Time:      %s\n", entry.Time.Local().Format(time.RFC3339))
+	fmt.Printf("  User:      %s\n", entry.User)
+	fmt.Printf("  Work dir:  %s\n", entry.WorkDir)
+	fmt.Printf("  Executor:  %s (%s)\n", entry.Executor, entry.Shell)
+	fmt.Printf("  Command:   %s\n", entry.Command)
+	if entry.DecodedCommand != "" {
+		fmt.Printf("  Decoded:   %s\n", entry.DecodedCommand)
+	}
+	fmt.Printf("  Status:    %s\n", entry.Status)
+	fmt.Printf("  Exit code: %d\n", entry.ExitCode)
+	fmt.Printf("  Duration:  %v (%d attempts)\n", time.Duration(entry.DurationMs)*time.Millisecond, entry.Attempts)
+	if entry.Error != "" {
+		fmt.Printf("  Error:     %s\n", entry.Error)
+	}
+	if entry.RerunOf != 0 {
+		fmt.Printf("  Rerun of:  %d\n", entry.RerunOf)
+	}
+	if entry.JobID != "" {
+		fmt.Printf("  Job:       %s\n", entry.JobID)
+	}
+	fmt.Println("--- stdout ---")
+	fmt.Print(output.Stdout)
+	if output.Stdout != "" && !strings.HasSuffix(output.Stdout, "\n") {
+		fmt.Println()
+	}
+	fmt.Println("--- stderr ---")
+	fmt.Print(output.Stderr)
+	if output.Stderr != "" && !strings.HasSuffix(output.Stderr, "\n") {
+		fmt.Println()
+	}
+}
+
+// runServer serves the HTTP API until the process is interrupted
+func runServer(config *parser.Config, shellType executor.ShellType, tracer *tracing.Tracer) {
+	logger := utils.GetModuleLogger("main")
+
+	token, source, err := server.ResolveToken(config.TokenFile)
+	if err != nil {
+		logger.Error("Failed to load API token: %v", err)
+		utils.Exit(exitCodeError)
+	}
+	redact.Default.AddValue("api-token", token)
+
+	// Audit and record every execution like the execute action, one at a time
+	var recordMu sync.Mutex
+	verifier, err := bundleVerifier(config)
+	if err != nil {
+		logger.Error("%v", err)
+		utils.Exit(exitCodeError)
+	}
+	if config.RequireSignature {
+		logger.Info("Only signed bundles are accepted")
+	}
+
+	api := server.New(server.Config{
+		Executor:         config.ExecutorType,
+		Shell:            shellType,
+		Options:          config.ExecOptions,
+		Token:            token,
+		Tracer:           tracer,
+		PeerAuth:         config.PeerAuth,
+		Verifier:         verifier,
+		RequireSignature: config.RequireSignature,
+		OnFinish: func(execution *server.Execution) {
+			recordMu.Lock()
+			defer recordMu.Unlock()
+			requestConfig := *config
+			requestConfig.ExecutorType = execution.ExecutorType()
+			metrics.ObserveExecution(execution.Executor, execution.Shell,
+				executionStatus(execution.Result, execution.Err()), execution.Result)
+			auditExecution(&requestConfig, execution.CommandExecutor(), execution.Command, execution.ShellType(),
+				execution.CallerIdentity(), execution.Signature(), execution.Result, execution.Err())
+			recordHistory(&requestConfig, execution.CommandExecutor(), execution.Command, execution.ShellType(),
+				execution.Result, execution.Err(), 0, "")
+		},
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if config.ServerConfig != "" {
+		if err := api.LoadConfigFile(config.ServerConfig); err != nil {
+			logger.Error("%v", err)
+			utils.Exit(exitCodeError)
+		}
+		go api.WatchConfigFile(ctx, config.ServerConfig, 2*time.Second)
+	}
+	if !server.IsLocal(config.Listen) && !api.TLSEnabled() {
+		logger.Warn("Listening on %s without TLS, which is reachable from other hosts: tokens and output are sent in clear text", config.Listen)
+	}
+	listener, err := server.Listen(config.Listen, config.Socket)
+	if err != nil {
+		logger.Error("Failed to listen on %s: %v", config.Listen, err)
+		utils.Exit(exitCodeError)
+	}
+	go func() {
+		<-ctx.Done()
+		logger.Info("Shutting down, canceling running executions")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := api.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Shutdown failed: %v", err)
+		}
+	}()
+
+	switch {
+	case api.TLSEnabled():
+		fmt.Printf("Serving API on %s with TLS (bearer token from %s)\n", config.Listen, source)
+	case config.PeerAuth && executor.IsLinux():
+		fmt.Printf("Serving API on %s (callers identified by peer credentials; bearer token from %s)\n", config.Listen, source)
+	default:
+		fmt.Printf("Serving API on %s (bearer token from %s)\n", config.Listen, source)
+	}
+	if err := api.Serve(listener); err != nil {
+		logger.Error("Server failed: %v", err)
+		utils.Exit(exitCodeError)
+	}
+}
+
+// startMetrics serves the metrics on -metrics-listen in the background,
+// separately from the API so that it can stay on a local address
+func startMetrics(config *parser.Config) {
+	logger := utils.GetModuleLogger("main")
+
+	if !server.IsLocal(config.MetricsListen) {
+		logger.Warn("Serving metrics on %s, which is reachable from other hosts", config.MetricsListen)
+	}
+	listener, err := server.Listen(config.MetricsListen, server.SocketOptions{Mode: 0600})
+	if err != nil {
+		logger.Error("Failed to listen on %s: %v", config.MetricsListen, err)
+		utils.Exit(exitCodeError)
+	}
+
+	metrics.TrackJobs(func() int {
+		store, err := jobStore()
+		if err != nil {
+			return 0
+		}
+		list, _ := store.List()
+		running := 0
+		for _, job := range list {
+			if job.Running() {
+				running++
+			}
+		}
+		return running
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+	metricsServer := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := metricsServer.Serve(listener); err != nil {
+			logger.Error("Metrics server failed: %v", err)
+		}
+	}()
+	logger.Info("Serving metrics on %s/metrics", config.MetricsListen)
+}
+
+// runRemote sends the command to a server and streams its output. The
+// server's policy and options apply and the command's stdin is empty;
+// Ctrl-C cancels the remote execution
+func runRemote(config *parser.Config, command string, trace *tracing.Span) {
+	logger := utils.GetModuleLogger("main")
+
+	token, err := client.ResolveToken(config.TokenFile)
+	if err != nil {
+		logger.Error("%v", err)
+		utils.Exit(exitCodeError)
+	}
+	api, err := client.New(config.Remote, token, config.RemoteTLS)
+	if err != nil {
+		logger.Error("%v", err)
+		utils.Exit(exitCodeError)
+	}
+	api.SetTraceparent(trace.Traceparent())
+	trace.SetAttribute("remote.address", config.Remote)
+
+	request := server.Request{
+		Command:  command,
+		Executor: config.ExecutorType.String(),
+		Shell:    config.ShellType.String(),
+	}
+	if config.Bundle != "" {
+		// The server verifies the bundle and runs its command, executor and shell
+		bundle, err := signing.LoadSignedBundle(config.Bundle)
+		if err != nil {
+			logger.Error("%v", err)
+			utils.Exit(exitCodeError)
+		}
+		request = server.Request{Bundle: bundle}
+	}
+	if config.ExecOptions.Timeout > 0 {
+		request.Timeout = config.ExecOptions.Timeout.String()
+	}
+	if len(config.ExecOptions.Env) > 0 {
+		request.Env = make(map[string]string)
+		for _, entry := range config.ExecOptions.Env {
+			name, value, _ := strings.Cut(entry, "=")
+			request.Env[name] = value
+		}
+	}
+	if config.ExecOptions.Stdin != nil {
+		stdin, err := io.ReadAll(config.ExecOptions.Stdin)
+		if err != nil {
+			logger.Error("Failed to read stdin: %v", err)
+			utils.Exit(exitCodeError)
+		}
+		request.Stdin = string(stdin)
+	}
+	execution, err := api.Submit(context.Background(), request)
+	if err != nil {
+		logger.Error("Error executing command: %v", err)
+		trace.RecordError(err)
+		trace.End()
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusForbidden {
+			utils.Exit(exitCodePolicyDenied)
+		}
+		utils.Exit(exitCodeError)
+	}
+	logger.Info("Remote execution %s started on %s", execution.ID, config.Remote)
+	trace.SetAttribute("execution.id", execution.ID)
+
+	interrupt, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-interrupt.Done()
+		logger.Info("Canceling remote execution %s", execution.ID)
+		if err := api.Cancel(context.Background(), execution.ID); err != nil {
+			logger.Warn("Failed to cancel remote execution: %v", err)
+		}
+	}()
+
+	finished, err := api.Stream(context.Background(), execution.ID, os.Stdout, os.Stderr)
+	if err != nil {
+		logger.Error("%v", err)
+		trace.RecordError(err)
+		trace.End()
+		utils.Exit(exitCodeError)
+	}
+	trace.SetAttribute("process.exit_code", finished.ExitCode)
+	trace.SetAttribute("execution.status", finished.Status)
+	if finished.Error != "" {
+		logger.Error("Error executing command: %v", finished.Error)
+		trace.RecordError(errors.New(finished.Error))
+	}
+	trace.End()
+	utils.Exit(statusExitCode(finished.Status))
+}
+
+// newTracer creates the tracer for -trace, or returns nil when tracing is off
+func newTracer(config *parser.Config) *tracing.Tracer {
+	if config.Trace == "" {
+		return nil
+	}
+	exporter, err := tracing.NewExporter(config.Trace, config.TraceEndpoint)
+	if err != nil {
+		utils.GetModuleLogger("main").Error("%v", err)
+		utils.Exit(exitCodeError)
+	}
+	return tracing.NewTracer(exporter)
+}
+
+// finishTrace tags the root span of an execution with its outcome and
+// exports the trace
+func finishTrace(trace *tracing.Span, result *executor.ExecutionResult, execErr error) {
+	trace.SetAttribute("execution.status", executionStatus(result, execErr))
+	if result != nil {
+		trace.SetAttribute("process.exit_code", result.ExitCode())
+		trace.SetAttribute("execution.attempts", len(result.Attempts))
+	}
+	trace.RecordError(execErr)
+	trace.End()
+}
+
+// renderCommand fills the command template from the environment, vars file and -var flags
+func renderCommand(config *parser.Config, command string, shellType executor.ShellType, secret templating.SecretFunc) (string, error) {
+	vars := templating.VarsFromEnvironment()
+	if config.VarsFile != "" {
+		fileVars, err := templating.LoadVarsFile(config.VarsFile)
+		if err != nil {
+			return "", err
+		}
+		vars.Merge(fileVars)
+	}
+	for _, definition := range config.Vars {
+		key, value, err := templating.ParseVar(definition)
+		if err != nil {
+			return "", err
+		}
+		vars[key] = value
+	}
+	return templating.Render(command, vars, shellType, secret)
+}
+
+// bundleVerifier returns a verifier for the trusted keys, with the nonces
+// kept in the state directory
+func bundleVerifier(config *parser.Config) (*signing.Verifier, error) {
+	keysDir, err := config.TrustedKeysPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate trusted keys: %v", err)
+	}
+	nonceDir, err := signing.DefaultNonceDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate nonce store: %v", err)
+	}
+	return signing.NewVerifier(keysDir, signing.NewNonceStore(nonceDir)), nil
+}
+
+// acceptBundle verifies the -bundle, sets the command, executor and shell
+// to the bundle's and checks the command, then uses the bundle's nonce. Like
+// the server, the nonce is used last, so a bundle that can't run yet is not
+// spent. A missing bundle under -require-signature, a bundle that must not
+// run or a denied command is audited and recorded, and the process exits
+func acceptBundle(config *parser.Config) *signing.SignatureInfo {
+	logger := utils.GetModuleLogger("main")
+
+	var bundle *signing.Bundle
+	var signature *signing.SignatureInfo
+	verifier, err := bundleVerifier(config)
+	if err == nil {
+		bundle, signature, err = verifyBundle(config, verifier)
+	}
+	if bundle != nil {
+		config.Args = []string{"execute", bundle.Command}
+		config.ExecutorType = executor.ParseExecutorType(bundle.Executor)
+		config.ShellType = executor.ParseShellType(bundle.Shell)
+	}
+	if err == nil {
+		err = checkBundleCommand(config)
+	}
+	if err == nil {
+		err = verifier.Consume(bundle, signature, time.Now())
+	}
+
+	var rejected *signing.RejectedError
+	var denied *policy.DeniedError
+	if errors.As(err, &rejected) || errors.As(err, &denied) {
+		shellType := config.ShellType
+		cmdExecutor := executor.NewExecutorFactory().CreateExecutorWithShell(config.ExecutorType, shellType)
+		command := config.GetCommand()
+		auditExecution(config, cmdExecutor, command, shellType, nil, signature, nil, err)
+		recordHistory(config, cmdExecutor, command, shellType, nil, err, 0, "")
+		logger.Error("%v", err)
+		utils.Exit(exitCodePolicyDenied)
+	}
+	if err != nil {
+		logger.Error("%v", err)
+		utils.Exit(exitCodeError)
+	}
+	logger.Info("Running bundle %s signed by %s", bundle.Nonce, signature)
+	return signature
+}
+
+// verifyBundle reads the -bundle and verifies it without using its nonce
+func verifyBundle(config *parser.Config, verifier *signing.Verifier) (*signing.Bundle, *signing.SignatureInfo, error) {
+	if config.Bundle == "" {
+		return nil, nil, &signing.RejectedError{Reason: "a signed bundle is required (-require-signature)"}
+	}
+	signed, err := signing.LoadSignedBundle(config.Bundle)
+	if err != nil {
+		return nil, nil, err
+	}
+	return verifier.Verify(signed, time.Now())
+}
+
+// checkBundleCommand checks that the command can run with the configured
+// executor and shell: it decodes base64 commands and validates them
+// against the policy, as the executor does before running them
+func checkBundleCommand(config *parser.Config) error {
+	command, shellType, err := decodeCommand(config)
+	if err != nil {
+		return err
+	}
+	return executor.ValidateCommandWith(command, config.ExecutorType, shellType, config.ExecOptions)
+}
+
+// decodeCommand returns the plaintext command and the shell it runs in. A
+// base64 command must decode for that shell
+func decodeCommand(config *parser.Config) (string, executor.ShellType, error) {
+	command := config.GetCommand()
+	shellType := config.ShellType
+	if config.ExecutorType != executor.Base64Type {
+		return command, shellType, nil
+	}
+	if shellType == executor.AutoShell && executor.IsWindows() {
+		shellType = executor.PowerShellShell
+	}
+	if executor.ResolveShellType(shellType) == executor.CMDShell {
+		return "", shellType, fmt.Errorf("base64 executor is not compatible with cmd shell")
+	}
+	decoded, err := executor.NewExecutorFactory().CreateExecutorWithShell(config.ExecutorType, shellType).DecodeCommand(command)
+	if err != nil {
+		return "", shellType, fmt.Errorf("invalid base64 command: %v", err)
+	}
+	return decoded, shellType, nil
+}
+
+// runSign prints a bundle of the command, executor and shell signed with
+// the -signing-key
+func runSign(config *parser.Config) error {
+	key, err := signing.LoadPrivateKey(config.SigningKey)
+	if err != nil {
+		return err
+	}
+	// A bundle that can't be decoded would be rejected by every host
+	if _, _, err := decodeCommand(config); err != nil {
+		return err
+	}
+	bundle, err := signing.NewBundle(config.GetCommand(), config.ExecutorType.String(), config.ShellType.String(), config.BundleTTL)
+	if err != nil {
+		return err
+	}
+	signed, err := signing.Sign(bundle, key)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(signed, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle: %v", err)
+	}
+	fmt.Println(string(data))
+	fmt.Fprintf(os.Stderr, "Signed bundle %s with key %s, expires %s\n",
+		bundle.Nonce, signed.KeyID, bundle.Expires.Local().Format(time.RFC3339))
+	return nil
+}
+
+// runVerify checks a signed bundle against the trusted keys without
+// running it or using its nonce, and prints what it would run
+func runVerify(config *parser.Config) {
+	logger := utils.GetModuleLogger("main")
+
+	signed, err := signing.LoadSignedBundle(config.Args[1])
+	if err != nil {
+		logger.Error("%v", err)
+		utils.Exit(exitCodeError)
+	}
+	verifier, err := bundleVerifier(config)
+	if err != nil {
+		logger.Error("%v", err)
+		utils.Exit(exitCodeError)
+	}
+	now := time.Now()
+	bundle, signature, err := verifier.Verify(signed, now)
+	if err == nil {
+		var used bool
+		if used, err = verifier.Used(bundle, now); err == nil && used {
+			err = &signing.RejectedError{Reason: fmt.Sprintf("bundle %s was already used (replay)", bundle.Nonce)}
+		}
+	}
+
+	if bundle != nil {
+		fmt.Printf("Command:  %s\n", bundle.Command)
+		fmt.Printf("Executor: %s\n", bundle.Executor)
+		fmt.Printf("Shell:    %s\n", bundle.Shell)
+		fmt.Printf("Signed:   %s by %s\n", bundle.Signed.Local().Format(time.RFC3339), signature)
+		fmt.Printf("Expires:  %s\n", bundle.Expires.Local().Format(time.RFC3339))
+		fmt.Printf("Nonce:    %s\n", bundle.Nonce)
+	}
+	var rejected *signing.RejectedError
+	if errors.As(err, &rejected) {
+		fmt.Printf("Result:   REJECTED (%s)\n", rejected.Reason)
+		utils.Exit(exitCodePolicyDenied)
+	}
+	if err != nil {
+		logger.Error("%v", err)
+		utils.Exit(exitCodeError)
+	}
+	fmt.Println("Result:   VALID")
+}
+
+// secretResolver returns a resolver for the -secret sources, falling back
+// to the encrypted store
+func secretResolver(config *parser.Config) *secrets.Resolver {
+	var store *secrets.Store
+	if dir, err := secrets.DefaultDir(); err == nil {
+		store = secrets.NewStore(dir)
+	}
+	return secrets.NewResolver(config.Secrets, store)
+}
+
+// injectSecrets passes the secrets resolved while rendering to the command
+// through its environment, and the -secret-stdin secret through its stdin
+func injectSecrets(config *parser.Config, resolver *secrets.Resolver) error {
+	if config.SecretStdin != "" {
+		value, err := resolver.Resolve(config.SecretStdin)
+		if err != nil {
+			return err
+		}
+		config.ExecOptions.Stdin = strings.NewReader(value + "\n")
+	}
+	if env := resolver.Env(); len(env) > 0 {
+		config.ExecOptions.Env = append(config.ExecOptions.Env, env...)
+	}
+	return nil
+}
+
+func printAttemptReport(result *executor.ExecutionResult) {
+	fmt.Fprintf(os.Stderr, "Attempts: %d (total duration: %v)\n", len(result.Attempts), result.TotalDuration())
+	for _, attempt := range result.Attempts {
+		fmt.Fprintf(os.Stderr, "  #%d %-18s exit=%d duration=%v", attempt.Number, attempt.Status.String(), attempt.ExitCode, attempt.Duration)
+		if attempt.Error != "" {
+			fmt.Fprintf(os.Stderr, " error=%q", attempt.Error)
+		}
+		if attempt.LimitExceeded != "" {
+			fmt.Fprintf(os.Stderr, " limit=%s", attempt.LimitExceeded)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+func printCgroupUsage(usage *executor.CgroupUsage) {
+	memoryPeak := "n/a"
+	if usage.MemoryPeak > 0 {
+		memoryPeak = formatBytes(usage.MemoryPeak)
+	}
+	fmt.Fprintf(os.Stderr, "Cgroup usage: memory peak %s, CPU %v (user %v, system %v)",
+		memoryPeak, usage.CPUUsage, usage.CPUUser, usage.CPUSystem)
+	if usage.OOMKills > 0 {
+		fmt.Fprintf(os.Stderr, ", OOM kills %d", usage.OOMKills)
+	}
+	if usage.PidsLimited > 0 {
+		fmt.Fprintf(os.Stderr, ", forks refused %d", usage.PidsLimited)
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+func printResourceUsage(usage *executor.ResourceUsage) {
+	fmt.Fprintf(os.Stderr, "Stats: wall %v, user %v, system %v",
+		usage.WallTime.Round(time.Millisecond), usage.UserTime, usage.SystemTime)
+	if usage.MaxRSS > 0 {
+		fmt.Fprintf(os.Stderr, ", max RSS %s, context switches %d/%d (voluntary/forced), block I/O %d/%d (in/out)",
+			formatBytes(usage.MaxRSS), usage.VoluntarySwitches, usage.ForcedSwitches, usage.BlockInputs, usage.BlockOutputs)
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := uint64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+func printExpectationFailures(attempt *executor.AttemptResult) {
+	fmt.Fprintf(os.Stderr, "Expectations not met:\n")
+	for _, failure := range attempt.ExpectationFailures {
+		fmt.Fprintf(os.Stderr, "  - %s\n", failure)
+	}
+}
+
+func printPolicyDecision(command string, decision policy.Decision) {
+	verdict := "ALLOWED"
+	if !decision.Allowed {
+		verdict = "DENIED"
+	}
+	fmt.Printf("Policy decision: %s\n", verdict)
+	fmt.Printf("  Command:  %s\n", command)
+	fmt.Printf("  Caller:   %s\n", policy.CurrentCaller())
+	fmt.Printf("  Binaries: %s\n", strings.Join(decision.Binaries, ", "))
+	if decision.Rule != nil {
+		fmt.Printf("  Rule:     %s (%s %s %q)\n", decision.Rule.Name, decision.Rule.Action, decision.Rule.Match, decision.Rule.Pattern)
+	}
+	fmt.Printf("  Reason:   %s\n", decision.Reason)
+}
+
+func printAuditReport(path string, report *audit.VerifyReport) {
+	fmt.Printf("Audit log: %s\n", path)
+	fmt.Printf("  Records: %d\n", report.Records)
+	if report.OK() {
+		fmt.Printf("  Status:  OK (hash chain intact)\n")
+		return
+	}
+	fmt.Printf("  Status:  TAMPERED (%d problems)\n", len(report.Problems))
+	for _, problem := range report.Problems {
+		fmt.Printf("  - %s\n", problem)
+	}
+}
+
+// setupRedaction configures the secrets masked in logs, audit records, the
+// history and captured output
+func setupRedaction(config *parser.Config) error {
+	redact.Default.SetEnabled(config.Redact)
+	if !config.Redact {
+		return nil
+	}
+	logger := utils.GetModuleLogger("main")
+	for _, name := range config.RedactEnv {
+		if !redact.Default.AddEnv(name) {
+			logger.Warn("Environment variable %s is marked secret but is unset or too short to mask", name)
+		}
+	}
+	redact.Default.AddEnv(server.TokenEnv)
+	if config.RedactFile != "" {
+		if err := redact.Default.AddFile(config.RedactFile); err != nil {
+			return err
+		}
+	}
+	utils.SetLogRedactor(redact.Default.Redact)
+	return nil
+}
+
+// runRedact prints the arguments of the redact action, or stdin without
+// any, with their secrets masked, and the rules that fired on stderr
+func runRedact(config *parser.Config) error {
+	text := strings.Join(config.Args[1:], " ")
+	if len(config.Args) < 2 {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %v", err)
+		}
+		text = string(data)
+	}
+	masked, rules := redact.Default.RedactWithRules(text)
+	fmt.Print(masked)
+	if !strings.HasSuffix(masked, "\n") {
+		fmt.Println()
+	}
+	if len(rules) == 0 {
+		fmt.Fprintln(os.Stderr, "No secrets found")
+	} else {
+		fmt.Fprintf(os.Stderr, "Rules fired: %s\n", strings.Join(rules, ", "))
+	}
+	return nil
+}
+
+// runSecrets lists, sets and removes the secrets in the encrypted store. The
+// value of secrets set is read from stdin, so it never appears in argv
+func runSecrets(config *parser.Config) error {
+	dir, err := secrets.DefaultDir()
+	if err != nil {
+		return fmt.Errorf("failed to locate secrets store: %v", err)
+	}
+	store := secrets.NewStore(dir)
+
+	switch config.Args[1] {
+	case "list":
+		names, err := store.Names()
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			fmt.Println("No secrets stored")
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+
+	case "set":
+		name := config.Args[2]
+		var value string
+		if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			// A terminal gives a single line; piped input is read whole
+			fmt.Fprintf(os.Stderr, "Value for %s: ", name)
+			value, err = bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("failed to read stdin: %v", err)
+			}
+		} else {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("failed to read stdin: %v", err)
+			}
+			value = string(data)
+		}
+		if err := store.Set(name, strings.TrimRight(value, "\r\n")); err != nil {
+			return err
+		}
+		fmt.Printf("Secret %s stored\n", name)
+		return nil
+
+	default:
+		name := config.Args[2]
+		if err := store.Delete(name); err != nil {
+			return err
+		}
+		fmt.Printf("Secret %s removed\n", name)
+		return nil
+	}
+}
+
 func printSystemInfo() {
 	sysInfo := executor.GetSystemInfo()
 	fmt.Printf("System Information:\n")
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"execute_command/executor"
+)
+
+// Default is the registry served by -metrics-listen
+var Default = NewRegistry()
+
+var (
+	executionsTotal = Default.NewCounter("execute_command_executions_total",
+		"Executions by executor, shell and outcome (success, failed, timeout, expectation_failed, canceled, denied, error).",
+		"executor", "shell", "outcome")
+	executionDuration = Default.NewHistogram("execute_command_execution_duration_seconds",
+		"Duration of executions, summed over every attempt.",
+		[]float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600},
+		"executor", "shell")
+	outputBytes = Default.NewHistogram("execute_command_output_bytes",
+		"Size of the output of the final attempt of an execution.",
+		[]float64{0, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216},
+		"executor", "stream")
+	attemptsTotal = Default.NewCounter("execute_command_attempts_total",
+		"Attempts made, including retries.",
+		"executor")
+	timeoutsTotal = Default.NewCounter("execute_command_timeouts_total",
+		"Attempts killed after their timeout expired.",
+		"executor")
+	policyDenialsTotal = Default.NewCounter("execute_command_policy_denials_total",
+		"Commands refused by the command policy.",
+		"executor")
+	runningExecutions = Default.NewGauge("execute_command_running_executions",
+		"Executions currently running in this process.")
+)
+
+// ExecutionStarted counts an execution as running until ExecutionEnded
+func ExecutionStarted() {
+	runningExecutions.Inc()
+}
+
+// ExecutionEnded stops counting an execution as running
+func ExecutionEnded() {
+	runningExecutions.Dec()
+}
+
+// ObserveExecution records the outcome of an execution. The outcome is the
+// execution status, or "denied" or "error" if the command never ran (result
+// is nil then)
+func ObserveExecution(executorName, shell, outcome string, result *executor.ExecutionResult) {
+	executionsTotal.Inc(executorName, shell, outcome)
+	if outcome == "denied" {
+		policyDenialsTotal.Inc(executorName)
+	}
+	if result == nil {
+		return
+	}
+
+	executionDuration.Observe(result.TotalDuration().Seconds(), executorName, shell)
+	attemptsTotal.Add(float64(len(result.Attempts)), executorName)
+	for _, attempt := range result.Attempts {
+		if attempt.TimedOut {
+			timeoutsTotal.Inc(executorName)
+		}
+	}
+	if last := result.LastAttempt(); last != nil {
+		outputBytes.Observe(float64(len(last.Stdout)), executorName, "stdout")
+		outputBytes.Observe(float64(len(last.Stderr)), executorName, "stderr")
+	}
+}
+
+// TrackJobs exposes the number of running background jobs, counted by fn
+// on every scrape
+func TrackJobs(fn func() int) {
+	Default.NewGaugeFunc("execute_command_background_jobs_running",
+		"Background jobs (execute -detach) that have not finished.",
+		func() float64 { return float64(fn()) })
+}
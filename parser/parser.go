@@ -3,33 +3,193 @@ package parser
 import (
 	"flag"
 	"fmt"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"execute_command/audit"
+	"execute_command/client"
 	"execute_command/executor"
+	"execute_command/history"
+	"execute_command/secrets"
+	"execute_command/server"
+	"execute_command/signing"
 	"execute_command/utils"
 )
 
 // Config holds all parsed configuration
 type Config struct {
-	LogLevel     utils.LogLevel
-	ShellType    executor.ShellType
-	ExecutorType executor.ExecutorType
-	Help         bool
-	Action       string
-	Args         []string
+	LogLevel         utils.LogLevel
+	LogFormat        utils.LogFormat
+	ModuleLevels     map[string]utils.LogLevel // Level overrides by module name or wildcard pattern
+	LogOutputs       []utils.SinkConfig        // Destinations of log lines (default: stdout)
+	ShellType        executor.ShellType
+	ExecutorType     executor.ExecutorType
+	Help             bool
+	Action           string
+	Args             []string
+	ExecOptions      executor.ExecutionOptions
+	Template         bool
+	Vars             []string
+	VarsFile         string
+	PolicyFile       string
+	AuditLog         string
+	Stats            bool
+	Detach           bool
+	Follow           bool
+	HistoryDir       string
+	Retention        history.Retention
+	Filter           history.Filter
+	Listen           string
+	TokenFile        string
+	Socket           server.SocketOptions
+	PeerAuth         bool // Authenticate Unix socket peers by their credentials, without a token
+	Remote           string
+	RemoteTLS        client.TLSFiles
+	ServerConfig     string
+	MetricsListen    string
+	Trace            string
+	TraceEndpoint    string
+	Redact           bool                      // Mask secrets in logs, audit records, the history and captured output
+	RedactEnv        []string                  // Environment variables whose values are secret
+	RedactFile       string                    // File of secret values, one per line
+	Secrets          map[string]secrets.Source // Sources of the secrets referenced by {{secret "name"}}
+	SecretStdin      string                    // Secret written to the command's stdin
+	Bundle           string                    // Signed bundle to execute ("-" = stdin)
+	SigningKey       string                    // Private key of the sign action
+	BundleTTL        time.Duration             // Lifetime of bundles created by sign
+	TrustedKeys      string                    // Directory of public keys whose bundles may run
+	RequireSignature bool                      // Only run signed bundles (execute and serve)
+}
+
+// stringList is a flag value that can be repeated to collect multiple strings
+type stringList []string
+
+// String returns the string representation of stringList
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ", ")
+}
+
+// Set appends a value to the list
+func (sl *stringList) Set(value string) error {
+	*sl = append(*sl, value)
+	return nil
 }
 
 // ParseConfig parses command line arguments and returns configuration
 func ParseConfig() (*Config, error) {
 	// Parse command line flags
 	var logLevel = flag.String("log-level", "ERROR", "Set logging level (DEBUG, INFO, WARN, ERROR, FATAL)")
+	var logFormat = flag.String("log-format", "text", "Log line format: text (colored on a terminal unless NO_COLOR is set) or json")
+	var logModules stringList
+	flag.Var(&logModules, "log-module", "Log level for matching modules as pattern=LEVEL, e.g. executor.*=DEBUG (repeatable)")
+	var logOutputs stringList
+	flag.Var(&logOutputs, "log-output", "Log destination: stdout, stderr or a file, with options, e.g. app.log,max-size=100M,max-backups=5,compress (repeatable)")
 	var shell = flag.String("shell", "auto", "Set shell type (auto, cmd, powershell, sh)")
 	var executorType = flag.String("executor", "base64", "Set executor type (base64, plain)")
 	var help = flag.Bool("help", false, "Show help information")
+	var timeout = flag.Duration("timeout", 0, "Timeout for each attempt (e.g. 30s, 5m; 0 = no timeout)")
+	var retries = flag.Int("retries", 0, "Number of times to retry a failed command")
+	var retryDelay = flag.Duration("retry-delay", time.Second, "Base delay before the first retry (doubled for each retry, with jitter)")
+	var retryMaxDelay = flag.Duration("retry-max-delay", 30*time.Second, "Maximum delay between retries")
+	var retryOn stringList
+	flag.Var(&retryOn, "retry-on", "Retry only on: exit=<codes>, timeout, stderr=<regex> (repeatable)")
+	var expectExit = flag.String("expect-exit", "", "Allowed exit codes, comma separated (default 0)")
+	var expectStdout = flag.String("expect-stdout", "", "Regex that stdout must match")
+	var expectNoStdout = flag.String("expect-no-stdout", "", "Regex that stdout must not match")
+	var expectStderr = flag.String("expect-stderr", "", "Regex that stderr must match")
+	var expectNoStderr = flag.String("expect-no-stderr", "", "Regex that stderr must not match")
+	var expectContains stringList
+	flag.Var(&expectContains, "expect-contains", "String that stdout must contain (repeatable)")
+	var maxDuration = flag.Duration("max-duration", 0, "Maximum duration for the command to be considered successful")
+	var templateEnabled = flag.Bool("template", false, "Treat the command as a template even without -var or -vars-file")
+	var vars stringList
+	flag.Var(&vars, "var", "Template variable as key=value (repeatable, enables templating)")
+	var varsFile = flag.String("vars-file", "", "File with template variables (key=value lines or .json, enables templating)")
+	var policyFile = flag.String("policy", "", "Policy file (JSON) with allow/deny rules enforced before execution")
+	var auditLog = flag.String("audit-log", "", "Audit log file (default: audit.jsonl in the state directory, \"off\" to disable)")
+	var historyDir = flag.String("history-dir", "", "Execution history directory (default: history in the state directory, \"off\" to disable)")
+	var historyMaxEntries = flag.Int("history-max-entries", 1000, "Maximum number of executions kept in the history (0 = unlimited)")
+	var historyMaxSize = flag.String("history-max-size", "100M", "Maximum size of the history including output, e.g. 100M (0 = unlimited)")
+	var limitCPU = flag.Uint64("limit-cpu", 0, "Maximum CPU time in seconds for the command (Linux only)")
+	var limitAS = flag.String("limit-as", "", "Maximum address space for the command, e.g. 512M (Linux only)")
+	var limitNofile = flag.Uint64("limit-nofile", 0, "Maximum open files for the command (Linux only)")
+	var limitNproc = flag.Uint64("limit-nproc", 0, "Maximum processes for the command's user (Linux only)")
+	var limitFsize = flag.String("limit-fsize", "", "Maximum size of files written by the command, e.g. 100M (Linux only)")
+	var cgroupEnabled = flag.Bool("cgroup", false, "Run each execution in its own transient cgroup v2 (Linux only)")
+	var cgroupParent = flag.String("cgroup-parent", "", "Delegated parent cgroup directory (default: the tool's own cgroup)")
+	var cgroupMemory = flag.String("cgroup-memory", "", "memory.max for the command's process tree, e.g. 512M (implies -cgroup)")
+	var cgroupCPU = flag.Float64("cgroup-cpu", 0, "cpu.max for the command's process tree in CPUs, e.g. 0.5 (implies -cgroup)")
+	var cgroupPids = flag.Uint64("cgroup-pids", 0, "pids.max for the command's process tree (implies -cgroup)")
+	var runAsUser = flag.String("user", "", "Run the command as this user (name or uid, Linux only, requires privilege)")
+	var runAsGroup = flag.String("group", "", "Run the command with this group (name or gid, default: the user's primary group)")
+	var stats = flag.Bool("stats", false, "Print a resource usage summary after the command output")
+	var listen = flag.String("listen", "127.0.0.1:8780", "Address for the serve action: host:port or unix:/path/to.sock")
+	var tokenFile = flag.String("token-file", "", "File with the API bearer token (default: $EXECUTE_COMMAND_API_TOKEN or api.token in the state directory)")
+	var socketMode = flag.String("socket-mode", "0600", "Permissions of the serve action's Unix socket, in octal (e.g. 0660 to let a group connect)")
+	var socketGroup = flag.String("socket-group", "", "Group owning the serve action's Unix socket (name or gid)")
+	var peerAuth = flag.Bool("peer-auth", false, "Let Unix socket peers of serve in without a token, identified by their credentials (Linux)")
+	var remote = flag.String("remote", "", "Send execute to a server instead of running locally: unix:///path or host:port")
+	var serverConfig = flag.String("server-config", "", "Server config file (JSON) with TLS, roles and clients for the serve action, reloaded on change")
+	var metricsListen = flag.String("metrics-listen", "", "Serve Prometheus metrics on this local address (host:port or unix:/path) during serve or execute")
+	var trace = flag.String("trace", "", "Trace executions and export the spans: otlp, stdout or a file path (JSON lines)")
+	var traceEndpoint = flag.String("trace-endpoint", "", "OTLP/HTTP collector for -trace otlp (default: $OTEL_EXPORTER_OTLP_ENDPOINT or http://localhost:4318)")
+	var redactEnabled = flag.Bool("redact", true, "Mask secrets in logs, audit records, the history and captured output (-redact=false to disable)")
+	var redactEnv stringList
+	flag.Var(&redactEnv, "redact-env", "Environment variable whose value is secret and masked wherever it appears (repeatable)")
+	var redactFile = flag.String("redact-file", "", "File of secret values to mask, one per line")
+	var secretDefs stringList
+	flag.Var(&secretDefs, "secret", "Secret for {{secret \"name\"}} as name=env:VAR or name=file:PATH (repeatable, enables templating)")
+	var secretStdin = flag.String("secret-stdin", "", "Write this secret to the command's stdin")
+	var bundle = flag.String("bundle", "", "Execute this signed bundle (file or - for stdin) with its command, executor and shell")
+	var signingKey = flag.String("signing-key", "", "Ed25519 private key (PEM) used by the sign action")
+	var bundleTTL = flag.Duration("expires", time.Hour, "Lifetime of bundles created by the sign action")
+	var trustedKeys = flag.String("trusted-keys", "", "Directory of public keys (*.pub, *.pem) whose bundles may run (default: trusted_keys in the state directory)")
+	var requireSignature = flag.Bool("require-signature", false, "Only execute signed bundles, locally and for serve requests")
+	var tlsCA = flag.String("tls-ca", "", "CA certificate to verify an https:// -remote server")
+	var tlsCert = flag.String("tls-cert", "", "Client certificate for an https:// -remote server")
+	var tlsKey = flag.String("tls-key", "", "Key of the -tls-cert client certificate")
+	var detach = flag.Bool("detach", false, "Run the command as a background job (execute only)")
+	var follow = flag.Bool("follow", false, "Keep printing new output until the job finishes (logs only)")
 	flag.Parse()
 
 	// Parse log level
 	level := utils.ParseLogLevel(*logLevel)
+	format, err := utils.ParseLogFormat(*logFormat)
+	if err != nil {
+		return nil, err
+	}
+	moduleLevels := make(map[string]utils.LogLevel)
+	for _, spec := range logModules {
+		pattern, moduleLevel, err := utils.ParseModuleLevel(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -log-module: %v", err)
+		}
+		moduleLevels[pattern] = moduleLevel
+	}
+	var sinks []utils.SinkConfig
+	for _, spec := range logOutputs {
+		sink, err := parseLogOutput(spec, format)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -log-output: %v", err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(sinks) == 0 {
+		sinks = []utils.SinkConfig{{Target: "stdout", Format: format}}
+	}
+
+	// Parse secret sources
+	secretSources := make(map[string]secrets.Source)
+	for _, definition := range secretDefs {
+		name, source, err := secrets.ParseDefinition(definition)
+		if err != nil {
+			return nil, err
+		}
+		secretSources[name] = source
+	}
 
 	// Parse shell type
 	shellType := executor.ParseShellType(*shell)
@@ -37,6 +197,80 @@ func ParseConfig() (*Config, error) {
 	// Parse executor type
 	execType := executor.ParseExecutorType(*executorType)
 
+	// Parse retry conditions
+	if *retries < 0 {
+		return nil, fmt.Errorf("retries cannot be negative")
+	}
+	var conditions []executor.RetryCondition
+	for _, value := range retryOn {
+		condition, err := executor.ParseRetryCondition(value)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+
+	// Parse expectations
+	expectations, err := parseExpectations(*expectExit, *expectStdout, *expectNoStdout, *expectStderr, *expectNoStderr)
+	if err != nil {
+		return nil, err
+	}
+	expectations.Contains = expectContains
+	expectations.MaxDuration = *maxDuration
+
+	// Parse resource limits
+	limits := executor.ResourceLimits{
+		CPUSeconds: *limitCPU,
+		OpenFiles:  *limitNofile,
+		Processes:  *limitNproc,
+	}
+	if limits.AddressSpace, err = ParseSize(*limitAS); err != nil {
+		return nil, fmt.Errorf("invalid -limit-as: %v", err)
+	}
+	if limits.FileSize, err = ParseSize(*limitFsize); err != nil {
+		return nil, fmt.Errorf("invalid -limit-fsize: %v", err)
+	}
+	if limits.IsSet() && !executor.IsLinux() {
+		return nil, fmt.Errorf("resource limits (-limit-*) are only supported on Linux")
+	}
+
+	// Parse cgroup options
+	cgroup := executor.CgroupOptions{
+		Enabled: *cgroupEnabled,
+		Parent:  *cgroupParent,
+		CPUMax:  *cgroupCPU,
+		PidsMax: *cgroupPids,
+	}
+	if cgroup.MemoryMax, err = ParseSize(*cgroupMemory); err != nil {
+		return nil, fmt.Errorf("invalid -cgroup-memory: %v", err)
+	}
+	if cgroup.CPUMax < 0 {
+		return nil, fmt.Errorf("invalid -cgroup-cpu: must be positive")
+	}
+	if cgroup.IsSet() && !executor.IsLinux() {
+		return nil, fmt.Errorf("cgroup options (-cgroup*) are only supported on Linux")
+	}
+
+	// Parse the identity to run as
+	runAs := executor.RunAs{User: *runAsUser, Group: *runAsGroup}
+	if runAs.IsSet() && !executor.IsLinux() {
+		return nil, fmt.Errorf("-user and -group are only supported on Linux")
+	}
+
+	// Parse history retention
+	retention := history.Retention{MaxEntries: *historyMaxEntries}
+	maxBytes, err := ParseSize(*historyMaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -history-max-size: %v", err)
+	}
+	retention.MaxBytes = int64(maxBytes)
+
+	// Parse the Unix socket permissions
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return nil, fmt.Errorf("invalid -socket-mode: %s (use octal permissions like 0660)", *socketMode)
+	}
+
 	// Get remaining arguments after flag parsing
 	args := flag.Args()
 
@@ -50,16 +284,238 @@ func ParseConfig() (*Config, error) {
 		action = args[0]
 	}
 
+	// Action flags may also follow the action: execute -detach, logs <id> -follow
+	var filter history.Filter
+	switch action {
+	case "execute":
+		if len(args) > 1 && isFlag(args[1], "detach") {
+			*detach = true
+			args = append(args[:1:1], args[2:]...)
+		}
+	case "logs":
+		var remaining []string
+		for _, arg := range args {
+			if isFlag(arg, "follow") {
+				*follow = true
+			} else {
+				remaining = append(remaining, arg)
+			}
+		}
+		args = remaining
+	case "history":
+		if len(args) > 1 && args[1] != "show" {
+			if filter, err = parseHistoryFilter(args[1:]); err != nil {
+				return nil, err
+			}
+			args = args[:1]
+		}
+	}
+
 	return &Config{
 		LogLevel:     level,
+		LogFormat:    format,
+		ModuleLevels: moduleLevels,
+		LogOutputs:   sinks,
 		ShellType:    shellType,
 		ExecutorType: execType,
 		Help:         *help,
 		Action:       action,
 		Args:         args,
+		ExecOptions: executor.ExecutionOptions{
+			Timeout: *timeout,
+			Retry: executor.RetryPolicy{
+				MaxRetries: *retries,
+				Delay:      *retryDelay,
+				MaxDelay:   *retryMaxDelay,
+				Conditions: conditions,
+			},
+			Expect: expectations,
+			Limits: limits,
+			Cgroup: cgroup,
+			RunAs:  runAs,
+		},
+		Template:         *templateEnabled || len(vars) > 0 || *varsFile != "" || len(secretDefs) > 0,
+		Vars:             vars,
+		VarsFile:         *varsFile,
+		PolicyFile:       *policyFile,
+		AuditLog:         *auditLog,
+		Stats:            *stats,
+		Detach:           *detach,
+		Follow:           *follow,
+		HistoryDir:       *historyDir,
+		Retention:        retention,
+		Filter:           filter,
+		Listen:           *listen,
+		TokenFile:        *tokenFile,
+		Socket:           server.SocketOptions{Mode: os.FileMode(mode), Group: *socketGroup},
+		PeerAuth:         *peerAuth,
+		Remote:           *remote,
+		RemoteTLS:        client.TLSFiles{CAFile: *tlsCA, CertFile: *tlsCert, KeyFile: *tlsKey},
+		ServerConfig:     *serverConfig,
+		MetricsListen:    *metricsListen,
+		Trace:            *trace,
+		TraceEndpoint:    *traceEndpoint,
+		Redact:           *redactEnabled,
+		RedactEnv:        redactEnv,
+		RedactFile:       *redactFile,
+		Secrets:          secretSources,
+		SecretStdin:      *secretStdin,
+		Bundle:           *bundle,
+		SigningKey:       *signingKey,
+		BundleTTL:        *bundleTTL,
+		TrustedKeys:      *trustedKeys,
+		RequireSignature: *requireSignature,
 	}, nil
 }
 
+// parseHistoryFilter parses the filter flags of the history action
+func parseHistoryFilter(args []string) (history.Filter, error) {
+	var filter history.Filter
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	since := flags.String("since", "", "Only executions at or after this time (e.g. 2h, 7d, 2006-01-02)")
+	until := flags.String("until", "", "Only executions before this time")
+	flags.StringVar(&filter.Status, "status", "", "Only executions with this status (success, failed, timeout, ...)")
+	flags.StringVar(&filter.Text, "grep", "", "Only executions whose command contains this text")
+	flags.IntVar(&filter.Limit, "limit", 20, "Show at most this many of the most recent executions (0 = all)")
+	if err := flags.Parse(args); err != nil {
+		return filter, err
+	}
+	if flags.NArg() > 0 {
+		return filter, fmt.Errorf("unexpected history argument: %s", flags.Arg(0))
+	}
+
+	now := time.Now()
+	var err error
+	if *since != "" {
+		if filter.Since, err = history.ParseTime(*since, now); err != nil {
+			return filter, fmt.Errorf("invalid -since: %v", err)
+		}
+	}
+	if *until != "" {
+		if filter.Until, err = history.ParseTime(*until, now); err != nil {
+			return filter, fmt.Errorf("invalid -until: %v", err)
+		}
+	}
+	return filter, nil
+}
+
+// isFlag checks if an argument is the given boolean flag (-name or --name)
+func isFlag(arg, name string) bool {
+	return arg == "-"+name || arg == "--"+name
+}
+
+// ParseSize parses a byte size with an optional K, M, G or T suffix (powers of 1024)
+func ParseSize(size string) (uint64, error) {
+	size = strings.TrimSpace(strings.ToUpper(size))
+	if size == "" {
+		return 0, nil
+	}
+
+	multiplier := uint64(1)
+	size = strings.TrimSuffix(size, "B")
+	for i, suffix := range []string{"K", "M", "G", "T"} {
+		if strings.HasSuffix(size, suffix) {
+			multiplier = 1 << (10 * (i + 1))
+			size = strings.TrimSuffix(size, suffix)
+			break
+		}
+	}
+
+	value, err := strconv.ParseUint(size, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q (use e.g. 4096, 512K, 256M, 1G)", size)
+	}
+	return value * multiplier, nil
+}
+
+// parseLogOutput parses a -log-output value: stdout, stderr or a file path,
+// followed by comma separated options: format=text|json, max-size=100M,
+// max-age=24h, max-backups=5 and compress. The format defaults to -log-format
+func parseLogOutput(spec string, format utils.LogFormat) (utils.SinkConfig, error) {
+	fields := strings.Split(spec, ",")
+	sink := utils.SinkConfig{Target: strings.TrimSpace(fields[0]), Format: format}
+	if sink.Target == "" {
+		return sink, fmt.Errorf("missing destination in %q", spec)
+	}
+	file := sink.Target != "stdout" && sink.Target != "stderr"
+
+	for _, field := range fields[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		if key != "format" && !file {
+			return sink, fmt.Errorf("%s only applies to files, not %s", key, sink.Target)
+		}
+		switch key {
+		case "format":
+			parsed, err := utils.ParseLogFormat(value)
+			if err != nil {
+				return sink, err
+			}
+			sink.Format = parsed
+		case "max-size":
+			size, err := ParseSize(value)
+			if err != nil {
+				return sink, err
+			}
+			sink.Rotate.MaxSize = int64(size)
+		case "max-age":
+			age, err := time.ParseDuration(value)
+			if err != nil || age < 0 {
+				return sink, fmt.Errorf("invalid max-age %q (use e.g. 24h)", value)
+			}
+			sink.Rotate.MaxAge = age
+		case "max-backups":
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 0 {
+				return sink, fmt.Errorf("invalid max-backups %q", value)
+			}
+			sink.Rotate.MaxBackups = count
+		case "compress":
+			sink.Rotate.Compress = true
+		default:
+			return sink, fmt.Errorf("unknown option %q (use format, max-size, max-age, max-backups or compress)", key)
+		}
+	}
+	return sink, nil
+}
+
+// parseExpectations parses the exit code list and output patterns of the expectation flags
+func parseExpectations(exitCodes, stdout, noStdout, stderr, noStderr string) (executor.Expectations, error) {
+	var expectations executor.Expectations
+
+	if exitCodes != "" {
+		for _, field := range strings.Split(exitCodes, ",") {
+			code, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return expectations, fmt.Errorf("invalid exit code in -expect-exit: %q", field)
+			}
+			expectations.ExitCodes = append(expectations.ExitCodes, code)
+		}
+	}
+
+	patterns := []struct {
+		flag   string
+		value  string
+		target **regexp.Regexp
+	}{
+		{"expect-stdout", stdout, &expectations.StdoutMatch},
+		{"expect-no-stdout", noStdout, &expectations.StdoutNotMatch},
+		{"expect-stderr", stderr, &expectations.StderrMatch},
+		{"expect-no-stderr", noStderr, &expectations.StderrNotMatch},
+	}
+	for _, pattern := range patterns {
+		if pattern.value == "" {
+			continue
+		}
+		compiled, err := regexp.Compile(pattern.value)
+		if err != nil {
+			return expectations, fmt.Errorf("invalid regex in -%s: %v", pattern.flag, err)
+		}
+		*pattern.target = compiled
+	}
+
+	return expectations, nil
+}
+
 // ValidateAction validates the action and its arguments
 func (c *Config) ValidateAction() error {
 	if c.Help {
@@ -71,9 +527,58 @@ func (c *Config) ValidateAction() error {
 		return err
 	}
 
+	if c.Remote != "" && c.Action != "execute" {
+		return fmt.Errorf("-remote only supports the execute action")
+	}
+
+	if c.MetricsListen != "" {
+		if c.Action != "execute" && c.Action != "serve" {
+			return fmt.Errorf("-metrics-listen only supports the execute and serve actions")
+		}
+		if c.Remote != "" || c.Detach {
+			return fmt.Errorf("-metrics-listen cannot be combined with -remote or -detach")
+		}
+		if c.Action == "serve" && c.MetricsListen == c.Listen {
+			return fmt.Errorf("-metrics-listen must differ from -listen")
+		}
+	}
+
+	if c.SecretStdin != "" && c.Action != "execute" {
+		return fmt.Errorf("-secret-stdin only supports the execute action")
+	}
+
+	if c.Bundle != "" && c.Action != "execute" {
+		return fmt.Errorf("-bundle only supports the execute action")
+	}
+	if c.RequireSignature && c.Action != "execute" && c.Action != "serve" {
+		return fmt.Errorf("-require-signature only supports the execute and serve actions")
+	}
+
+	if c.PeerAuth && (c.Action != "serve" || !strings.HasPrefix(c.Listen, "unix:")) {
+		return fmt.Errorf("-peer-auth only supports the serve action on a unix: -listen address")
+	}
+
+	if c.TraceEndpoint != "" && c.Trace != "otlp" {
+		return fmt.Errorf("-trace-endpoint needs -trace otlp")
+	}
+
 	switch c.Action {
 	case "execute":
+		if c.Remote != "" && c.Detach {
+			return fmt.Errorf("-remote cannot be combined with -detach")
+		}
+		if c.Bundle != "" {
+			if len(c.Args) > 1 {
+				return fmt.Errorf("-bundle runs the bundle's command; don't give another one")
+			}
+			if c.Template || c.Detach || c.SecretStdin != "" {
+				return fmt.Errorf("-bundle cannot be combined with templates, -secret-stdin or -detach")
+			}
+		}
 		// execute action can work without command (will use default)
+		if c.Template && c.ExecutorType == executor.Base64Type {
+			return fmt.Errorf("command templates are only supported with the plain executor")
+		}
 	case "encode":
 		if len(c.Args) < 2 {
 			return fmt.Errorf("usage: go run main.go encode <command>")
@@ -82,8 +587,67 @@ func (c *Config) ValidateAction() error {
 		if len(c.Args) < 2 {
 			return fmt.Errorf("usage: go run main.go decode <base64-encoded-command>")
 		}
+	case "explain":
+		if len(c.Args) < 2 {
+			return fmt.Errorf("usage: go run main.go [-policy file] explain <command>")
+		}
+		if c.Template && c.ExecutorType == executor.Base64Type {
+			return fmt.Errorf("command templates are only supported with the plain executor")
+		}
 	case "info":
 		// No additional arguments needed
+	case "redact":
+		// Masks the arguments, or stdin without any
+	case "keygen":
+		if len(c.Args) != 2 {
+			return fmt.Errorf("usage: go run main.go keygen <private-key-path>")
+		}
+	case "sign":
+		if len(c.Args) < 2 || c.SigningKey == "" {
+			return fmt.Errorf("usage: go run main.go -signing-key key.pem [-expires 1h] [-executor type] [-shell type] sign <command>")
+		}
+		if c.Template {
+			return fmt.Errorf("sign does not render templates; sign the final command")
+		}
+		if c.BundleTTL <= 0 {
+			return fmt.Errorf("-expires must be positive")
+		}
+	case "verify":
+		if len(c.Args) != 2 {
+			return fmt.Errorf("usage: go run main.go [-trusted-keys dir] verify <bundle-file|->")
+		}
+	case "secrets":
+		valid := len(c.Args) == 2 && c.Args[1] == "list" ||
+			len(c.Args) == 3 && (c.Args[1] == "set" || c.Args[1] == "rm")
+		if !valid {
+			return fmt.Errorf("usage: go run main.go secrets list | secrets set <name> (value from stdin) | secrets rm <name>")
+		}
+	case "audit":
+		if len(c.Args) < 2 || c.Args[1] != "verify" {
+			return fmt.Errorf("usage: go run main.go [-audit-log file] audit verify")
+		}
+	case "jobs":
+		// No additional arguments needed
+	case "status", "wait", "kill":
+		if len(c.Args) != 2 {
+			return fmt.Errorf("usage: go run main.go %s <job-id>", c.Action)
+		}
+	case "logs":
+		if len(c.Args) != 2 {
+			return fmt.Errorf("usage: go run main.go logs <job-id> [-follow]")
+		}
+	case "history":
+		if len(c.Args) > 1 && (len(c.Args) != 3 || c.Args[1] != "show") {
+			return fmt.Errorf("usage: go run main.go history [-since t] [-until t] [-status s] [-grep text] [-limit n] | history show <id>")
+		}
+	case "serve":
+		if len(c.Args) != 1 {
+			return fmt.Errorf("usage: go run main.go [-listen addr] [-server-config file] [-socket-mode mode] [-socket-group group] [-peer-auth] [-token-file path] [-metrics-listen addr] serve")
+		}
+	case "rerun":
+		if len(c.Args) != 2 {
+			return fmt.Errorf("usage: go run main.go [flags] rerun <history-id>")
+		}
 	default:
 		return fmt.Errorf("unknown action: %s", c.Action)
 	}
@@ -108,6 +672,38 @@ func (c *Config) ValidateExecutorShellCompatibility() error {
 	return nil
 }
 
+// AuditLogPath returns the audit log location, or "" if auditing is disabled
+func (c *Config) AuditLogPath() (string, error) {
+	switch strings.ToLower(c.AuditLog) {
+	case "off", "none":
+		return "", nil
+	case "":
+		return audit.DefaultPath()
+	default:
+		return c.AuditLog, nil
+	}
+}
+
+// HistoryPath returns the history directory, or "" if the history is disabled
+func (c *Config) HistoryPath() (string, error) {
+	switch strings.ToLower(c.HistoryDir) {
+	case "off", "none":
+		return "", nil
+	case "":
+		return history.DefaultDir()
+	default:
+		return c.HistoryDir, nil
+	}
+}
+
+// TrustedKeysPath returns the directory of trusted public keys
+func (c *Config) TrustedKeysPath() (string, error) {
+	if c.TrustedKeys != "" {
+		return c.TrustedKeys, nil
+	}
+	return signing.DefaultKeysDir()
+}
+
 // GetCommand returns the command string from arguments
 func (c *Config) GetCommand() string {
 	if len(c.Args) < 2 {
@@ -124,15 +720,95 @@ func PrintUsage() {
 	fmt.Println()
 	fmt.Println("Flags:")
 	fmt.Println("  -log-level string    Set logging level (DEBUG, INFO, WARN, ERROR, FATAL) (default \"INFO\")")
+	fmt.Println("  -log-format format   Log line format: text or json (text is colored on a terminal unless NO_COLOR is set)")
+	fmt.Println("  -log-output spec     Log destination: stdout (default), stderr or a file with options format=text|json,")
+	fmt.Println("                       max-size=100M, max-age=24h, max-backups=5 and compress (repeatable)")
+	fmt.Println("  -log-module spec     Log level for modules matching a pattern, e.g. executor.base64=DEBUG or executor.*=WARN (repeatable)")
 	fmt.Println("  -shell string        Set shell type (auto, cmd, powershell, sh) (default \"auto\")")
 	fmt.Println("  -executor string     Set executor type (base64, plain) (default \"base64\")")
+	fmt.Println("  -timeout duration    Timeout for each attempt, e.g. 30s (default 0 = no timeout)")
+	fmt.Println("  -retries int         Number of times to retry a failed command (default 0)")
+	fmt.Println("  -retry-delay dur     Base delay before the first retry, doubled with jitter (default 1s)")
+	fmt.Println("  -retry-max-delay dur Maximum delay between retries (default 30s)")
+	fmt.Println("  -retry-on value      Retry only on exit=<codes>, timeout or stderr=<regex> (repeatable)")
+	fmt.Println("  -expect-exit codes   Allowed exit codes, comma separated (default 0)")
+	fmt.Println("  -expect-stdout re    Regex that stdout must match")
+	fmt.Println("  -expect-no-stdout re Regex that stdout must not match")
+	fmt.Println("  -expect-stderr re    Regex that stderr must match")
+	fmt.Println("  -expect-no-stderr re Regex that stderr must not match")
+	fmt.Println("  -expect-contains str String that stdout must contain (repeatable)")
+	fmt.Println("  -max-duration dur    Maximum duration for the command to be considered successful")
+	fmt.Println("  -var key=value       Template variable (repeatable, enables templating)")
+	fmt.Println("  -vars-file path      File with template variables (key=value lines or .json)")
+	fmt.Println("  -template            Treat the command as a template (variables from EXEC_VAR_* env only)")
+	fmt.Println("  -secret name=source  Secret for {{secret \"name\"}} from env:VAR or file:PATH (repeatable, enables templating)")
+	fmt.Println("  -secret-stdin name   Write this secret to the command's stdin")
+	fmt.Println("  -bundle path         Execute a signed bundle (file or - for stdin) with its command, executor and shell")
+	fmt.Println("  -signing-key path    Ed25519 private key (PEM) for the sign action")
+	fmt.Println("  -expires duration    Lifetime of bundles created by sign (default 1h)")
+	fmt.Println("  -trusted-keys dir    Public keys whose bundles may run (default: trusted_keys in the state directory)")
+	fmt.Println("  -require-signature   Only execute signed bundles, locally and for serve requests")
+	fmt.Println("  -policy path         Policy file (JSON) with allow/deny rules enforced before execution")
+	fmt.Println("  -audit-log path      Audit log file (default: audit.jsonl in the state directory, \"off\" to disable)")
+	fmt.Println("  -limit-cpu seconds   Maximum CPU time for the command (Linux only)")
+	fmt.Println("  -limit-as size       Maximum address space for the command, e.g. 512M (Linux only)")
+	fmt.Println("  -limit-nofile n      Maximum open files for the command (Linux only)")
+	fmt.Println("  -limit-nproc n       Maximum processes for the command's user (Linux only)")
+	fmt.Println("  -limit-fsize size    Maximum size of files written by the command, e.g. 100M (Linux only)")
+	fmt.Println("  -cgroup              Run each execution in its own transient cgroup v2 (Linux only)")
+	fmt.Println("  -cgroup-parent dir   Delegated parent cgroup directory (default: the tool's own cgroup)")
+	fmt.Println("  -cgroup-memory size  memory.max for the whole process tree, e.g. 512M (implies -cgroup)")
+	fmt.Println("  -cgroup-cpu cpus     cpu.max for the whole process tree in CPUs, e.g. 0.5 (implies -cgroup)")
+	fmt.Println("  -cgroup-pids n       pids.max for the whole process tree (implies -cgroup)")
+	fmt.Println("  -user name           Run the command as this user (name or uid, Linux only, requires privilege)")
+	fmt.Println("  -group name          Run the command with this group (default: the user's primary group)")
+	fmt.Println("  -stats               Print CPU time, max RSS, context switches and block I/O after the command")
+	fmt.Println("  -history-dir dir     Execution history directory (default: history in the state directory, \"off\" to disable)")
+	fmt.Println("  -history-max-entries n Maximum number of executions kept in the history (default 1000)")
+	fmt.Println("  -history-max-size size Maximum size of the history including output (default 100M)")
+	fmt.Println("  -listen addr         Address for serve: host:port or unix:/path (default \"127.0.0.1:8780\")")
+	fmt.Println("  -token-file path     API bearer token file (default: $EXECUTE_COMMAND_API_TOKEN or generated api.token)")
+	fmt.Println("  -socket-mode mode    Permissions of the serve Unix socket in octal, e.g. 0660 (default 0600)")
+	fmt.Println("  -socket-group group  Group owning the serve Unix socket (name or gid)")
+	fmt.Println("  -peer-auth           Let Unix socket peers in without a token, identified by SO_PEERCRED (Linux);")
+	fmt.Println("                       anyone who can connect may then run what the policy allows their user")
+	fmt.Println("  -remote addr         Send execute to a server instead of running locally: unix:///path or host:port")
+	fmt.Println("  -server-config path  Server config (JSON) with TLS, roles and clients for serve, reloaded on change")
+	fmt.Println("  -metrics-listen addr Serve Prometheus metrics on /metrics at this local address during serve or execute")
+	fmt.Println("  -trace target        Trace executions to otlp, stdout or a file (JSON lines)")
+	fmt.Println("  -trace-endpoint url  OTLP/HTTP collector for -trace otlp (default http://localhost:4318)")
+	fmt.Println("  -redact              Mask secrets in logs, audit records, the history and captured output (default true)")
+	fmt.Println("  -redact-env name     Environment variable whose value is secret (repeatable)")
+	fmt.Println("  -redact-file path    File of secret values to mask, one per line")
+	fmt.Println("  -tls-ca path         CA certificate to verify an https:// -remote server")
+	fmt.Println("  -tls-cert path       Client certificate for an https:// -remote server")
+	fmt.Println("  -tls-key path        Key of the -tls-cert client certificate")
+	fmt.Println("  -detach              Run the command as a background job (also: execute -detach)")
+	fmt.Println("  -follow              Keep printing new job output until the job finishes (also: logs <id> -follow)")
 	fmt.Println("  -help               Show help information")
 	fmt.Println()
 	fmt.Println("Actions:")
 	fmt.Println("  execute [command]                 - Execute command using specified executor (uses default if no command)")
 	fmt.Println("  encode <command>                  - Encode command to base64")
 	fmt.Println("  decode <base64-command>           - Decode base64 command")
+	fmt.Println("  explain <command>                 - Show which policy rule allows or denies a command (nothing is run)")
 	fmt.Println("  info                              - Show system information")
+	fmt.Println("  redact [text]                     - Mask secrets in the text (or stdin) and list the rules that fired")
+	fmt.Println("  secrets list|set|rm [name]        - Manage the encrypted secrets store (set reads the value from stdin)")
+	fmt.Println("  keygen <path>                     - Create an Ed25519 key pair for signing bundles (path and path.pub)")
+	fmt.Println("  sign <command>                    - Print a bundle of the command, executor and shell signed with -signing-key")
+	fmt.Println("  verify <bundle>                   - Check a signed bundle against the trusted keys without running it")
+	fmt.Println("  audit verify                      - Verify the audit log hash chain")
+	fmt.Println("  execute -detach [command]         - Start the command as a background job and print its ID")
+	fmt.Println("  jobs                              - List background jobs")
+	fmt.Println("  status <job-id>                   - Show the state and outcome of a job")
+	fmt.Println("  logs <job-id> [-follow]           - Print a job's output, optionally until it finishes")
+	fmt.Println("  wait <job-id>                     - Wait for a job to finish (exits with the code execute would have)")
+	fmt.Println("  kill <job-id>                     - Kill a running job's process tree")
+	fmt.Println("  history [filters]                 - List past executions (filters: -since, -until, -status, -grep, -limit)")
+	fmt.Println("  history show <id>                 - Show a past execution with its full output")
+	fmt.Println("  rerun <id>                        - Run a past execution's command again with its executor and shell")
+	fmt.Println("  serve                             - Serve the HTTP API for submitting and tracking executions")
 	fmt.Println()
 	fmt.Println("Executor Types:")
 	fmt.Println("  base64     - Execute base64 encoded command (default)")
@@ -155,14 +831,61 @@ func PrintUsage() {
 	fmt.Println("  go run main.go encode \"dir\"")
 	fmt.Println("  go run main.go decode \"ZGly\"")
 	fmt.Println("  go run main.go info")
+	fmt.Println("  go run main.go audit verify")
+	fmt.Println("  go run main.go history -since 1d -status failed -grep backup")
+	fmt.Println("  go run main.go -timeout 5m rerun 42")
+	fmt.Println("  go run main.go -executor plain -listen unix:/run/execute_command.sock serve")
+	fmt.Println("  sudo ./execute_command -policy policy.json -listen unix:/run/execute_command.sock -socket-mode 0660 -socket-group ops -peer-auth serve")
+	fmt.Println("  go run main.go -executor plain -remote unix:///run/execute_command.sock execute \"uptime\"")
+	fmt.Println("  go run main.go -listen 0.0.0.0:8443 -server-config /etc/execute_command/server.json serve")
+	fmt.Println("  go run main.go -listen 0.0.0.0:8443 -metrics-listen 127.0.0.1:9464 -server-config server.json serve")
+	fmt.Println("  go run main.go -remote https://build01:8443 -tls-ca ca.crt -tls-cert ci.crt -tls-key ci.key -executor plain execute \"make\"")
+	fmt.Println("  TRACEPARENT=00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01 go run main.go -trace otlp execute \"./deploy.sh\"")
+	fmt.Println("  go run main.go -trace traces.jsonl -executor plain execute \"make test\"")
+	fmt.Println("  GITHUB_TOKEN=... go run main.go -redact-env GITHUB_TOKEN -executor plain execute \"./release.sh\"")
+	fmt.Println("  echo \"curl -H 'Authorization: Bearer abc123' https://api\" | go run main.go redact")
+	fmt.Println("  go run main.go -policy policy.json -executor plain explain \"rm -rf /tmp/x\"")
 	fmt.Println("  go run main.go -log-level DEBUG -executor plain execute")
+	fmt.Println("  go run main.go -log-level INFO -log-format json -executor plain serve")
+	fmt.Println("  go run main.go -log-level INFO -log-module executor.base64=DEBUG execute")
+	fmt.Println("  go run main.go -log-level INFO -log-output stderr -log-output /var/log/execute_command.log,format=json,max-size=50M,max-backups=7,compress serve")
 	fmt.Println("  go run main.go -shell powershell -executor plain execute")
 	fmt.Println("  go run main.go -shell cmd -executor plain execute")
+	fmt.Println("  go run main.go -executor plain -expect-exit 0,1 -expect-contains \"active\" -max-duration 5s execute \"systemctl is-active sshd\"")
+	fmt.Println("  go run main.go -executor plain -var host=web01 execute \"ping -c 1 {{.host | quote}}\"")
+	fmt.Println("  go run main.go -executor plain -secret db=file:/run/secrets/db execute \"PGPASSWORD={{secret \\\"db\\\"}} psql -h db01\"")
+	fmt.Println("  go run main.go secrets set registry < token.txt")
+	fmt.Println("  go run main.go -executor plain -secret-stdin registry execute \"docker login -u ci --password-stdin registry.example.com\"")
+	fmt.Println("  go run main.go -signing-key ops.pem -executor plain -expires 30m sign \"systemctl restart nginx\" > restart.json")
+	fmt.Println("  go run main.go -bundle restart.json execute")
+	fmt.Println("  go run main.go -require-signature -trusted-keys /etc/execute_command/keys -listen unix:/run/execute_command.sock serve")
+	fmt.Println("  go run main.go -executor plain -limit-cpu 10 -limit-as 512M -limit-nofile 256 execute \"./batch.sh\"")
+	fmt.Println("  go run main.go -executor plain -cgroup-memory 1G -cgroup-cpu 2 -cgroup-pids 100 execute \"make -j8\"")
+	fmt.Println("  sudo ./execute_command -executor plain -user nobody execute \"id\"")
+	fmt.Println("  go run main.go -executor plain -stats execute \"make\"")
+	fmt.Println("  go run main.go -executor plain execute -detach \"./backup.sh\" && go run main.go logs <job-id> -follow")
+	fmt.Println("  go run main.go -retries 3 -retry-delay 2s -retry-on exit=1,255 -retry-on timeout -timeout 30s -executor plain execute \"curl -f http://host\"")
 	fmt.Println()
 	fmt.Println("Compatibility Matrix:")
 	fmt.Println("  Plain Executor:  cmd, powershell, sh")
 	fmt.Println("  Base64 Executor: powershell, sh")
 	fmt.Println()
+	fmt.Println("Command Templates (plain executor):")
+	fmt.Println("  Placeholders like {{.host}} are filled from -var, -vars-file and EXEC_VAR_<name> environment")
+	fmt.Println("  variables (in that order of precedence). Values are quoted for the shell the command runs in:")
+	fmt.Println("    {{.host}} {{.host | quote}} - quote for the shell the command runs in")
+	fmt.Println("    {{sh .x}} {{cmd .x}} {{powershell .x}} - quote for a specific shell")
+	fmt.Println("    {{raw .x}}              - insert the value verbatim (trusted values only)")
+	fmt.Println("  {{secret \"name\"}} inserts a reference to $EXEC_SECRET_<NAME>, which carries the value in")
+	fmt.Println("  the command's environment, so the value never appears in ps output.")
+	fmt.Println("  Unfilled variables are reported as an error before anything runs.")
+	fmt.Println()
+	fmt.Println("Exit Codes:")
+	fmt.Println("  0  - Command succeeded")
+	fmt.Println("  1  - Command failed, timed out or could not be run")
+	fmt.Println("  2  - Command ran but did not meet the -expect-* / -max-duration criteria")
+	fmt.Println("  3  - Command was denied by the policy, or its signed bundle was rejected")
+	fmt.Println()
 	fmt.Println("Note: Flags must come BEFORE the action, not after the command!")
 	fmt.Println("  Correct: go run main.go -log-level DEBUG execute \"whoami\"")
 	fmt.Println("  Wrong:   go run main.go execute \"whoami\" --log-level DEBUG")
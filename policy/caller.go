@@ -0,0 +1,122 @@
+package policy
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Caller identifies who asked for a command to run: the local user for the
+// CLI, or the peer of a Unix socket connection for the API server
+type Caller struct {
+	UID    int      // -1 if unknown (e.g. a TCP client)
+	GID    int      // -1 if unknown
+	PID    int      // Process of the peer (0 if unknown)
+	User   string   // User name, or the uid if it has no name
+	Groups []string // Names and ids of the groups the user belongs to
+}
+
+// Anonymous returns a caller whose identity is unknown. User and group
+// scoped rules never apply to it
+func Anonymous() *Caller {
+	return &Caller{UID: -1, GID: -1}
+}
+
+// Known reports whether the caller's uid is known
+func (c *Caller) Known() bool {
+	return c != nil && c.UID >= 0
+}
+
+// String returns the caller for log messages, e.g. alice (uid 1000, pid 4242)
+func (c *Caller) String() string {
+	if !c.Known() {
+		if c != nil && c.User != "" {
+			return c.User
+		}
+		return "anonymous"
+	}
+	text := c.User + " (uid " + strconv.Itoa(c.UID)
+	if c.PID > 0 {
+		text += ", pid " + strconv.Itoa(c.PID)
+	}
+	return text + ")"
+}
+
+// LookupCaller builds a caller from the credentials of a process, resolving
+// the user and group names. Lookup failures leave the numeric ids in place
+func LookupCaller(uid, gid, pid int) *Caller {
+	caller := &Caller{UID: uid, GID: gid, PID: pid, User: strconv.Itoa(uid)}
+	groupIDs := []string{strconv.Itoa(gid)}
+
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		caller.User = u.Username
+		if ids, err := u.GroupIds(); err == nil {
+			groupIDs = append(groupIDs, ids...)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, id := range groupIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		caller.Groups = append(caller.Groups, id)
+		if g, err := user.LookupGroupId(id); err == nil {
+			caller.Groups = append(caller.Groups, g.Name)
+		}
+	}
+	return caller
+}
+
+var (
+	currentOnce   sync.Once
+	currentCaller *Caller
+)
+
+// CurrentCaller returns the user running this process
+func CurrentCaller() *Caller {
+	currentOnce.Do(func() {
+		uid, gid := os.Getuid(), os.Getgid()
+		if uid < 0 {
+			// Windows has no numeric ids; match rules by user name only
+			currentCaller = Anonymous()
+			if u, err := user.Current(); err == nil {
+				currentCaller.User = u.Username
+			}
+			return
+		}
+		currentCaller = LookupCaller(uid, gid, os.Getpid())
+	})
+	return currentCaller
+}
+
+// matchesUser checks if the caller is one of the users, given by name or uid
+func (c *Caller) matchesUser(users []string) bool {
+	if c == nil {
+		return false
+	}
+	for _, u := range users {
+		if c.User != "" && strings.EqualFold(u, c.User) || c.Known() && u == strconv.Itoa(c.UID) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesGroup checks if the caller belongs to one of the groups, given by name or gid
+func (c *Caller) matchesGroup(groups []string) bool {
+	if c == nil {
+		return false
+	}
+	for _, g := range groups {
+		for _, member := range c.Groups {
+			if strings.EqualFold(g, member) {
+				return true
+			}
+		}
+	}
+	return false
+}
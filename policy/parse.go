@@ -0,0 +1,253 @@
+package policy
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// assignmentPattern matches a leading environment assignment (NAME=value)
+var assignmentPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// redirectPattern matches a redirection word (>file, 2>&1, <input)
+var redirectPattern = regexp.MustCompile(`^[0-9]*[<>]`)
+
+// wrapper describes the arguments a wrapper command takes before the command
+// it runs, so that option values are not taken for the program name
+type wrapper struct {
+	options  string   // Short options that take a value (-u root or -uroot)
+	long     []string // Long options that take a value (--user root or --user=root)
+	split    string   // Options whose value is a command line to run (env -S)
+	operands int      // Arguments before the command (timeout DURATION)
+}
+
+// wrapperCommands run the command that follows them, so the program name is
+// taken from the first word after their options and operands instead
+var wrapperCommands = map[string]wrapper{
+	"sudo": {options: "CDghpRrTtUu", long: []string{
+		"--chdir", "--chroot", "--close-from", "--command-timeout", "--group",
+		"--host", "--other-user", "--prompt", "--role", "--type", "--user",
+	}},
+	"doas":    {options: "Cu"},
+	"env":     {options: "CSu", long: []string{"--chdir", "--split-string", "--unset"}, split: "S"},
+	"exec":    {options: "a"},
+	"command": {},
+	"builtin": {},
+	"nohup":   {},
+	"setsid":  {},
+	"time":    {options: "fo", long: []string{"--format", "--output"}},
+	"nice":    {options: "n", long: []string{"--adjustment"}},
+	"ionice":  {options: "cnp", long: []string{"--class", "--classdata", "--pid"}},
+	"timeout": {options: "ks", long: []string{"--kill-after", "--signal"}, operands: 1},
+	"stdbuf":  {options: "eio", long: []string{"--error", "--input", "--output"}},
+	"chrt":    {operands: 1},
+	"taskset": {operands: 1},
+	"xargs": {options: "adEILnPs", long: []string{
+		"--arg-file", "--delimiter", "--eof", "--max-args", "--max-chars",
+		"--max-lines", "--max-procs", "--process-slot-var", "--replace",
+	}},
+}
+
+// option parses an option of the wrapper. It returns the value attached to
+// the option, whether the value is the next word instead, and whether the
+// value is a command line
+func (w wrapper) option(word string) (value string, next, split bool) {
+	if strings.HasPrefix(word, "--") {
+		name, attached, hasValue := strings.Cut(word, "=")
+		for _, long := range w.long {
+			if name == long {
+				split = name == "--split-string"
+				return attached, !hasValue, split
+			}
+		}
+		return "", false, false
+	}
+	for i, r := range word[1:] {
+		if strings.ContainsRune(w.options, r) {
+			value = word[2+i:]
+			return value, value == "", strings.ContainsRune(w.split, r)
+		}
+	}
+	return "", false, false
+}
+
+// shellKeywords are words that can precede a command in a segment
+var shellKeywords = map[string]bool{
+	"if": true, "then": true, "else": true, "elif": true, "do": true,
+	"while": true, "until": true, "!": true, "{": true, "}": true,
+}
+
+// syntax describes the quoting rules of a shell
+type syntax struct {
+	escape       rune   // Escape character (\ for sh, ` for PowerShell, ^ for cmd)
+	quotes       string // Quote characters
+	substitution bool   // Whether $( ) and backticks start command substitutions
+}
+
+// syntaxFor returns the quoting rules for a shell type name
+func syntaxFor(shell string) syntax {
+	switch shell {
+	case "cmd":
+		return syntax{escape: '^', quotes: `"`}
+	case "powershell":
+		return syntax{escape: '`', quotes: `'"`}
+	default:
+		return syntax{escape: '\\', quotes: `'"`, substitution: true}
+	}
+}
+
+// ParseBinaries returns the program name of every command segment in a
+// command line for the given shell type (sh, cmd or powershell). Segments are
+// split on unquoted ; & | newlines, parentheses, braces and command
+// substitutions; leading assignments, redirections, shell keywords and wrapper
+// commands (sudo, env, timeout, ...) with their options and operands are
+// skipped. Program names are reduced to their
+// base name without a Windows executable extension.
+//
+// This is a best-effort parse of shell syntax, intended to make policy rules
+// convenient to write, not a complete shell grammar.
+func ParseBinaries(command, shell string) []string {
+	var binaries []string
+	for _, segment := range splitSegments(command, syntaxFor(shell)) {
+		if binary := segmentBinary(segment); binary != "" {
+			binaries = append(binaries, binary)
+		}
+	}
+	return binaries
+}
+
+// splitSegments splits a command line into segments of words
+func splitSegments(command string, syn syntax) [][]string {
+	var segments [][]string
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	endSegment := func() {
+		endWord()
+		if len(words) > 0 {
+			segments = append(segments, words)
+			words = nil
+		}
+	}
+
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		// Command substitution starts a new command even inside double quotes
+		if quote != '\'' && (r == '$' && i+1 < len(runes) && runes[i+1] == '(' || syn.substitution && r == '`') {
+			quote = 0
+			if r == '$' {
+				i++
+			}
+			endSegment()
+			continue
+		}
+
+		if quote != 0 {
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+			continue
+		}
+
+		switch {
+		case strings.ContainsRune(syn.quotes, r):
+			quote = r
+			inWord = true
+		case r == syn.escape && i+1 < len(runes):
+			// Escaped character (an escaped newline is a line continuation)
+			i++
+			if runes[i] != '\n' {
+				word.WriteRune(runes[i])
+				inWord = true
+			}
+		case r == '&' && i > 0 && (runes[i-1] == '>' || runes[i-1] == '<'):
+			// Part of a redirection such as 2>&1
+			word.WriteRune(r)
+			inWord = true
+		case strings.ContainsRune(";&|\n(){}", r):
+			endSegment()
+		case r == ' ' || r == '\t' || r == '\r' || r == syn.escape:
+			endWord()
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	endSegment()
+
+	return segments
+}
+
+// segmentBinary returns the program name of a segment
+func segmentBinary(words []string) string {
+	skipRedirectTarget := false
+	skipValue := false
+	var wrapped *wrapper // Wrapper whose options and operands are being skipped
+	operands := 0
+	endOptions := false
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		switch {
+		case skipRedirectTarget:
+			skipRedirectTarget = false
+		case skipValue:
+			skipValue = false
+		case redirectPattern.MatchString(word):
+			// A bare operator (">", "2>") is followed by its target; 2>&1 is not
+			skipRedirectTarget = strings.Trim(strings.TrimLeft(word, "0123456789"), "<>&") == ""
+		case assignmentPattern.MatchString(word), shellKeywords[word]:
+		case wrapped != nil && !endOptions && word == "--":
+			endOptions = true
+		case wrapped != nil && !endOptions && len(word) > 1 && word[0] == '-':
+			value, next, split := wrapped.option(word)
+			if next && split && i+1 < len(words) {
+				i++
+				value = words[i]
+			} else if next {
+				skipValue = true
+			}
+			if split {
+				// The value is the start of the command (env -S "rm -rf /")
+				return segmentBinary(append(strings.Fields(value), words[i+1:]...))
+			}
+		case operands > 0:
+			operands--
+		default:
+			name := binaryName(word)
+			if w, ok := wrapperCommands[name]; ok {
+				wrapped, operands, endOptions = &w, w.operands, false
+				continue
+			}
+			return name
+		}
+	}
+	return ""
+}
+
+// binaryName reduces a program path to its base name without a Windows executable extension
+func binaryName(program string) string {
+	if program == "" {
+		return ""
+	}
+	name := path.Base(strings.ReplaceAll(program, `\`, "/"))
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".exe", ".com", ".bat", ".cmd"} {
+		if strings.HasSuffix(lower, ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
@@ -0,0 +1,56 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBinaries(t *testing.T) {
+	tests := []struct {
+		command, shell string
+		want           []string
+	}{
+		{"ls -la", "sh", []string{"ls"}},
+		{"/usr/bin/ls; echo hi && rm x | tee y", "sh", []string{"ls", "echo", "rm", "tee"}},
+		{"FOO=1 BAR=2 make test", "sh", []string{"make"}},
+		{"2>&1 > out.log cat file", "sh", []string{"cat"}},
+		{"if true; then rm x; fi", "sh", []string{"true", "rm", "fi"}},
+		{"echo $(rm -rf /) `id`", "sh", []string{"echo", "rm", "id"}},
+		{`echo "$(whoami)"`, "sh", []string{"echo", "whoami"}},
+		{`echo '$(whoami)'`, "sh", []string{"echo"}},
+		{`C:\Windows\System32\cmd.exe /c dir`, "cmd", []string{"cmd"}},
+		{"Get-ChildItem | Remove-Item", "powershell", []string{"Get-ChildItem", "Remove-Item"}},
+
+		// Wrappers and the values of their options
+		{"sudo rm -rf /", "sh", []string{"rm"}},
+		{"sudo -u root rm -rf /", "sh", []string{"rm"}},
+		{"sudo -uroot -g wheel rm x", "sh", []string{"rm"}},
+		{"sudo -Eu root rm x", "sh", []string{"rm"}},
+		{"sudo --user root --chdir=/tmp rm x", "sh", []string{"rm"}},
+		{"sudo -C 3 -- rm x", "sh", []string{"rm"}},
+		{"doas -u root rm x", "sh", []string{"rm"}},
+		{"nice -n 5 rm x", "sh", []string{"rm"}},
+		{"nice -n5 rm x", "sh", []string{"rm"}},
+		{"nice -5 rm x", "sh", []string{"rm"}},
+		{"env -u X rm x", "sh", []string{"rm"}},
+		{"env -i -C /tmp FOO=1 rm x", "sh", []string{"rm"}},
+		{"env -S 'rm -rf /'", "sh", []string{"rm"}},
+		{"env --split-string='rm -rf /'", "sh", []string{"rm"}},
+		{"timeout 5 rm x", "sh", []string{"rm"}},
+		{"timeout -s KILL -k 10 5s rm x", "sh", []string{"rm"}},
+		{"timeout --signal=KILL 5s rm x", "sh", []string{"rm"}},
+		{"ionice -c 3 nice -n 19 rm x", "sh", []string{"rm"}},
+		{"stdbuf -o L rm x", "sh", []string{"rm"}},
+		{"chrt -f 10 rm x", "sh", []string{"rm"}},
+		{"taskset -c 0,1 rm x", "sh", []string{"rm"}},
+		{"xargs -n 1 -I {} rm {}", "sh", []string{"rm"}},
+		{"nohup setsid exec -a name rm x", "sh", []string{"rm"}},
+		{"time -f %e rm x", "sh", []string{"rm"}},
+		{"sudo -i", "sh", nil},
+	}
+	for _, test := range tests {
+		if got := ParseBinaries(test.command, test.shell); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseBinaries(%q, %s) = %q, want %q", test.command, test.shell, got, test.want)
+		}
+	}
+}
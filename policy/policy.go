@@ -0,0 +1,247 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Action is the effect of a policy rule
+type Action string
+
+const (
+	Allow Action = "allow"
+	Deny  Action = "deny"
+)
+
+// MatchType is how a rule pattern is compared to a command
+type MatchType string
+
+const (
+	MatchExact  MatchType = "exact"  // Whole command (trimmed) equals the pattern
+	MatchGlob   MatchType = "glob"   // Whole command matches a glob (* and ?)
+	MatchRegex  MatchType = "regex"  // Command matches a regular expression
+	MatchBinary MatchType = "binary" // Program name of each command segment matches a glob
+)
+
+// Rule is a single allow or deny rule
+type Rule struct {
+	Name      string    `json:"name"`
+	Action    Action    `json:"action"`
+	Match     MatchType `json:"match"`
+	Pattern   string    `json:"pattern"`
+	Executors []string  `json:"executors,omitempty"` // Executor types the rule applies to (empty = all)
+	Shells    []string  `json:"shells,omitempty"`    // Shell types the rule applies to (empty = all)
+	Users     []string  `json:"users,omitempty"`     // Callers the rule applies to, by name or uid (empty = all)
+	Groups    []string  `json:"groups,omitempty"`    // Caller groups the rule applies to, by name or gid (empty = all)
+
+	regex *regexp.Regexp
+}
+
+// Policy is an ordered list of rules; the first rule that matches decides,
+// and the default action applies when no rule matches
+type Policy struct {
+	Default Action `json:"default"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Request describes a command to be evaluated
+type Request struct {
+	Command  string
+	Executor string
+	Shell    string
+	Caller   *Caller // Who asked for the command (nil = unknown)
+}
+
+// Decision is the result of evaluating a request
+type Decision struct {
+	Allowed  bool
+	Rule     *Rule    // Matching rule, nil if the default action applied
+	Binaries []string // Program names parsed from the command
+	Reason   string
+}
+
+// DeniedError is returned when a policy denies a command
+type DeniedError struct {
+	Decision Decision
+}
+
+// Error returns the error message for a denied command
+func (e *DeniedError) Error() string {
+	return "command denied by policy: " + e.Decision.Reason
+}
+
+// LoadPolicy loads and compiles a policy from a JSON file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %v", err)
+	}
+
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %v", path, err)
+	}
+	if err := p.Compile(); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %v", path, err)
+	}
+	return &p, nil
+}
+
+// Compile validates the policy and prepares its rules for matching
+func (p *Policy) Compile() error {
+	switch p.Default {
+	case "":
+		p.Default = Allow
+	case Allow, Deny:
+	default:
+		return fmt.Errorf("invalid default action %q (use allow or deny)", p.Default)
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if rule.Action != Allow && rule.Action != Deny {
+			return fmt.Errorf("rule %s: invalid action %q (use allow or deny)", rule.Name, rule.Action)
+		}
+
+		var expr string
+		switch rule.Match {
+		case MatchExact:
+			continue
+		case MatchRegex:
+			expr = rule.Pattern
+		case MatchGlob, MatchBinary:
+			expr = globToRegex(rule.Pattern)
+		default:
+			return fmt.Errorf("rule %s: invalid match type %q (use exact, glob, regex or binary)", rule.Name, rule.Match)
+		}
+
+		regex, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid pattern: %v", rule.Name, err)
+		}
+		rule.regex = regex
+	}
+	return nil
+}
+
+// Evaluate decides whether a request is allowed. Rules are checked in order
+// and the first one that matches decides. Binary allow rules combine: a
+// command made of several segments is allowed once every segment's program has
+// been allowed by some rule, while a binary deny rule matches if any segment's
+// program matches it
+func (p *Policy) Evaluate(request Request) Decision {
+	binaries := ParseBinaries(request.Command, request.Shell)
+	allowed := make([]bool, len(binaries))
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if !rule.appliesTo(request) || !rule.matches(request.Command, binaries, allowed) {
+			continue
+		}
+		return Decision{
+			Allowed:  rule.Action == Allow,
+			Rule:     rule,
+			Binaries: binaries,
+			Reason:   fmt.Sprintf("%s by rule %q (%s %s)", actionVerb(rule.Action), rule.Name, rule.Match, rule.Pattern),
+		}
+	}
+
+	return Decision{
+		Allowed:  p.Default == Allow,
+		Binaries: binaries,
+		Reason:   fmt.Sprintf("%s by default action (no rule matched)", actionVerb(p.Default)),
+	}
+}
+
+// appliesTo checks if the rule is scoped to the request's executor, shell
+// and caller. Rules scoped to users or groups never apply to unknown callers
+func (r *Rule) appliesTo(request Request) bool {
+	if !inScope(r.Executors, request.Executor) || !inScope(r.Shells, request.Shell) {
+		return false
+	}
+	if len(r.Users) > 0 && !request.Caller.matchesUser(r.Users) {
+		return false
+	}
+	return len(r.Groups) == 0 || request.Caller.matchesGroup(r.Groups)
+}
+
+// matches checks if the rule pattern matches the command. For binary allow
+// rules, allowed tracks which programs earlier rules already allowed; the rule
+// matches once every program is allowed
+func (r *Rule) matches(command string, binaries []string, allowed []bool) bool {
+	switch r.Match {
+	case MatchExact:
+		return strings.TrimSpace(command) == r.Pattern
+	case MatchGlob:
+		return r.regex.MatchString(strings.TrimSpace(command))
+	case MatchRegex:
+		return r.regex.MatchString(command)
+	case MatchBinary:
+		if len(binaries) == 0 {
+			return false
+		}
+		if r.Action == Deny {
+			for _, binary := range binaries {
+				if r.regex.MatchString(binary) {
+					return true
+				}
+			}
+			return false
+		}
+		complete := true
+		for i, binary := range binaries {
+			if r.regex.MatchString(binary) {
+				allowed[i] = true
+			}
+			complete = complete && allowed[i]
+		}
+		return complete
+	default:
+		return false
+	}
+}
+
+// inScope checks if a value is in the scope list (an empty list matches everything)
+func inScope(scope []string, value string) bool {
+	if len(scope) == 0 {
+		return true
+	}
+	for _, s := range scope {
+		if strings.EqualFold(s, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// globToRegex converts a glob pattern (* and ?) to an anchored regular expression
+func globToRegex(glob string) string {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			expr.WriteString("(?s:.*)")
+		case '?':
+			expr.WriteString("(?s:.)")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return expr.String()
+}
+
+// actionVerb returns the past tense of an action for decision reasons
+func actionVerb(action Action) string {
+	if action == Deny {
+		return "denied"
+	}
+	return "allowed"
+}
@@ -0,0 +1,148 @@
+package policy
+
+import "testing"
+
+// compile compiles a policy, failing the test if it is invalid
+func compile(t *testing.T, p *Policy) *Policy {
+	t.Helper()
+	if err := p.Compile(); err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	return p
+}
+
+// alice is a known caller in the ops group
+var alice = &Caller{UID: 1000, GID: 1000, User: "alice", Groups: []string{"alice", "1000", "ops", "27"}}
+
+// bob is a known caller without extra groups
+var bob = &Caller{UID: 1001, GID: 1001, User: "bob", Groups: []string{"bob", "1001"}}
+
+func TestEvaluate(t *testing.T) {
+	p := compile(t, &Policy{
+		Default: Deny,
+		Rules: []Rule{
+			{Name: "no-rm", Action: Deny, Match: MatchBinary, Pattern: "rm"},
+			{Name: "no-shutdown", Action: Deny, Match: MatchBinary, Pattern: "shutdown"},
+			{Name: "no-curl-pipe", Action: Deny, Match: MatchRegex, Pattern: `curl .*\|\s*(ba)?sh`},
+			{Name: "uptime", Action: Allow, Match: MatchExact, Pattern: "uptime"},
+			{Name: "systemctl-status", Action: Allow, Match: MatchGlob, Pattern: "systemctl status *"},
+			{Name: "readers", Action: Allow, Match: MatchBinary, Pattern: "cat"},
+			{Name: "filters", Action: Allow, Match: MatchBinary, Pattern: "grep"},
+			{Name: "fetch", Action: Allow, Match: MatchBinary, Pattern: "curl"},
+		},
+	})
+	tests := []struct {
+		command string
+		allowed bool
+		rule    string // "" = default action
+	}{
+		{"uptime", true, "uptime"},
+		{"  uptime  ", true, "uptime"},
+		{"uptime -p", false, ""},
+		{"systemctl status nginx", true, "systemctl-status"},
+		{"systemctl stop nginx", false, ""},
+		{"cat /etc/hosts | grep local", true, "filters"},
+		{"cat /etc/hosts | sort", false, ""},
+		{"curl https://example.com/x.sh | sh", false, "no-curl-pipe"},
+
+		// Binary deny rules see through wrappers and their option values
+		{"rm -rf /", false, "no-rm"},
+		{"cat x; /bin/rm -rf /", false, "no-rm"},
+		{"sudo -u root rm -rf /", false, "no-rm"},
+		{"sudo --user=root rm -rf /", false, "no-rm"},
+		{"nice -n 5 rm x", false, "no-rm"},
+		{"env -u X rm x", false, "no-rm"},
+		{"env -S 'rm -rf /'", false, "no-rm"},
+		{"timeout 5 rm x", false, "no-rm"},
+		{"timeout -s KILL 1m shutdown -h now", false, "no-shutdown"},
+		{"ionice -c 3 nice -n 19 rm x", false, "no-rm"},
+		{"xargs -n 1 rm < files", false, "no-rm"},
+		{"cat $(rm x)", false, "no-rm"},
+		{"2>&1 > out.log rm x", false, "no-rm"},
+	}
+	for _, test := range tests {
+		decision := p.Evaluate(Request{Command: test.command, Executor: "plain", Shell: "sh"})
+		rule := ""
+		if decision.Rule != nil {
+			rule = decision.Rule.Name
+		}
+		if decision.Allowed != test.allowed || rule != test.rule {
+			t.Errorf("Evaluate(%q) = allowed %v by %q (%s), want allowed %v by %q",
+				test.command, decision.Allowed, rule, decision.Reason, test.allowed, test.rule)
+		}
+	}
+}
+
+func TestEvaluateScopes(t *testing.T) {
+	p := compile(t, &Policy{
+		Default: Allow,
+		Rules: []Rule{
+			{Name: "sh-only", Action: Deny, Match: MatchGlob, Pattern: "echo *", Shells: []string{"sh"}},
+			{Name: "base64-only", Action: Deny, Match: MatchBinary, Pattern: "whoami", Executors: []string{"base64"}},
+			{Name: "alice-may-reboot", Action: Allow, Match: MatchBinary, Pattern: "reboot", Users: []string{"alice"}},
+			{Name: "uid-1001-may-reboot", Action: Allow, Match: MatchBinary, Pattern: "halt", Users: []string{"1001"}},
+			{Name: "ops-may-restart", Action: Allow, Match: MatchGlob, Pattern: "systemctl restart *", Groups: []string{"ops"}},
+			{Name: "gid-27-may-mount", Action: Allow, Match: MatchBinary, Pattern: "mount", Groups: []string{"27"}},
+			{Name: "nobody-else", Action: Deny, Match: MatchRegex, Pattern: `^(reboot|halt|mount|systemctl restart)`},
+		},
+	})
+	tests := []struct {
+		command, executor, shell string
+		caller                   *Caller
+		allowed                  bool
+	}{
+		{"echo hi", "plain", "sh", nil, false},
+		{"echo hi", "plain", "powershell", nil, true},
+		{"whoami", "base64", "sh", nil, false},
+		{"whoami", "plain", "sh", nil, true},
+		{"reboot", "plain", "sh", alice, true},
+		{"reboot", "plain", "sh", bob, false},
+		{"reboot", "plain", "sh", nil, false},
+		{"reboot", "plain", "sh", Anonymous(), false},
+		{"halt", "plain", "sh", bob, true},
+		{"halt", "plain", "sh", &Caller{UID: -1, GID: -1, User: "1001"}, true}, // A config file client named 1001
+		{"halt", "plain", "sh", alice, false},
+		{"systemctl restart nginx", "plain", "sh", alice, true},
+		{"systemctl restart nginx", "plain", "sh", bob, false},
+		{"mount /dev/sdb1 /mnt", "plain", "sh", alice, true},
+		{"mount /dev/sdb1 /mnt", "plain", "sh", bob, false},
+	}
+	for _, test := range tests {
+		decision := p.Evaluate(Request{Command: test.command, Executor: test.executor, Shell: test.shell, Caller: test.caller})
+		if decision.Allowed != test.allowed {
+			t.Errorf("Evaluate(%q, %s, %s, %v) = %v (%s), want %v",
+				test.command, test.executor, test.shell, test.caller, decision.Allowed, decision.Reason, test.allowed)
+		}
+	}
+}
+
+func TestGlobMatchesWholeCommand(t *testing.T) {
+	p := compile(t, &Policy{
+		Default: Deny,
+		Rules:   []Rule{{Action: Allow, Match: MatchGlob, Pattern: "ls ?a*"}},
+	})
+	for command, want := range map[string]bool{
+		"ls -al":      true,
+		"ls xa":       true,
+		"ls -l":       false,
+		"ls a":        false,
+		"sudo ls -al": false,
+	} {
+		if got := p.Evaluate(Request{Command: command, Shell: "sh"}).Allowed; got != want {
+			t.Errorf("Evaluate(%q) = %v, want %v", command, got, want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for name, p := range map[string]*Policy{
+		"default": {Default: "maybe"},
+		"action":  {Rules: []Rule{{Action: "block", Match: MatchExact}}},
+		"match":   {Rules: []Rule{{Action: Deny, Match: "prefix"}}},
+		"regex":   {Rules: []Rule{{Action: Deny, Match: MatchRegex, Pattern: "("}}},
+	} {
+		if err := p.Compile(); err == nil {
+			t.Errorf("%s: invalid policy compiled", name)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Package quote quotes and escapes arguments so they can be embedded safely
+// in command lines run by sh, cmd.exe or PowerShell.
+package quote
+
+import (
+	"strings"
+
+	"execute_command/executor"
+)
+
+// cmdMetaChars are the characters cmd.exe interprets before starting a program
+const cmdMetaChars = `()%!^"<>&|`
+
+// QuoteSh quotes a value as a single sh word. Values made only of characters
+// that are never special to sh are returned unchanged; anything else is wrapped
+// in single quotes, with each embedded single quote closed, escaped and reopened
+func QuoteSh(value string) string {
+	if value == "" {
+		return "''"
+	}
+	if isShSafe(value) {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// QuoteCmd quotes a value as a single argument for a program started by cmd.exe.
+// The value is first quoted following the CommandLineToArgvW rules used by most
+// Windows programs, then every cmd.exe metacharacter is escaped with ^ so that
+// cmd.exe passes it through literally
+func QuoteCmd(value string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	backslashes := 0
+	for _, r := range value {
+		switch r {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			// Backslashes before a quote must be doubled, plus one for the quote itself
+			quoted.WriteString(strings.Repeat(`\`, backslashes*2+1))
+		default:
+			quoted.WriteString(strings.Repeat(`\`, backslashes))
+		}
+		backslashes = 0
+		quoted.WriteRune(r)
+	}
+	// Backslashes before the closing quote must be doubled
+	quoted.WriteString(strings.Repeat(`\`, backslashes*2))
+	quoted.WriteByte('"')
+
+	var escaped strings.Builder
+	for _, r := range quoted.String() {
+		if strings.ContainsRune(cmdMetaChars, r) {
+			escaped.WriteByte('^')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+// QuotePowerShell quotes a value as a PowerShell verbatim (single-quoted) string.
+// Embedded single quotes, including the typographic variants PowerShell also
+// accepts as quotes, are doubled
+func QuotePowerShell(value string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('\'')
+	for _, r := range value {
+		if isPowerShellQuote(r) {
+			quoted.WriteRune(r)
+		}
+		quoted.WriteRune(r)
+	}
+	quoted.WriteByte('\'')
+	return quoted.String()
+}
+
+// Quote quotes a value for the given shell type (auto resolves based on the OS)
+func Quote(value string, shellType executor.ShellType) string {
+	switch executor.ResolveShellType(shellType) {
+	case executor.CMDShell:
+		return QuoteCmd(value)
+	case executor.PowerShellShell:
+		return QuotePowerShell(value)
+	default:
+		return QuoteSh(value)
+	}
+}
+
+// Join builds a command line from argv for the given shell type, quoting every
+// element so that the shell runs argv[0] with exactly the remaining elements as
+// arguments. For PowerShell the call operator (&) is prepended, since a quoted
+// program name would otherwise be evaluated as a string expression
+func Join(argv []string, shellType executor.ShellType) string {
+	if len(argv) == 0 {
+		return ""
+	}
+
+	shellType = executor.ResolveShellType(shellType)
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = Quote(arg, shellType)
+	}
+
+	if shellType == executor.PowerShellShell {
+		return "& " + strings.Join(quoted, " ")
+	}
+	return strings.Join(quoted, " ")
+}
+
+// isShSafe checks if a value contains only characters that sh never interprets
+func isShSafe(value string) bool {
+	for _, r := range value {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("_@%+:,./-", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isPowerShellQuote checks if a rune is treated as a single quote by PowerShell
+func isPowerShellQuote(r rune) bool {
+	return r == '\'' || r == '‘' || r == '’' || r == '‚' || r == '‛'
+}
@@ -0,0 +1,162 @@
+package quote
+
+import (
+	"bytes"
+	"math/rand"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	"execute_command/executor"
+)
+
+// alphabet holds the characters most likely to break quoting, plus plain
+// letters and non-ASCII text
+var alphabet = []string{
+	"a", "Z", "0", " ", "\t", "\n", "\r", "'", `"`, "`", "$", "\\", "!", "*", "?",
+	"[", "]", "{", "}", "(", ")", "<", ">", "|", "&", ";", "#", "~", "=", "%",
+	"-", "é", "日本", "’", "\u00a0", "😀",
+}
+
+// randomString returns a NUL-free string of up to 24 characters from the
+// alphabet or raw bytes, including invalid UTF-8
+func randomString(rng *rand.Rand) string {
+	var b strings.Builder
+	for i := rng.Intn(25); i > 0; i-- {
+		if rng.Intn(5) == 0 {
+			b.WriteByte(byte(1 + rng.Intn(255)))
+		} else {
+			b.WriteString(alphabet[rng.Intn(len(alphabet))])
+		}
+	}
+	return b.String()
+}
+
+// runSh runs a script with sh and returns its stdout
+func runSh(t *testing.T, script string) []byte {
+	t.Helper()
+	output, err := exec.Command("sh", "-c", script).Output()
+	if err != nil {
+		t.Fatalf("sh -c %q: %v", script, err)
+	}
+	return output
+}
+
+// requireSh skips tests that need a real sh
+func requireSh(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("sh round trips run on Unix only")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+}
+
+func TestQuoteShRoundTrip(t *testing.T) {
+	requireSh(t)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		value := randomString(rng)
+		output := runSh(t, "printf %s "+QuoteSh(value))
+		if !bytes.Equal(output, []byte(value)) {
+			t.Fatalf("QuoteSh(%q) = %s: sh printed %q", value, QuoteSh(value), output)
+		}
+	}
+}
+
+func TestJoinShRoundTrip(t *testing.T) {
+	requireSh(t)
+	rng := rand.New(rand.NewSource(2))
+	for i := 0; i < 300; i++ {
+		argv := make([]string, 1+rng.Intn(5))
+		for j := range argv {
+			argv[j] = randomString(rng)
+		}
+		// Arguments are NUL-terminated, since they may contain newlines
+		command := Join(append([]string{"printf", `%s\000`}, argv...), executor.ShShell)
+		output := string(runSh(t, command))
+		got := strings.Split(strings.TrimSuffix(output, "\x00"), "\x00")
+		if len(got) != len(argv) {
+			t.Fatalf("Join(%q) = %s: sh printed %q", argv, command, output)
+		}
+		for j := range argv {
+			if got[j] != argv[j] {
+				t.Fatalf("Join(%q) = %s: argument %d is %q", argv, command, j, got[j])
+			}
+		}
+	}
+}
+
+func TestQuoteShSafeValues(t *testing.T) {
+	tests := map[string]string{
+		"":              "''",
+		"plain":         "plain",
+		"/usr/bin/env":  "/usr/bin/env",
+		"key=value":     "'key=value'",
+		"it's":          `'it'\''s'`,
+		"$HOME":         "'$HOME'",
+		"a b":           "'a b'",
+		"user@host:1.0": "user@host:1.0",
+	}
+	for value, want := range tests {
+		if got := QuoteSh(value); got != want {
+			t.Errorf("QuoteSh(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
+
+func TestQuoteCmd(t *testing.T) {
+	tests := map[string]string{
+		"":             `^"^"`,
+		"hello":        `^"hello^"`,
+		"a&b":          `^"a^&b^"`,
+		"50%":          `^"50^%^"`,
+		"(x)|!y^<z>":   `^"^(x^)^|^!y^^^<z^>^"`,
+		`say "hi"`:     `^"say \^"hi\^"^"`,
+		`C:\dir\`:      `^"C:\dir\\^"`,
+		`a\"b`:         `^"a\\\^"b^"`,
+		`C:\a b\c.exe`: `^"C:\a b\c.exe^"`,
+	}
+	for value, want := range tests {
+		if got := QuoteCmd(value); got != want {
+			t.Errorf("QuoteCmd(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
+
+func TestQuotePowerShell(t *testing.T) {
+	tests := map[string]string{
+		"":             "''",
+		"plain":        "'plain'",
+		"it's":         "'it''s'",
+		"a’b‘c":        "'a’’b‘‘c'",
+		"x‚y‛z":        "'x‚‚y‛‛z'",
+		"$env:PATH `n": "'$env:PATH `n'",
+		`say "hi"`:     `'say "hi"'`,
+	}
+	for value, want := range tests {
+		if got := QuotePowerShell(value); got != want {
+			t.Errorf("QuotePowerShell(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	tests := []struct {
+		argv      []string
+		shellType executor.ShellType
+		want      string
+	}{
+		{nil, executor.ShShell, ""},
+		{[]string{"echo", "a b", "it's"}, executor.ShShell, `echo 'a b' 'it'\''s'`},
+		{[]string{"echo", "a&b"}, executor.CMDShell, `^"echo^" ^"a^&b^"`},
+		{[]string{`C:\Program Files\x.exe`, "a b"}, executor.PowerShellShell, `& 'C:\Program Files\x.exe' 'a b'`},
+	}
+	for _, test := range tests {
+		if got := Join(test.argv, test.shellType); got != test.want {
+			t.Errorf("Join(%q, %s) = %s, want %s", test.argv, test.shellType, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package redact
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask replaces every secret found in a text
+const Mask = "[REDACTED]"
+
+// minValueLength is the length below which secret values are ignored, so
+// that short values don't mask unrelated text everywhere
+const minValueLength = 4
+
+// Rule is a pattern of secrets such as bearer tokens. Replace may keep
+// parts of the match with $1-style references; the secret itself is masked
+type Rule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Replace string
+}
+
+// BuiltinRules are the common secret patterns masked by default
+var BuiltinRules = []Rule{
+	{
+		Name:    "bearer-token",
+		Pattern: regexp.MustCompile(`(?i)\b(bearer\s+)[A-Za-z0-9\-._~+/]+=*`),
+		Replace: "${1}" + Mask,
+	},
+	{
+		Name:    "aws-access-key",
+		Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+		Replace: Mask,
+	},
+	{
+		Name:    "aws-secret-key",
+		Pattern: regexp.MustCompile(`(?i)(aws_secret_access_key["']?\s*[=:]\s*["']?)[A-Za-z0-9/+=]{40}`),
+		Replace: "${1}" + Mask,
+	},
+	{
+		Name:    "url-password",
+		Pattern: regexp.MustCompile(`(\b[a-zA-Z][a-zA-Z0-9+.\-]*://[^/\s:@]+:)[^/\s@]+(@)`),
+		Replace: "${1}" + Mask + "${2}",
+	},
+	{
+		Name:    "github-token",
+		Pattern: regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`),
+		Replace: Mask,
+	},
+}
+
+// secretValue is a literal value to mask and the rule name reported for it
+type secretValue struct {
+	name  string // e.g. env:GITHUB_TOKEN
+	value string
+}
+
+// Redactor masks configured secret values and common secret patterns
+type Redactor struct {
+	mu       sync.RWMutex
+	disabled bool
+	rules    []Rule
+	values   []secretValue   // Longest first, so a value containing another is masked whole
+	env      map[string]bool // Names of environment variables marked secret
+	fired    map[string]int
+}
+
+// New creates a redactor with the built-in rules
+func New() *Redactor {
+	return &Redactor{
+		rules: append([]Rule(nil), BuiltinRules...),
+		env:   make(map[string]bool),
+		fired: make(map[string]int),
+	}
+}
+
+// Default is the redactor applied to logs, audit records, the history and
+// captured output
+var Default = New()
+
+// Clone returns a redactor with the same settings, rules and values. Values
+// added to the clone, e.g. those of one server request, don't change r
+func (r *Redactor) Clone() *Redactor {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	clone := &Redactor{
+		disabled: r.disabled,
+		rules:    r.rules,
+		values:   r.values, // Never modified in place (see AddValue)
+		env:      make(map[string]bool, len(r.env)),
+		fired:    make(map[string]int),
+	}
+	for name := range r.env {
+		clone.env[name] = true
+	}
+	return clone
+}
+
+// SetEnabled turns redaction on or off
+func (r *Redactor) SetEnabled(enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.disabled = !enabled
+}
+
+// Enabled reports whether redaction is on
+func (r *Redactor) Enabled() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return !r.disabled
+}
+
+// AddValue registers a literal secret value, reported under the name when
+// it is masked. Values shorter than 4 characters are ignored; AddValue
+// reports whether the value was added
+func (r *Redactor) AddValue(name, value string) bool {
+	if len(value) < minValueLength {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.values {
+		if existing.value == value {
+			return true
+		}
+	}
+	// Replace the slice rather than modify it: Redact reads it unlocked
+	values := make([]secretValue, len(r.values), len(r.values)+1)
+	copy(values, r.values)
+	values = append(values, secretValue{name: name, value: value})
+	sort.SliceStable(values, func(a, b int) bool {
+		return len(values[a].value) > len(values[b].value)
+	})
+	r.values = values
+	return true
+}
+
+// AddEnv marks an environment variable as secret: its current value is
+// masked as env:NAME, and so are values given to it later with AddEnvValue.
+// It reports whether the variable had a usable value
+func (r *Redactor) AddEnv(name string) bool {
+	r.mu.Lock()
+	r.env[name] = true
+	r.mu.Unlock()
+	return r.AddValue("env:"+name, os.Getenv(name))
+}
+
+// AddEnvValue masks a value given to an environment variable, e.g. in a
+// server request, if the variable is marked secret
+func (r *Redactor) AddEnvValue(name, value string) {
+	r.mu.RLock()
+	secret := r.env[name]
+	r.mu.RUnlock()
+	if secret {
+		r.AddValue("env:"+name, value)
+	}
+}
+
+// AddFile registers every line of a file as a secret value, ignoring empty
+// lines and lines starting with #
+func (r *Redactor) AddFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open redaction file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		value := strings.TrimSpace(scanner.Text())
+		if value == "" || strings.HasPrefix(value, "#") {
+			continue
+		}
+		if !r.AddValue(fmt.Sprintf("file:%s:%d", path, line), value) {
+			return fmt.Errorf("%s:%d: secret values must have at least %d characters", path, line, minValueLength)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read redaction file: %v", err)
+	}
+	return nil
+}
+
+// Redact masks the secrets in a text
+func (r *Redactor) Redact(text string) string {
+	redacted, _ := r.RedactWithRules(text)
+	return redacted
+}
+
+// RedactWithRules masks the secrets in a text and returns the names of the
+// rules that fired, in order, without duplicates
+func (r *Redactor) RedactWithRules(text string) (string, []string) {
+	if text == "" {
+		return text, nil
+	}
+	r.mu.RLock()
+	if r.disabled {
+		r.mu.RUnlock()
+		return text, nil
+	}
+	values, rules := r.values, r.rules
+	r.mu.RUnlock()
+
+	var fired []string
+	for _, secret := range values {
+		if strings.Contains(text, secret.value) {
+			text = strings.ReplaceAll(text, secret.value, Mask)
+			fired = Merge(fired, secret.name)
+		}
+	}
+	for _, rule := range rules {
+		if rule.Pattern.MatchString(text) {
+			text = rule.Pattern.ReplaceAllString(text, rule.Replace)
+			fired = append(fired, rule.Name)
+		}
+	}
+
+	if len(fired) > 0 {
+		r.mu.Lock()
+		for _, name := range fired {
+			r.fired[name]++
+		}
+		r.mu.Unlock()
+	}
+	return text, fired
+}
+
+// Fired returns how many texts each rule has masked secrets in so far
+func (r *Redactor) Fired() map[string]int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	fired := make(map[string]int, len(r.fired))
+	for name, count := range r.fired {
+		fired[name] = count
+	}
+	return fired
+}
+
+// Rules returns the names of the configured rules: the secret values, then
+// the patterns
+func (r *Redactor) Rules() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.values)+len(r.rules))
+	for _, secret := range r.values {
+		names = append(names, secret.name)
+	}
+	for _, rule := range r.rules {
+		names = append(names, rule.Name)
+	}
+	return names
+}
+
+// Merge adds the rule names in more to names, skipping the ones already there
+func Merge(names []string, more ...string) []string {
+	for _, name := range more {
+		found := false
+		for _, existing := range names {
+			if existing == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			names = append(names, name)
+		}
+	}
+	return names
+}
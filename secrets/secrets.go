@@ -0,0 +1,158 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"execute_command/executor"
+	"execute_command/redact"
+)
+
+// EnvPrefix is the prefix of the environment variables that carry secret
+// values into commands (e.g. {{secret "db-password"}} is passed as
+// EXEC_SECRET_DB_PASSWORD)
+const EnvPrefix = "EXEC_SECRET_"
+
+// EnvName returns the environment variable that carries a secret: the name
+// upper-cased with every character other than letters and digits replaced by _
+func EnvName(name string) string {
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	for _, r := range strings.ToUpper(name) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// Reference returns how a command refers to the variable carrying a secret
+// in the given shell. The value is expanded by the shell when the command
+// runs, so it never appears in the command line of any process
+func Reference(name string, shellType executor.ShellType) string {
+	variable := EnvName(name)
+	switch executor.ResolveShellType(shellType) {
+	case executor.PowerShellShell:
+		return "$env:" + variable
+	case executor.CMDShell:
+		return "%" + variable + "%"
+	default:
+		return `"$` + variable + `"`
+	}
+}
+
+// Source is where the value of a secret is loaded from
+type Source struct {
+	Kind string // env or file
+	Ref  string // Variable name or file path
+}
+
+// String returns the source as written in a definition, e.g. env:DB_PASSWORD
+func (s Source) String() string {
+	return s.Kind + ":" + s.Ref
+}
+
+// ParseDefinition parses a secret definition of the form name=env:VAR or
+// name=file:PATH
+func ParseDefinition(definition string) (string, Source, error) {
+	name, spec, found := strings.Cut(definition, "=")
+	name = strings.TrimSpace(name)
+	if !found || name == "" {
+		return "", Source{}, fmt.Errorf("invalid secret %q (expected name=env:VAR or name=file:PATH)", definition)
+	}
+	kind, ref, found := strings.Cut(spec, ":")
+	if !found || ref == "" || (kind != "env" && kind != "file") {
+		return "", Source{}, fmt.Errorf("invalid secret source %q for %s (expected env:VAR or file:PATH)", spec, name)
+	}
+	return name, Source{Kind: kind, Ref: ref}, nil
+}
+
+// Resolver loads secret values from the defined sources, falling back to
+// the encrypted store for names without a source. Every value loaded is
+// registered with the default redactor
+type Resolver struct {
+	sources map[string]Source
+	store   *Store // nil = no fallback
+
+	mu     sync.Mutex
+	loaded map[string]string
+}
+
+// NewResolver creates a resolver for the defined sources and the store
+func NewResolver(sources map[string]Source, store *Store) *Resolver {
+	return &Resolver{
+		sources: sources,
+		store:   store,
+		loaded:  make(map[string]string),
+	}
+}
+
+// Resolve returns the value of a secret
+func (r *Resolver) Resolve(name string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if value, ok := r.loaded[name]; ok {
+		return value, nil
+	}
+
+	value, err := r.load(name)
+	if err != nil {
+		return "", err
+	}
+	if value == "" {
+		return "", fmt.Errorf("secret %s is empty", name)
+	}
+	r.loaded[name] = value
+	redact.Default.AddValue("secret:"+name, value)
+	return value, nil
+}
+
+// load reads a secret from its source or the store
+func (r *Resolver) load(name string) (string, error) {
+	source, ok := r.sources[name]
+	if !ok {
+		if r.store == nil {
+			return "", fmt.Errorf("secret %s is not defined", name)
+		}
+		value, found, err := r.store.Get(name)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			return "", fmt.Errorf("secret %s is not defined (use -secret %s=env:VAR, -secret %s=file:PATH or secrets set %s)", name, name, name, name)
+		}
+		return value, nil
+	}
+
+	switch source.Kind {
+	case "env":
+		value, ok := os.LookupEnv(source.Ref)
+		if !ok {
+			return "", fmt.Errorf("secret %s: environment variable %s is not set", name, source.Ref)
+		}
+		return value, nil
+	default:
+		data, err := os.ReadFile(source.Ref)
+		if err != nil {
+			return "", fmt.Errorf("secret %s: %v", name, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+}
+
+// Env returns the KEY=VALUE variables that carry the secrets resolved so far
+func (r *Resolver) Env() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	env := make([]string, 0, len(r.loaded))
+	for name, value := range r.loaded {
+		env = append(env, EnvName(name)+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
@@ -0,0 +1,300 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"execute_command/utils"
+)
+
+// KeyEnv holds a passphrase the store key is derived from. Without it, a
+// random key is kept in a key file next to the store
+const KeyEnv = "EXECUTE_COMMAND_SECRETS_KEY"
+
+// Files in the secrets directory
+const (
+	storeFile = "secrets.enc"
+	keyFile   = "secrets.key"
+	lockFile  = "secrets.lock"
+)
+
+// Key derivation
+const (
+	kdfPassphrase = "pbkdf2-sha256"
+	kdfKeyFile    = "keyfile"
+	kdfIterations = 600000
+	keySize       = 32
+)
+
+// envelope is the on-disk form of the store: the secrets as a JSON object,
+// encrypted with AES-256-GCM
+type envelope struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+// Store is a local encrypted store of secrets
+type Store struct {
+	dir string
+}
+
+// NewStore creates a store in dir
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// DefaultDir returns the default store directory, the state directory
+func DefaultDir() (string, error) {
+	return utils.StateDir()
+}
+
+// Get returns the value of a secret and whether it exists
+func (s *Store) Get(name string) (string, bool, error) {
+	values, _, err := s.read()
+	if err != nil {
+		return "", false, err
+	}
+	value, ok := values[name]
+	return value, ok, nil
+}
+
+// Names returns the sorted names of the stored secrets
+func (s *Store) Names() ([]string, error) {
+	values, _, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Set stores a secret, replacing its previous value
+func (s *Store) Set(name, value string) error {
+	if name == "" {
+		return fmt.Errorf("secret name is empty")
+	}
+	if value == "" {
+		return fmt.Errorf("secret %s is empty", name)
+	}
+	return s.update(func(values map[string]string) error {
+		values[name] = value
+		return nil
+	})
+}
+
+// Delete removes a secret
+func (s *Store) Delete(name string) error {
+	return s.update(func(values map[string]string) error {
+		if _, ok := values[name]; !ok {
+			return fmt.Errorf("secret %s is not stored", name)
+		}
+		delete(values, name)
+		return nil
+	})
+}
+
+// update changes the secrets under the store lock and rewrites the store
+func (s *Store) update(change func(map[string]string) error) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create secrets directory: %v", err)
+	}
+	lock, err := os.OpenFile(filepath.Join(s.dir, lockFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open secrets lock: %v", err)
+	}
+	defer lock.Close()
+	if err := utils.LockFile(lock); err != nil {
+		return fmt.Errorf("failed to lock secrets: %v", err)
+	}
+	defer utils.UnlockFile(lock)
+
+	values, existing, err := s.read()
+	if err != nil {
+		return err
+	}
+	if err := change(values); err != nil {
+		return err
+	}
+	return s.write(values, existing)
+}
+
+// read decrypts the store. A missing store is empty; the envelope is nil then
+func (s *Store) read() (map[string]string, *envelope, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, storeFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read secrets: %v", err)
+	}
+
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse secrets store: %v", err)
+	}
+	if env.Version != 1 {
+		return nil, nil, fmt.Errorf("unsupported secrets store version %d", env.Version)
+	}
+	key, err := s.key(&env, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	plain, err := aead.Open(nil, env.Nonce, env.Data, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decrypt secrets store (wrong key?)")
+	}
+
+	values := map[string]string{}
+	if err := json.Unmarshal(plain, &values); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse secrets store: %v", err)
+	}
+	return values, &env, nil
+}
+
+// write encrypts the secrets with a fresh nonce and replaces the store. The
+// key derivation of an existing store is kept
+func (s *Store) write(values map[string]string, existing *envelope) error {
+	env := &envelope{Version: 1}
+	if existing != nil {
+		env.KDF, env.Salt, env.Iterations = existing.KDF, existing.Salt, existing.Iterations
+	}
+	key, err := s.key(env, true)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	plain, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("failed to encode secrets: %v", err)
+	}
+	env.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(env.Nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	env.Data = aead.Seal(nil, env.Nonce, plain, nil)
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		return fmt.Errorf("failed to encode secrets store: %v", err)
+	}
+	path := filepath.Join(s.dir, storeFile)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("failed to write secrets: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write secrets: %v", err)
+	}
+	return nil
+}
+
+// key returns the store key. A new store (no KDF yet) uses the passphrase
+// from KeyEnv if set, otherwise the key file, which is created if create is set
+func (s *Store) key(env *envelope, create bool) ([]byte, error) {
+	passphrase := os.Getenv(KeyEnv)
+	if env.KDF == "" {
+		if passphrase != "" {
+			env.KDF, env.Iterations = kdfPassphrase, kdfIterations
+			env.Salt = make([]byte, 16)
+			if _, err := rand.Read(env.Salt); err != nil {
+				return nil, fmt.Errorf("failed to generate salt: %v", err)
+			}
+		} else {
+			env.KDF = kdfKeyFile
+		}
+	}
+
+	switch env.KDF {
+	case kdfPassphrase:
+		if passphrase == "" {
+			return nil, fmt.Errorf("the secrets store is protected by a passphrase; set %s", KeyEnv)
+		}
+		return pbkdf2SHA256([]byte(passphrase), env.Salt, env.Iterations, keySize), nil
+	case kdfKeyFile:
+		return s.readKeyFile(create)
+	default:
+		return nil, fmt.Errorf("unsupported secrets key derivation %q", env.KDF)
+	}
+}
+
+// readKeyFile reads the random store key, creating it if create is set
+func (s *Store) readKeyFile(create bool) ([]byte, error) {
+	path := filepath.Join(s.dir, keyFile)
+	key, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && create {
+		key = make([]byte, keySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate secrets key: %v", err)
+		}
+		if err := os.WriteFile(path, key, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write secrets key: %v", err)
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets key: %v", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid secrets key file %s", path)
+	}
+	return key, nil
+}
+
+// newAEAD returns AES-256-GCM for a key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secrets key: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2SHA256 derives a key from a passphrase (RFC 8018, PBKDF2 with HMAC-SHA256)
+func pbkdf2SHA256(passphrase, salt []byte, iterations, size int) []byte {
+	prf := hmac.New(sha256.New, passphrase)
+	var key []byte
+	for block := uint32(1); len(key) < size; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		var counter [4]byte
+		binary.BigEndian.PutUint32(counter[:], block)
+		prf.Write(counter[:])
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:size]
+}
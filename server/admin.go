@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"execute_command/utils"
+)
+
+// LogLevels is the body of the log level admin endpoint. In a change, an
+// empty level leaves the default level as it is and removes a module override
+type LogLevels struct {
+	Level   string            `json:"level,omitempty"`   // Level of modules without an override
+	Modules map[string]string `json:"modules,omitempty"` // Overrides by module name or wildcard pattern
+}
+
+// handleLogLevels shows or changes the process's log levels. Only admins may
+// use it: the server's token, root and the user running the server
+func (s *Server) handleLogLevels(w http.ResponseWriter, r *http.Request) {
+	id := requestIdentity(r)
+	if !id.admin {
+		writeError(w, http.StatusForbidden, "log levels can only be managed by an admin")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, currentLogLevels())
+	case http.MethodPut:
+		var change LogLevels
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&change); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		if err := applyLogLevels(change); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		levels := currentLogLevels()
+		s.logger.With("caller", id.caller.String()).Warn("Log levels changed: %s", describeLogLevels(levels))
+		writeJSON(w, http.StatusOK, levels)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// applyLogLevels validates a change in full, then applies it to the global logger
+func applyLogLevels(change LogLevels) error {
+	var level utils.LogLevel
+	if change.Level != "" {
+		parsed, err := utils.ParseLogLevelStrict(change.Level)
+		if err != nil {
+			return err
+		}
+		level = parsed
+	}
+	overrides := make(map[string]utils.LogLevel)
+	for pattern, name := range change.Modules {
+		if name == "" {
+			continue
+		}
+		parsedPattern, parsed, err := utils.ParseModuleLevel(pattern + "=" + name)
+		if err != nil {
+			return err
+		}
+		overrides[parsedPattern] = parsed
+	}
+
+	if change.Level != "" {
+		utils.GetGlobalLogger().SetLevel(level)
+	}
+	for pattern, name := range change.Modules {
+		if name == "" {
+			utils.ClearModuleLevel(strings.TrimSpace(pattern))
+		}
+	}
+	for pattern, parsed := range overrides {
+		utils.SetModuleLevel(pattern, parsed)
+	}
+	return nil
+}
+
+// currentLogLevels returns the global logger's levels
+func currentLogLevels() LogLevels {
+	level, modules := utils.LogLevels()
+	levels := LogLevels{Level: level.String(), Modules: make(map[string]string, len(modules))}
+	for pattern, moduleLevel := range modules {
+		levels.Modules[pattern] = moduleLevel.String()
+	}
+	return levels
+}
+
+// describeLogLevels formats levels for a log line, e.g. INFO executor.*=DEBUG
+func describeLogLevels(levels LogLevels) string {
+	parts := []string{levels.Level}
+	patterns := make([]string, 0, len(levels.Modules))
+	for pattern := range levels.Modules {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		parts = append(parts, pattern+"="+levels.Modules[pattern])
+	}
+	return strings.Join(parts, " ")
+}
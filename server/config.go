@@ -0,0 +1,340 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"execute_command/policy"
+)
+
+// FileConfig is the server configuration file (-server-config). It holds the
+// TLS certificates, the roles clients may have and how clients map to roles.
+// Relative paths are resolved from the directory of the file
+//
+//	{
+//	  "tls": {"cert_file": "server.crt", "key_file": "server.key", "client_ca_file": "ca.crt"},
+//	  "policies": {"deploy": "deploy-policy.json"},
+//	  "roles": {"deployer": {"executors": ["plain"], "shells": ["sh"], "policies": ["deploy"]}},
+//	  "allowed_env": ["CI", "EXEC_SECRET_*"],
+//	  "clients": [
+//	    {"name": "ci", "common_name": "ci-runner", "role": "deployer"},
+//	    {"name": "cron", "token_sha256": "<hex>", "role": "deployer"}
+//	  ]
+//	}
+type FileConfig struct {
+	TLS      *TLSConfig            `json:"tls,omitempty"`
+	Policies map[string]string     `json:"policies,omitempty"` // Named policy files roles may use
+	Roles    map[string]RoleConfig `json:"roles,omitempty"`
+	Clients  []ClientConfig        `json:"clients,omitempty"`
+
+	// AllowedEnv are the environment variables requests may set. A trailing
+	// * matches every name with that prefix
+	AllowedEnv []string `json:"allowed_env,omitempty"`
+}
+
+// TLSConfig enables HTTPS, optionally verifying client certificates
+type TLSConfig struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file,omitempty"` // CA that signs client certificates
+	ClientAuth   string `json:"client_auth,omitempty"`    // require (default with a CA) or optional
+}
+
+// RoleConfig limits what the clients with a role may use. Empty lists allow
+// everything, except that only listed policies can be selected
+type RoleConfig struct {
+	Executors []string `json:"executors,omitempty"`
+	Shells    []string `json:"shells,omitempty"`
+	Policies  []string `json:"policies,omitempty"` // The first one applies when a request names none
+}
+
+// ClientConfig maps a client certificate or token to a role. The name
+// identifies the client in the audit log and in policy "users" rules
+type ClientConfig struct {
+	Name        string `json:"name"`
+	Subject     string `json:"subject,omitempty"`      // Certificate subject, e.g. CN=ci-runner,O=Example
+	CommonName  string `json:"common_name,omitempty"`  // Certificate common name
+	TokenSHA256 string `json:"token_sha256,omitempty"` // Hex SHA-256 of a bearer token
+	Role        string `json:"role"`
+}
+
+// Role is a compiled role
+type Role struct {
+	Name      string
+	Executors []string
+	Shells    []string
+	Policies  []string
+}
+
+// allows checks if a value is in a role's list (an empty list allows everything)
+func allows(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// settings is a loaded configuration file
+type settings struct {
+	tls      *tls.Config
+	policies map[string]*policy.Policy
+	roles    map[string]*Role
+	clients  []ClientConfig
+	env      []string // Environment variables requests may set
+	files    []string // Every file read, watched for changes
+}
+
+// loadSettings reads and validates a configuration file and the
+// certificates and policies it refers to
+func loadSettings(path string) (*settings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server config: %v", err)
+	}
+	var config FileConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse server config %s: %v", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(file string) string {
+		if file == "" || filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(dir, file)
+	}
+
+	loaded := &settings{
+		policies: make(map[string]*policy.Policy),
+		roles:    make(map[string]*Role),
+		files:    []string{path},
+	}
+
+	if config.TLS != nil {
+		if loaded.tls, err = loadTLS(config.TLS, resolve); err != nil {
+			return nil, fmt.Errorf("invalid server config %s: %v", path, err)
+		}
+		loaded.files = append(loaded.files, resolve(config.TLS.CertFile), resolve(config.TLS.KeyFile))
+		if config.TLS.ClientCAFile != "" {
+			loaded.files = append(loaded.files, resolve(config.TLS.ClientCAFile))
+		}
+	}
+
+	for name, file := range config.Policies {
+		p, err := policy.LoadPolicy(resolve(file))
+		if err != nil {
+			return nil, fmt.Errorf("policy %s: %v", name, err)
+		}
+		loaded.policies[name] = p
+		loaded.files = append(loaded.files, resolve(file))
+	}
+
+	for name, role := range config.Roles {
+		for _, p := range role.Policies {
+			if loaded.policies[p] == nil {
+				return nil, fmt.Errorf("role %s: unknown policy %q", name, p)
+			}
+		}
+		loaded.roles[name] = &Role{Name: name, Executors: role.Executors, Shells: role.Shells, Policies: role.Policies}
+	}
+
+	for _, name := range config.AllowedEnv {
+		prefix := strings.TrimSuffix(name, "*")
+		if prefix == "" || strings.ContainsAny(prefix, "=*\x00") {
+			return nil, fmt.Errorf("allowed_env: invalid variable name %q", name)
+		}
+		loaded.env = append(loaded.env, name)
+	}
+
+	names := make(map[string]bool)
+	for i, client := range config.Clients {
+		if client.Name == "" {
+			return nil, fmt.Errorf("client %d: name is required", i+1)
+		}
+		if names[client.Name] {
+			return nil, fmt.Errorf("client %s: duplicate name", client.Name)
+		}
+		names[client.Name] = true
+		if loaded.roles[client.Role] == nil {
+			return nil, fmt.Errorf("client %s: unknown role %q", client.Name, client.Role)
+		}
+		if client.Subject == "" && client.CommonName == "" && client.TokenSHA256 == "" {
+			return nil, fmt.Errorf("client %s: needs a subject, common_name or token_sha256", client.Name)
+		}
+		if client.TokenSHA256 != "" {
+			if sum, err := hex.DecodeString(client.TokenSHA256); err != nil || len(sum) != sha256.Size {
+				return nil, fmt.Errorf("client %s: token_sha256 must be 64 hex characters", client.Name)
+			}
+			client.TokenSHA256 = strings.ToLower(client.TokenSHA256)
+		}
+		loaded.clients = append(loaded.clients, client)
+	}
+
+	sort.Strings(loaded.files)
+	return loaded, nil
+}
+
+// loadTLS loads the server certificate and the client CA
+func loadTLS(config *TLSConfig, resolve func(string) string) (*tls.Config, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, fmt.Errorf("tls needs cert_file and key_file")
+	}
+	cert, err := tls.LoadX509KeyPair(resolve(config.CertFile), resolve(config.KeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if config.ClientCAFile == "" {
+		if config.ClientAuth != "" {
+			return nil, fmt.Errorf("client_auth needs client_ca_file")
+		}
+		return tlsConfig, nil
+	}
+	pem, err := os.ReadFile(resolve(config.ClientCAFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %v", err)
+	}
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA %s", config.ClientCAFile)
+	}
+	switch config.ClientAuth {
+	case "", "require":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("invalid client_auth %q (use require or optional)", config.ClientAuth)
+	}
+	return tlsConfig, nil
+}
+
+// allowsEnv checks if requests may set an environment variable
+func (st *settings) allowsEnv(name string) bool {
+	for _, allowed := range st.env {
+		if allowed == name || strings.HasSuffix(allowed, "*") && strings.HasPrefix(name, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// certificateClient finds the client of a verified certificate
+func (st *settings) certificateClient(cert *x509.Certificate) *ClientConfig {
+	for i := range st.clients {
+		client := &st.clients[i]
+		if client.Subject != "" && client.Subject == cert.Subject.String() ||
+			client.CommonName != "" && client.CommonName == cert.Subject.CommonName {
+			return client
+		}
+	}
+	return nil
+}
+
+// tokenClient finds the client of a bearer token
+func (st *settings) tokenClient(token string) *ClientConfig {
+	sum := sha256.Sum256([]byte(token))
+	digest := []byte(hex.EncodeToString(sum[:]))
+	for i := range st.clients {
+		client := &st.clients[i]
+		if client.TokenSHA256 != "" && subtle.ConstantTimeCompare(digest, []byte(client.TokenSHA256)) == 1 {
+			return client
+		}
+	}
+	return nil
+}
+
+// LoadConfigFile loads the server configuration file and applies it to new
+// requests and connections. Enabling or disabling TLS requires a restart
+func (s *Server) LoadConfigFile(path string) error {
+	loaded, err := loadSettings(path)
+	if err != nil {
+		return err
+	}
+
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	if s.settings != nil && (s.settings.tls == nil) != (loaded.tls == nil) {
+		return fmt.Errorf("enabling or disabling TLS requires a restart")
+	}
+	s.settings = loaded
+	s.logger.Info("Loaded server config %s (%d roles, %d clients, TLS: %t)",
+		path, len(loaded.roles), len(loaded.clients), loaded.tls != nil)
+	return nil
+}
+
+// WatchConfigFile reloads the configuration file whenever it, or a
+// certificate or policy it refers to, changes. An invalid configuration is
+// logged and the previous one stays in use. It returns when ctx is done
+func (s *Server) WatchConfigFile(ctx context.Context, path string, interval time.Duration) {
+	last := fingerprint(s.currentSettings().files)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := fingerprint(s.currentSettings().files)
+		if current == last {
+			continue
+		}
+		last = current
+		if err := s.LoadConfigFile(path); err != nil {
+			s.logger.Error("Failed to reload server config, keeping the previous one: %v", err)
+			continue
+		}
+		last = fingerprint(s.currentSettings().files)
+	}
+}
+
+// TLSEnabled reports whether the loaded configuration file enables TLS
+func (s *Server) TLSEnabled() bool {
+	current := s.currentSettings()
+	return current != nil && current.tls != nil
+}
+
+// currentSettings returns the loaded configuration file, or nil
+func (s *Server) currentSettings() *settings {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.settings
+}
+
+// fingerprint summarizes the modification time and size of files
+func fingerprint(files []string) string {
+	var summary strings.Builder
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			fmt.Fprintf(&summary, "%s %d %d\n", file, info.ModTime().UnixNano(), info.Size())
+		} else {
+			fmt.Fprintf(&summary, "%s missing\n", file)
+		}
+	}
+	return summary.String()
+}
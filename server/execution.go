@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"execute_command/executor"
+	"execute_command/policy"
+	"execute_command/redact"
+	"execute_command/signing"
+	"execute_command/tracing"
+	"execute_command/utils"
+)
+
+// maxOutputBytes is how much of each stream's output an execution keeps in
+// memory, for streaming and in its result. Older output is dropped
+const maxOutputBytes = 1 << 20
+
+// States of an execution
+const (
+	StateRunning  = "running"
+	StateFinished = "finished"
+)
+
+// Request is the body of a request to run a command
+type Request struct {
+	Command  string            `json:"command"`
+	Executor string            `json:"executor,omitempty"` // plain or base64 (default: the server's -executor)
+	Shell    string            `json:"shell,omitempty"`    // auto, cmd, powershell or sh (default: the server's -shell)
+	Env      map[string]string `json:"env,omitempty"`      // Extra environment variables
+	Timeout  string            `json:"timeout,omitempty"`  // Per-attempt timeout, e.g. 30s (default: the server's -timeout)
+	Stdin    string            `json:"stdin,omitempty"`    // Input for the command
+	Policy   string            `json:"policy,omitempty"`   // Named policy from the server config (default: the role's first)
+
+	// Bundle is a signed bundle to run instead of Command, Executor and Shell
+	Bundle *signing.SignedBundle `json:"bundle,omitempty"`
+}
+
+// Execution is a command submitted through the API
+type Execution struct {
+	ID       string                    `json:"id"`
+	State    string                    `json:"state"`
+	Status   string                    `json:"status,omitempty"` // Execution status once finished
+	ExitCode int                       `json:"exit_code"`
+	Command  string                    `json:"command"`
+	Executor string                    `json:"executor"`
+	Shell    string                    `json:"shell"`
+	Caller   string                    `json:"caller,omitempty"` // User identified by peer credentials, or client name
+	Policy   string                    `json:"policy,omitempty"` // Named policy the command was checked against
+	Signer   string                    `json:"signer,omitempty"` // Trusted key that signed the command's bundle
+	Created  time.Time                 `json:"created"`
+	Finished *time.Time                `json:"finished,omitempty"`
+	Error    string                    `json:"error,omitempty"`
+	Result   *executor.ExecutionResult `json:"result,omitempty"`
+
+	execType    executor.ExecutorType
+	shellType   executor.ShellType
+	cmdExecutor executor.CommandExecutor
+	redacted    string           // Command with its secrets masked, as shown to clients
+	redactor    *redact.Redactor // Masks the request's secrets until the execution is recorded
+	caller      *policy.Caller
+	signature   *signing.SignatureInfo // nil = not a signed bundle
+	options     executor.ExecutionOptions
+	trace       *tracing.Span
+	logger      *utils.ModuleLogger // Server logger with the request's fields
+	err         error
+	cancel      context.CancelFunc
+	output      *outputStream
+}
+
+// ExecutorType returns the executor type the command ran with
+func (e *Execution) ExecutorType() executor.ExecutorType {
+	return e.execType
+}
+
+// ShellType returns the shell type the command ran with
+func (e *Execution) ShellType() executor.ShellType {
+	return e.shellType
+}
+
+// CommandExecutor returns the executor that ran the command. It is only
+// available until the execution has been recorded (OnFinish)
+func (e *Execution) CommandExecutor() executor.CommandExecutor {
+	return e.cmdExecutor
+}
+
+// CallerIdentity returns who submitted the command
+func (e *Execution) CallerIdentity() *policy.Caller {
+	return e.caller
+}
+
+// Signature returns the signature of the command's bundle, or nil if it
+// was not signed
+func (e *Execution) Signature() *signing.SignatureInfo {
+	return e.signature
+}
+
+// release drops the executor and the request's redactor once the execution
+// has been recorded, so the secret values it was given are not kept
+func (e *Execution) release() {
+	e.cmdExecutor = nil
+	e.redactor = nil
+	e.options = executor.ExecutionOptions{}
+}
+
+// Err returns the error of a finished execution, if it failed
+func (e *Execution) Err() error {
+	return e.err
+}
+
+// Chunk is a piece of output written by a command
+type Chunk struct {
+	Stream string `json:"-"` // stdout or stderr
+	Data   string `json:"data"`
+}
+
+// outputStream collects the output of an execution for streaming. Readers
+// wait on the notify channel, which is replaced after every write. Once the
+// chunks exceed maxOutputBytes, the oldest are dropped; chunk indexes keep
+// counting from the first chunk ever written
+type outputStream struct {
+	mu     sync.Mutex
+	chunks []Chunk
+	first  int // Index of chunks[0]
+	size   int // Bytes in chunks
+	closed bool
+	notify chan struct{}
+}
+
+// newOutputStream creates an empty output stream
+func newOutputStream() *outputStream {
+	return &outputStream{notify: make(chan struct{})}
+}
+
+// writer returns a writer appending to the given stream
+func (o *outputStream) writer(stream string, redactor *redact.Redactor) *streamWriter {
+	return &streamWriter{output: o, stream: stream, redactor: redactor}
+}
+
+// add appends a chunk and wakes up readers
+func (o *outputStream) add(chunk Chunk) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.chunks = append(o.chunks, chunk)
+	o.size += len(chunk.Data)
+	for o.size > maxOutputBytes && len(o.chunks) > 1 {
+		o.size -= len(o.chunks[0].Data)
+		o.chunks[0] = Chunk{}
+		o.chunks = o.chunks[1:]
+		o.first++
+	}
+	close(o.notify)
+	o.notify = make(chan struct{})
+}
+
+// close marks the end of the output and wakes up readers
+func (o *outputStream) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed = true
+	close(o.notify)
+	o.notify = make(chan struct{})
+}
+
+// since returns the chunks from index on and the index of the first one
+// returned, which is later if earlier chunks were dropped. It also returns a
+// channel closed on the next change and whether the output has ended
+func (o *outputStream) since(index int) ([]Chunk, int, <-chan struct{}, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if index < o.first {
+		index = o.first
+	}
+	var chunks []Chunk
+	if offset := index - o.first; offset < len(o.chunks) {
+		chunks = o.chunks[offset:len(o.chunks):len(o.chunks)]
+	}
+	return chunks, index, o.notify, o.closed
+}
+
+// streamWriter writes to one stream of an outputStream
+type streamWriter struct {
+	output   *outputStream
+	stream   string
+	redactor *redact.Redactor
+}
+
+// Write implements io.Writer. Secrets are masked in each chunk; one split
+// across two writes can't be detected
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	sw.output.add(Chunk{Stream: sw.stream, Data: sw.redactor.Redact(string(p))})
+	return len(p), nil
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"execute_command/utils"
+)
+
+// TokenEnv provides the API bearer token through the environment
+const TokenEnv = "EXECUTE_COMMAND_API_TOKEN"
+
+// SocketOptions sets the permissions of a Unix socket
+type SocketOptions struct {
+	Mode  os.FileMode // Permission bits (e.g. 0660 to let a group connect)
+	Group string      // Group owning the socket, by name or gid (empty = unchanged)
+}
+
+// Listen opens the listener for an address: host:port for TCP or
+// unix:/path for a Unix socket with the given permissions
+func Listen(address string, socket SocketOptions) (net.Listener, error) {
+	if strings.HasPrefix(address, "unix:") {
+		path := SocketPath(address)
+		// Remove a socket left behind by a previous server, but nothing else
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err := setSocketPermissions(path, socket); err != nil {
+			listener.Close()
+			return nil, err
+		}
+		return listener, nil
+	}
+	return net.Listen("tcp", address)
+}
+
+// SocketPath returns the path of a unix:/path or unix:///path address
+func SocketPath(address string) string {
+	path := strings.TrimPrefix(address, "unix:")
+	if strings.HasPrefix(path, "//") {
+		path = strings.TrimPrefix(path, "//")
+	}
+	return path
+}
+
+// setSocketPermissions applies the socket's group and mode
+func setSocketPermissions(path string, socket SocketOptions) error {
+	if socket.Group != "" {
+		gid, err := strconv.Atoi(socket.Group)
+		if err != nil {
+			g, lookupErr := user.LookupGroup(socket.Group)
+			if lookupErr != nil {
+				return fmt.Errorf("unknown socket group %q: %v", socket.Group, lookupErr)
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+		if err := os.Chown(path, -1, gid); err != nil {
+			return fmt.Errorf("failed to set socket group: %v", err)
+		}
+	}
+	if err := os.Chmod(path, socket.Mode); err != nil {
+		return fmt.Errorf("failed to set socket permissions: %v", err)
+	}
+	return nil
+}
+
+// IsLocal reports whether an address only accepts local connections
+func IsLocal(address string) bool {
+	if strings.HasPrefix(address, "unix:") {
+		return true
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ResolveToken returns the bearer token and where it came from: the
+// environment, the token file, or a token generated and saved as api.token
+// in the state directory on first use
+func ResolveToken(tokenFile string) (string, string, error) {
+	if token := os.Getenv(TokenEnv); token != "" {
+		return token, "$" + TokenEnv, nil
+	}
+
+	generate := false
+	if tokenFile == "" {
+		dir, err := utils.StateDir()
+		if err != nil {
+			return "", "", err
+		}
+		tokenFile = filepath.Join(dir, "api.token")
+		generate = true
+	}
+
+	data, err := os.ReadFile(tokenFile)
+	if err == nil {
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", "", fmt.Errorf("token file %s is empty", tokenFile)
+		}
+		return token, tokenFile, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) || !generate {
+		return "", "", fmt.Errorf("failed to read token file: %v", err)
+	}
+
+	var buf [32]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	token := hex.EncodeToString(buf[:])
+	if err := os.WriteFile(tokenFile, []byte(token+"\n"), 0600); err != nil {
+		return "", "", fmt.Errorf("failed to save token: %v", err)
+	}
+	return token, tokenFile, nil
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net"
+	"syscall"
+
+	"execute_command/policy"
+)
+
+// peerCaller identifies the process on the other end of a Unix socket
+// connection with SO_PEERCRED
+func peerCaller(conn net.Conn) (*policy.Caller, bool) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, false
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil, false
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || credErr != nil {
+		return nil, false
+	}
+	return policy.LookupCaller(int(cred.Uid), int(cred.Gid), int(cred.Pid)), true
+}
@@ -0,0 +1,793 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"execute_command/executor"
+	"execute_command/metrics"
+	"execute_command/policy"
+	"execute_command/redact"
+	"execute_command/secrets"
+	"execute_command/signing"
+	"execute_command/tracing"
+	"execute_command/utils"
+)
+
+// maxRequestBytes limits the size of a request body
+const maxRequestBytes = 1 << 20
+
+// maxFinished is the number of finished executions kept for status requests
+const maxFinished = 1000
+
+// Config configures the API server
+type Config struct {
+	Executor executor.ExecutorType     // Executor for requests that don't name one
+	Shell    executor.ShellType        // Shell for requests that don't name one
+	Options  executor.ExecutionOptions // Base options for every execution
+	Token    string                    // Bearer token for full access (clients from the config file have their own)
+	Tracer   *tracing.Tracer           // Traces every execution (nil = not traced)
+	PeerAuth bool                      // Identify Unix socket peers by their credentials, without a token
+	OnFinish func(*Execution)          // Called when an execution ends or is denied (e.g. to audit it)
+
+	Verifier         *signing.Verifier // Checks signed bundles (nil = bundles are refused)
+	RequireSignature bool              // Refuse requests without a signed bundle
+}
+
+// Server runs commands submitted over HTTP and keeps track of them
+type Server struct {
+	config     Config
+	mu         sync.Mutex
+	executions map[string]*Execution
+	running    sync.WaitGroup // Added to under mu, so Shutdown can wait for it
+	stopping   bool           // Set by Shutdown; new executions are refused
+	httpServer *http.Server
+	logger     *utils.ModuleLogger
+
+	settingsMu sync.RWMutex
+	settings   *settings // Loaded -server-config, nil if there is none
+}
+
+// New creates an API server
+func New(config Config) *Server {
+	s := &Server{
+		config:     config,
+		executions: make(map[string]*Execution),
+		logger:     utils.GetModuleLogger("server"),
+	}
+	s.httpServer = &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ConnContext:       connCaller,
+	}
+	return s
+}
+
+// callerKey is the context key of a connection's peer credentials
+type callerKey struct{}
+
+// identityKey is the context key of the identity of an authenticated request
+type identityKey struct{}
+
+// identity is who sent a request and what they may use
+type identity struct {
+	caller *policy.Caller
+	role   *Role // nil = no restrictions
+	admin  bool  // May change the server's settings, e.g. its log levels
+}
+
+// connCaller identifies the peer of a Unix socket connection by its
+// credentials, where the platform supports it
+func connCaller(ctx context.Context, conn net.Conn) context.Context {
+	if caller, ok := peerCaller(conn); ok {
+		return context.WithValue(ctx, callerKey{}, caller)
+	}
+	return ctx
+}
+
+// requestIdentity returns the identity of an authenticated request
+func requestIdentity(r *http.Request) *identity {
+	if id, ok := r.Context().Value(identityKey{}).(*identity); ok {
+		return id
+	}
+	return &identity{caller: policy.Anonymous()}
+}
+
+// clientCaller returns the caller for a client from the config file. Its
+// name matches policy "users" rules; it has no uid
+func clientCaller(client *ClientConfig) *policy.Caller {
+	caller := policy.Anonymous()
+	caller.User = client.Name
+	return caller
+}
+
+// Handler returns the HTTP handler of the API
+//
+//	POST   /v1/executions             run a command, returns its ID
+//	GET    /v1/executions             list executions
+//	GET    /v1/executions/{id}        status and result of an execution
+//	GET    /v1/executions/{id}/events output as server-sent events
+//	DELETE /v1/executions/{id}        cancel a running execution
+//	GET    /v1/admin/log-levels       log level and module overrides (admins only)
+//	PUT    /v1/admin/log-levels       change log levels (admins only)
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/executions", s.handleExecutions)
+	mux.HandleFunc("/v1/executions/", s.handleExecution)
+	mux.HandleFunc("/v1/admin/log-levels", s.handleLogLevels)
+	return s.authenticate(mux)
+}
+
+// Serve accepts connections on the listener until Shutdown is called. With
+// TLS in the config file, connections use the certificates loaded last
+func (s *Server) Serve(listener net.Listener) error {
+	if current := s.currentSettings(); current != nil && current.tls != nil {
+		listener = tls.NewListener(listener, &tls.Config{
+			GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				return s.currentSettings().tls, nil
+			},
+		})
+	}
+	err := s.httpServer.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown refuses new executions, cancels running ones, waits for them to
+// be recorded and stops the HTTP server
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopping = true
+	for _, execution := range s.executions {
+		if execution.State == StateRunning {
+			execution.cancel()
+		}
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return s.httpServer.Shutdown(ctx)
+}
+
+// authenticate identifies the sender of a request and rejects it if it
+// can't be identified. In order: Unix socket peer credentials (only with
+// PeerAuth), a client certificate or token from the config file, then the
+// server's own token
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := s.identify(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="execute_command"`)
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	})
+}
+
+// identify returns the identity of a request
+func (s *Server) identify(r *http.Request) (*identity, error) {
+	if caller, ok := r.Context().Value(callerKey{}).(*policy.Caller); ok && s.config.PeerAuth {
+		return &identity{caller: caller, admin: caller.UID == 0 || caller.UID == os.Getuid()}, nil
+	}
+
+	current := s.currentSettings()
+	var token string
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimPrefix(header, "Bearer ")
+	}
+
+	if current != nil {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			if client := current.certificateClient(r.TLS.PeerCertificates[0]); client != nil {
+				return &identity{caller: clientCaller(client), role: current.roles[client.Role]}, nil
+			}
+			if token == "" {
+				return nil, fmt.Errorf("client certificate %q is not mapped to a client", r.TLS.PeerCertificates[0].Subject)
+			}
+		}
+		if token != "" {
+			if client := current.tokenClient(token); client != nil {
+				return &identity{caller: clientCaller(client), role: current.roles[client.Role]}, nil
+			}
+		}
+	}
+
+	if token != "" && s.config.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) == 1 {
+		return &identity{caller: policy.Anonymous(), admin: true}, nil
+	}
+	return nil, fmt.Errorf("missing or invalid bearer token")
+}
+
+// handleExecutions lists executions or starts a new one
+func (s *Server) handleExecutions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.list(requestIdentity(r).caller))
+	case http.MethodPost:
+		s.handleSubmit(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleExecution serves the status, events and cancellation of one execution
+func (s *Server) handleExecution(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/executions/")
+	id, sub := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		id, sub = path[:i], path[i+1:]
+	}
+
+	s.mu.Lock()
+	execution, ok := s.executions[id]
+	s.mu.Unlock()
+	if !ok || !visibleTo(execution, requestIdentity(r).caller) {
+		writeError(w, http.StatusNotFound, "no such execution: "+id)
+		return
+	}
+
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.snapshot(execution, true))
+	case sub == "" && r.Method == http.MethodDelete:
+		s.handleCancel(w, execution)
+	case sub == "events" && r.Method == http.MethodGet:
+		s.handleEvents(w, r, execution)
+	case sub == "" || sub == "events":
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// handleSubmit validates a request and starts running it
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var request Request
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	// A signed bundle names the command, executor and shell itself
+	var bundle *signing.Bundle
+	var signature *signing.SignatureInfo
+	var rejected error
+	if request.Bundle != nil || s.config.RequireSignature {
+		var err error
+		bundle, signature, err = s.verifyBundle(&request)
+		var rejectedErr *signing.RejectedError
+		if errors.As(err, &rejectedErr) {
+			rejected = err
+		} else if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// A traceparent header joins the execution to the client's trace
+	parent, _ := tracing.ParseTraceparent(r.Header.Get("traceparent"))
+	execution, err := s.prepare(request, requestIdentity(r), parent)
+	if err != nil && rejected != nil {
+		s.logger.Warn("Rejected request from %s: %v", requestIdentity(r).caller, rejected)
+		writeError(w, http.StatusForbidden, rejected.Error())
+		return
+	}
+	var forbidden forbiddenError
+	if errors.As(err, &forbidden) {
+		s.logger.Warn("Refused request from %s: %v", requestIdentity(r).caller, err)
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	execution.signature = signature
+	if signature != nil && signature.Signer != "" {
+		execution.Signer = signature.String()
+		execution.logger = execution.logger.With("signer", execution.Signer)
+	}
+	if rejected != nil {
+		s.reject(w, execution, rejected)
+		return
+	}
+
+	// Refuse denied commands up front; the executor checks again before running
+	checked := request.Command
+	if execution.execType == executor.Base64Type {
+		if checked, err = execution.cmdExecutor.DecodeCommand(request.Command); err != nil {
+			execution.cancel()
+			execution.trace.RecordError(err)
+			execution.trace.End()
+			writeError(w, http.StatusBadRequest, "invalid base64 command: "+err.Error())
+			return
+		}
+	}
+	if err := executor.ValidateCommandWith(checked, execution.execType, execution.shellType, execution.options); err != nil {
+		execution.cancel()
+		execution.err = err
+		s.finished(execution)
+		var denied *policy.DeniedError
+		if errors.As(err, &denied) {
+			writeError(w, http.StatusForbidden, err.Error())
+		} else {
+			writeError(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+
+	// Use the bundle's nonce last, so a bundle refused above can still run
+	if bundle != nil {
+		if err := s.config.Verifier.Consume(bundle, signature, time.Now()); err != nil {
+			s.reject(w, execution, err)
+			return
+		}
+	}
+
+	if err := s.start(execution); err != nil {
+		execution.cancel()
+		execution.trace.RecordError(err)
+		execution.trace.End()
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	w.Header().Set("Location", "/v1/executions/"+execution.ID)
+	writeJSON(w, http.StatusAccepted, s.snapshot(execution, false))
+}
+
+// verifyBundle checks the signed bundle of a request and fills the request's
+// command, executor and shell from it. The environment is not signed, so a
+// request with a bundle can't set it. Without a bundle, or if it must not
+// run, the error is a RejectedError
+func (s *Server) verifyBundle(request *Request) (*signing.Bundle, *signing.SignatureInfo, error) {
+	if request.Bundle == nil {
+		return nil, nil, &signing.RejectedError{Reason: "a signed bundle is required"}
+	}
+	if request.Command != "" || request.Executor != "" || request.Shell != "" || len(request.Env) > 0 {
+		return nil, nil, fmt.Errorf("a request with a bundle must not set command, executor, shell or env")
+	}
+	if s.config.Verifier == nil {
+		return nil, nil, fmt.Errorf("signed bundles are not enabled")
+	}
+	bundle, signature, err := s.config.Verifier.Verify(request.Bundle, time.Now())
+	if bundle != nil {
+		request.Command, request.Executor, request.Shell = bundle.Command, bundle.Executor, bundle.Shell
+	}
+	return bundle, signature, err
+}
+
+// reject refuses an execution whose bundle must not run, recording it like
+// a denied command
+func (s *Server) reject(w http.ResponseWriter, execution *Execution, err error) {
+	execution.cancel()
+	execution.err = err
+	execution.logger.Warn("Rejected execution %s from %s: %v", execution.ID, execution.caller, err)
+	s.finished(execution)
+	var rejected *signing.RejectedError
+	if errors.As(err, &rejected) {
+		writeError(w, http.StatusForbidden, err.Error())
+	} else {
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// prepare creates an execution and its executor from a request, checking
+// that the identity's role may use the executor, shell and policy. Its
+// trace joins the parent if that is valid
+func (s *Server) prepare(request Request, id *identity, parent tracing.SpanContext) (*Execution, error) {
+	if strings.TrimSpace(request.Command) == "" {
+		return nil, fmt.Errorf("command is required")
+	}
+
+	execType := s.config.Executor
+	if request.Executor != "" {
+		execType = executor.ParseExecutorType(request.Executor)
+		if execType.String() != strings.ToLower(request.Executor) {
+			return nil, fmt.Errorf("unknown executor: %s", request.Executor)
+		}
+	}
+	shellType := s.config.Shell
+	if request.Shell != "" {
+		shellType = executor.ParseShellType(request.Shell)
+		if shellType == executor.AutoShell && !strings.EqualFold(request.Shell, "auto") {
+			return nil, fmt.Errorf("unknown shell: %s", request.Shell)
+		}
+	}
+	// Like the CLI, base64 payloads use PowerShell on Windows unless a shell is named
+	if execType == executor.Base64Type && shellType == executor.AutoShell && executor.IsWindows() {
+		shellType = executor.PowerShellShell
+	}
+	if execType == executor.Base64Type && executor.ResolveShellType(shellType) == executor.CMDShell {
+		return nil, fmt.Errorf("base64 executor is not compatible with cmd shell")
+	}
+
+	options := s.config.Options
+	if id.role != nil {
+		if !allows(id.role.Executors, execType.String()) {
+			return nil, forbiddenError(fmt.Sprintf("role %s may not use the %s executor", id.role.Name, execType))
+		}
+		if !allows(id.role.Shells, executor.ResolveShellType(shellType).String()) {
+			return nil, forbiddenError(fmt.Sprintf("role %s may not use the %s shell", id.role.Name, executor.ResolveShellType(shellType)))
+		}
+	}
+	policyName, commandPolicy, err := s.selectPolicy(request.Policy, id.role)
+	if err != nil {
+		return nil, err
+	}
+	if commandPolicy != nil {
+		options.Policy = commandPolicy
+	}
+
+	if request.Timeout != "" {
+		timeout, err := time.ParseDuration(request.Timeout)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid timeout: %s", request.Timeout)
+		}
+		options.Timeout = timeout
+	}
+	// The request's secret values are masked in its own output and records
+	// only, so they don't pile up in redact.Default
+	redactor := redact.Default.Clone()
+	options.Redactor = redactor
+	options.Env = append([]string(nil), options.Env...)
+	for name, value := range request.Env {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return nil, fmt.Errorf("invalid environment variable name: %q", name)
+		}
+		if current := s.currentSettings(); current == nil || !current.allowsEnv(name) {
+			return nil, fmt.Errorf("environment variable %s is not in the server's allowed_env", name)
+		}
+		options.Env = append(options.Env, name+"="+value)
+		redactor.AddEnvValue(name, value)
+		if strings.HasPrefix(name, secrets.EnvPrefix) {
+			redactor.AddValue("secret:"+strings.ToLower(strings.TrimPrefix(name, secrets.EnvPrefix)), value)
+		}
+	}
+	options.Stdin = strings.NewReader(request.Stdin)
+	options.MaxOutput = maxOutputBytes
+	options.Caller = id.caller
+
+	executionID, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	logFields := utils.Fields{"request_id": executionID, "caller": id.caller.String()}
+	for key, value := range options.LogFields {
+		logFields[key] = value
+	}
+
+	trace := s.config.Tracer.Start("execute", parent)
+	trace.SetAttribute("execution.id", executionID)
+	trace.SetAttribute("executor.type", execType.String())
+	trace.SetAttribute("shell", executor.ResolveShellType(shellType).String())
+	trace.SetAttribute("caller", id.caller.String())
+	if policyName != "" {
+		trace.SetAttribute("policy.name", policyName)
+	}
+	options.Trace = trace
+	if trace != nil {
+		logFields["trace_id"] = trace.Context().TraceID.String()
+	}
+	options.LogFields = logFields
+
+	output := newOutputStream()
+	ctx, cancel := context.WithCancel(context.Background())
+	options.Context = ctx
+	options.Stdout = output.writer("stdout", redactor)
+	options.Stderr = output.writer("stderr", redactor)
+
+	cmdExecutor := executor.NewExecutorFactory().CreateExecutorWithOptions(execType, shellType, options)
+	redacted, _ := executor.RedactCommand(cmdExecutor, request.Command)
+	return &Execution{
+		ID:          executionID,
+		State:       StateRunning,
+		ExitCode:    -1,
+		Command:     request.Command,
+		Executor:    execType.String(),
+		Shell:       executor.ResolveShellType(shellType).String(),
+		Caller:      id.caller.User,
+		Policy:      policyName,
+		Created:     time.Now().UTC(),
+		execType:    execType,
+		caller:      id.caller,
+		options:     options,
+		trace:       trace,
+		logger:      s.logger.WithFields(logFields),
+		shellType:   shellType,
+		cmdExecutor: cmdExecutor,
+		redacted:    redacted,
+		redactor:    redactor,
+		cancel:      cancel,
+		output:      output,
+	}, nil
+}
+
+// start registers an execution and runs it in the background, unless the
+// server is shutting down
+func (s *Server) start(execution *Execution) error {
+	s.mu.Lock()
+	if s.stopping {
+		s.mu.Unlock()
+		return fmt.Errorf("server is shutting down")
+	}
+	s.executions[execution.ID] = execution
+	s.pruneLocked()
+	s.running.Add(1)
+	s.mu.Unlock()
+
+	execution.logger.Info("Execution %s started by %s: %s", execution.ID, execution.caller, execution.redacted)
+	go func() {
+		defer s.running.Done()
+		defer execution.cancel()
+
+		metrics.ExecutionStarted()
+		result, err := execution.cmdExecutor.Execute(execution.Command)
+		metrics.ExecutionEnded()
+
+		s.mu.Lock()
+		now := time.Now().UTC()
+		execution.State = StateFinished
+		execution.Finished = &now
+		execution.Result = result
+		execution.err = err
+		if result != nil {
+			execution.Status = result.Status().String()
+			execution.ExitCode = result.ExitCode()
+		} else {
+			execution.Status = "error"
+		}
+		if err != nil {
+			execution.Error = execution.redactor.Redact(err.Error())
+		}
+		s.mu.Unlock()
+
+		execution.output.close()
+		execution.logger.Info("Execution %s finished (status: %s, exit code: %d)", execution.ID, execution.Status, execution.ExitCode)
+		s.finished(execution)
+	}()
+	return nil
+}
+
+// finished reports an execution that ended or was denied and exports its trace
+func (s *Server) finished(execution *Execution) {
+	var denied *policy.DeniedError
+	var rejected *signing.RejectedError
+	switch {
+	case execution.Result != nil:
+		execution.trace.SetAttribute("process.exit_code", execution.Result.ExitCode())
+		execution.trace.SetAttribute("execution.status", execution.Result.Status().String())
+	case errors.As(execution.err, &denied):
+		execution.trace.SetAttribute("execution.status", "denied")
+	case errors.As(execution.err, &rejected):
+		execution.trace.SetAttribute("execution.status", "rejected")
+	default:
+		execution.trace.SetAttribute("execution.status", "error")
+	}
+	execution.trace.RecordError(execution.err)
+	execution.trace.End()
+
+	if s.config.OnFinish != nil {
+		s.config.OnFinish(execution)
+	}
+	execution.release()
+}
+
+// pruneLocked drops the oldest finished executions beyond maxFinished
+func (s *Server) pruneLocked() {
+	var finished []*Execution
+	for _, execution := range s.executions {
+		if execution.State != StateRunning {
+			finished = append(finished, execution)
+		}
+	}
+	if len(finished) <= maxFinished {
+		return
+	}
+	sort.Slice(finished, func(a, b int) bool {
+		return finished[a].Created.Before(finished[b].Created)
+	})
+	for _, execution := range finished[:len(finished)-maxFinished] {
+		delete(s.executions, execution.ID)
+	}
+}
+
+// handleCancel cancels a running execution
+func (s *Server) handleCancel(w http.ResponseWriter, execution *Execution) {
+	s.mu.Lock()
+	running := execution.State == StateRunning
+	s.mu.Unlock()
+	if !running {
+		writeError(w, http.StatusConflict, "execution "+execution.ID+" is not running")
+		return
+	}
+
+	execution.logger.Info("Canceling execution %s", execution.ID)
+	execution.cancel()
+	writeJSON(w, http.StatusAccepted, s.snapshot(execution, false))
+}
+
+// handleEvents streams the output of an execution as server-sent events: a
+// stdout or stderr event per chunk of output, then a done event with the
+// final status. Clients can resume with the Last-Event-ID header
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request, execution *Execution) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	index := 0
+	if last, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil && last >= 0 {
+		index = last + 1
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		chunks, start, notify, closed := execution.output.since(index)
+		index = start
+		for _, chunk := range chunks {
+			data, _ := json.Marshal(chunk)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", index, chunk.Stream, data)
+			index++
+		}
+		if closed {
+			data, _ := json.Marshal(s.snapshot(execution, false))
+			fmt.Fprintf(w, "event: done\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-notify:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// list returns the executions visible to a caller without results, oldest first
+func (s *Server) list(caller *policy.Caller) []Execution {
+	s.mu.Lock()
+	list := make([]Execution, 0, len(s.executions))
+	for _, execution := range s.executions {
+		if visibleTo(execution, caller) {
+			list = append(list, s.snapshotLocked(execution, false))
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(list, func(a, b int) bool {
+		return list[a].Created.Before(list[b].Created)
+	})
+	return list
+}
+
+// snapshot returns a copy of an execution that is safe to encode
+func (s *Server) snapshot(execution *Execution, withResult bool) Execution {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshotLocked(execution, withResult)
+}
+
+// snapshotLocked is snapshot with the lock already held
+func (s *Server) snapshotLocked(execution *Execution, withResult bool) Execution {
+	snapshot := Execution{
+		ID:       execution.ID,
+		State:    execution.State,
+		Status:   execution.Status,
+		ExitCode: execution.ExitCode,
+		Command:  execution.redacted,
+		Executor: execution.Executor,
+		Shell:    execution.Shell,
+		Caller:   execution.Caller,
+		Policy:   execution.Policy,
+		Signer:   execution.Signer,
+		Created:  execution.Created,
+		Finished: execution.Finished,
+		Error:    execution.Error,
+	}
+	if withResult {
+		snapshot.Result = execution.Result
+	}
+	return snapshot
+}
+
+// selectPolicy returns the named policy a request may use: the one it names,
+// or the first of its role's policies. Without either, the server's policy
+// applies (nil)
+func (s *Server) selectPolicy(name string, role *Role) (string, *policy.Policy, error) {
+	if name == "" && role != nil && len(role.Policies) > 0 {
+		name = role.Policies[0]
+	}
+	if name == "" {
+		return "", nil, nil
+	}
+	if role == nil || len(role.Policies) > 0 && !allows(role.Policies, name) {
+		return "", nil, forbiddenError(fmt.Sprintf("not allowed to use policy %q", name))
+	}
+	current := s.currentSettings()
+	if current == nil || current.policies[name] == nil {
+		return "", nil, fmt.Errorf("unknown policy: %s", name)
+	}
+	return name, current.policies[name], nil
+}
+
+// forbiddenError is a request its sender is not allowed to make
+type forbiddenError string
+
+// Error returns the reason the request was refused
+func (e forbiddenError) Error() string {
+	return string(e)
+}
+
+// visibleTo reports whether a caller may see and cancel an execution. Callers
+// identified by peer credentials only see their own executions, except for
+// root and the user running the server; clients from the config file only see
+// their own; holders of the server's token see everything
+func visibleTo(execution *Execution, caller *policy.Caller) bool {
+	switch {
+	case caller.Known():
+		if caller.UID == 0 || caller.UID == os.Getuid() {
+			return true
+		}
+		return execution.caller.Known() && execution.caller.UID == caller.UID
+	case caller.User != "":
+		return !execution.caller.Known() && execution.caller.User == caller.User
+	default:
+		return true
+	}
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// newID returns a random execution ID
+func newID() (string, error) {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", fmt.Errorf("failed to generate execution ID: %v", err)
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"execute_command/executor"
+	"execute_command/redact"
+)
+
+// testToken is the server token of test servers
+const testToken = "test-token"
+
+// newTestServer creates a server running plain sh commands, with a config
+// file if one is given
+func newTestServer(t *testing.T, configFile string) *Server {
+	t.Helper()
+	s := New(Config{Executor: executor.PlainType, Shell: executor.ShShell, Token: testToken})
+	if configFile != "" {
+		path := filepath.Join(t.TempDir(), "server.json")
+		if err := os.WriteFile(path, []byte(configFile), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.LoadConfigFile(path); err != nil {
+			t.Fatalf("LoadConfigFile: %v", err)
+		}
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return s
+}
+
+// submit posts a request body and returns the response
+func submit(t *testing.T, s *Server, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/v1/executions", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+testToken)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	return w
+}
+
+func TestSubmitAllowsListedEnv(t *testing.T) {
+	s := newTestServer(t, `{"allowed_env": ["CI", "EXEC_SECRET_*"]}`)
+	for _, name := range []string{"CI", "EXEC_SECRET_DB"} {
+		w := submit(t, s, `{"command": "true", "env": {"`+name+`": "1"}}`)
+		if w.Code != http.StatusAccepted {
+			t.Errorf("env %s: status %d (%s), want 202", name, w.Code, w.Body)
+		}
+	}
+}
+
+func TestSubmitRefusesUnlistedEnv(t *testing.T) {
+	s := newTestServer(t, `{"allowed_env": ["CI", "EXEC_SECRET_*"]}`)
+	names := []string{
+		"GIT_SSH_COMMAND", "GIT_CONFIG_COUNT", "GIT_CONFIG_KEY_0", "NODE_OPTIONS",
+		"PERL5OPT", "PYTHONPATH", "PAGER", "GIT_PAGER", "PATH", "LD_PRELOAD",
+		"ci", "CI_EXTRA", "EXEC_SECRET",
+	}
+	for _, name := range names {
+		w := submit(t, s, `{"command": "true", "env": {"`+name+`": "x"}}`)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "allowed_env") {
+			t.Errorf("env %s: status %d (%s), want 400", name, w.Code, w.Body)
+		}
+	}
+}
+
+func TestSubmitRefusesEnvWithoutConfigFile(t *testing.T) {
+	s := newTestServer(t, "")
+	if w := submit(t, s, `{"command": "true", "env": {"CI": "1"}}`); w.Code != http.StatusBadRequest {
+		t.Errorf("status %d (%s), want 400", w.Code, w.Body)
+	}
+	if w := submit(t, s, `{"command": "true"}`); w.Code != http.StatusAccepted {
+		t.Errorf("request without env: status %d (%s), want 202", w.Code, w.Body)
+	}
+}
+
+func TestSubmitMasksRequestSecrets(t *testing.T) {
+	s := newTestServer(t, `{"allowed_env": ["EXEC_SECRET_*"]}`)
+	w := submit(t, s, `{"command": "echo $EXEC_SECRET_DB", "env": {"EXEC_SECRET_DB": "hunter2-db"}}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d (%s), want 202", w.Code, w.Body)
+	}
+	var response Execution
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	s.running.Wait()
+
+	s.mu.Lock()
+	execution := s.executions[response.ID]
+	s.mu.Unlock()
+	if stdout := execution.Result.LastAttempt().Stdout; stdout != redact.Mask+"\n" {
+		t.Errorf("stdout = %q, want the secret masked", stdout)
+	}
+	chunks, _, _, _ := execution.output.since(0)
+	if len(chunks) != 1 || chunks[0].Data != redact.Mask+"\n" {
+		t.Errorf("streamed output = %v, want the secret masked", chunks)
+	}
+	if execution.redactor != nil || execution.CommandExecutor() != nil {
+		t.Error("the request's redactor is kept after the execution was recorded")
+	}
+	if got := redact.Default.Redact("hunter2-db"); got != "hunter2-db" {
+		t.Errorf("redact.Default masks the request's secret: %q", got)
+	}
+}
+
+func TestShutdownRefusesSubmits(t *testing.T) {
+	s := newTestServer(t, "")
+	if w := submit(t, s, `{"command": "sleep 30"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("status %d (%s), want 202", w.Code, w.Body)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	for _, execution := range s.executions {
+		if execution.State != StateFinished {
+			t.Errorf("execution %s is %s after Shutdown", execution.ID, execution.State)
+		}
+	}
+	if w := submit(t, s, `{"command": "true"}`); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d (%s) after Shutdown, want 503", w.Code, w.Body)
+	}
+	if len(s.executions) != 1 {
+		t.Errorf("%d executions, want the one before Shutdown", len(s.executions))
+	}
+}
+
+func TestLoadSettingsRejectsInvalidAllowedEnv(t *testing.T) {
+	for _, name := range []string{"*", "", "A=B", "A*B*"} {
+		path := filepath.Join(t.TempDir(), "server.json")
+		if err := os.WriteFile(path, []byte(`{"allowed_env": ["`+name+`"]}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadSettings(path); err == nil {
+			t.Errorf("allowed_env %q accepted", name)
+		}
+	}
+}
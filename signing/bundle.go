@@ -0,0 +1,213 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Version is the format version of the bundles created by Sign
+const Version = 1
+
+// maxBundleBytes limits the size of a bundle read from stdin
+const maxBundleBytes = 1 << 20
+
+// signaturePrefix separates bundle signatures from anything else signed
+// with the same key
+const signaturePrefix = "execute_command signed bundle v1\n"
+
+// Bundle is a command with the executor and shell it must run with, signed
+// for a limited time. The nonce lets every host run it only once
+type Bundle struct {
+	Version  int       `json:"version"`
+	Command  string    `json:"command"`
+	Executor string    `json:"executor"`
+	Shell    string    `json:"shell"`
+	Signed   time.Time `json:"signed"`
+	Expires  time.Time `json:"expires"`
+	Nonce    string    `json:"nonce"`
+}
+
+// SignedBundle is a bundle with its signature. The signature covers the
+// exact payload bytes, so they are kept as signed rather than re-encoded
+type SignedBundle struct {
+	Payload   []byte `json:"payload"`   // JSON encoding of the Bundle
+	KeyID     string `json:"key_id"`    // ID of the signing key
+	Signature []byte `json:"signature"` // Ed25519 signature of the payload
+}
+
+// NewBundle creates a bundle for a command that expires after ttl, with a
+// random nonce
+func NewBundle(command, executor, shell string, ttl time.Duration) (*Bundle, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("bundle lifetime must be positive")
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	return &Bundle{
+		Version:  Version,
+		Command:  command,
+		Executor: executor,
+		Shell:    shell,
+		Signed:   now,
+		Expires:  now.Add(ttl),
+		Nonce:    hex.EncodeToString(nonce),
+	}, nil
+}
+
+// Sign signs a bundle with a private key
+func Sign(bundle *Bundle, key ed25519.PrivateKey) (*SignedBundle, error) {
+	payload, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode bundle: %v", err)
+	}
+	return &SignedBundle{
+		Payload:   payload,
+		KeyID:     KeyID(key.Public().(ed25519.PublicKey)),
+		Signature: ed25519.Sign(key, signedMessage(payload)),
+	}, nil
+}
+
+// signedMessage returns the bytes a signature covers
+func signedMessage(payload []byte) []byte {
+	return append([]byte(signaturePrefix), payload...)
+}
+
+// Bundle decodes the payload without verifying it, e.g. to record what a
+// rejected bundle asked for
+func (sb *SignedBundle) Bundle() (*Bundle, error) {
+	var bundle Bundle
+	if err := json.Unmarshal(sb.Payload, &bundle); err != nil {
+		return nil, fmt.Errorf("invalid bundle payload: %v", err)
+	}
+	return &bundle, nil
+}
+
+// ParseSignedBundle parses a signed bundle as written by the sign action
+func ParseSignedBundle(data []byte) (*SignedBundle, error) {
+	var sb SignedBundle
+	if err := json.Unmarshal(data, &sb); err != nil {
+		return nil, fmt.Errorf("invalid signed bundle: %v", err)
+	}
+	if len(sb.Payload) == 0 || len(sb.Signature) == 0 {
+		return nil, fmt.Errorf("invalid signed bundle: payload and signature are required")
+	}
+	return &sb, nil
+}
+
+// LoadSignedBundle reads a signed bundle from a file, or stdin for "-"
+func LoadSignedBundle(path string) (*SignedBundle, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(io.LimitReader(os.Stdin, maxBundleBytes))
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %v", err)
+	}
+	return ParseSignedBundle(data)
+}
+
+// KeyID returns the short ID of a public key: the first 16 hex digits of
+// its SHA-256
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// GenerateKey writes a new Ed25519 key pair: the private key to path (PKCS#8
+// PEM, mode 0600) and the public key to path.pub (PKIX PEM). It returns the
+// key ID
+func GenerateKey(path string) (string, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate key: %v", err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode private key: %v", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode public key: %v", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create private key: %v", err)
+	}
+	err = pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write private key: %v", err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	if err := os.WriteFile(path+".pub", publicPEM, 0644); err != nil {
+		return "", fmt.Errorf("failed to write public key: %v", err)
+	}
+	return KeyID(public), nil
+}
+
+// LoadPrivateKey reads an Ed25519 private key in PKCS#8 PEM, as written by
+// GenerateKey or openssl genpkey -algorithm ed25519
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key %s: %v", path, err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 key", path)
+	}
+	return private, nil
+}
+
+// LoadPublicKey reads an Ed25519 public key in PKIX PEM
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %s: %v", path, err)
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 key", path)
+	}
+	return public, nil
+}
+
+// readPEM reads the first PEM block of a file and checks its type
+func readPEM(path, blockType string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s does not contain a PEM %s", path, strings.ToLower(blockType))
+	}
+	return block, nil
+}
@@ -0,0 +1,119 @@
+package signing
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"execute_command/utils"
+)
+
+// Files in the nonce directory
+const (
+	nonceFile = "nonces"
+	nonceLock = "nonces.lock"
+)
+
+// NonceStore remembers the nonces of accepted bundles until they expire.
+// It is shared by every process using the same directory
+type NonceStore struct {
+	dir string
+}
+
+// NewNonceStore creates a nonce store in dir
+func NewNonceStore(dir string) *NonceStore {
+	return &NonceStore{dir: dir}
+}
+
+// DefaultNonceDir returns the default nonce directory, the state directory
+func DefaultNonceDir() (string, error) {
+	return utils.StateDir()
+}
+
+// Use records a nonce until it expires. It returns a RejectedError if the
+// nonce was used before
+func (s *NonceStore) Use(nonce string, expires, now time.Time) error {
+	if strings.ContainsAny(nonce, " \n") {
+		return rejected("invalid nonce")
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create nonce directory: %v", err)
+	}
+	lock, err := os.OpenFile(filepath.Join(s.dir, nonceLock), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open nonce lock: %v", err)
+	}
+	defer lock.Close()
+	if err := utils.LockFile(lock); err != nil {
+		return fmt.Errorf("failed to lock nonces: %v", err)
+	}
+	defer utils.UnlockFile(lock)
+
+	nonces, err := s.read(now)
+	if err != nil {
+		return err
+	}
+	if _, used := nonces[nonce]; used {
+		return rejected("bundle %s was already used (replay)", nonce)
+	}
+	nonces[nonce] = expires
+
+	// Rewrite the file without the expired nonces
+	var buf strings.Builder
+	for used, until := range nonces {
+		// Round up, so a nonce is never forgotten before its bundle expires
+		fmt.Fprintf(&buf, "%s %d\n", used, until.Add(time.Second-1).Unix())
+	}
+	path := filepath.Join(s.dir, nonceFile)
+	if err := os.WriteFile(path+".tmp", []byte(buf.String()), 0600); err != nil {
+		return fmt.Errorf("failed to write nonces: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write nonces: %v", err)
+	}
+	return nil
+}
+
+// Used reports whether a nonce was used and has not expired yet
+func (s *NonceStore) Used(nonce string, now time.Time) (bool, error) {
+	nonces, err := s.read(now)
+	if err != nil {
+		return false, err
+	}
+	_, used := nonces[nonce]
+	return used, nil
+}
+
+// read returns the nonces that have not expired, with their expiry
+func (s *NonceStore) read(now time.Time) (map[string]time.Time, error) {
+	nonces := make(map[string]time.Time)
+	file, err := os.Open(filepath.Join(s.dir, nonceFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nonces, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read nonces: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		nonce, expiry, found := strings.Cut(scanner.Text(), " ")
+		seconds, err := strconv.ParseInt(expiry, 10, 64)
+		if !found || err != nil {
+			continue
+		}
+		if until := time.Unix(seconds, 0); now.Before(until) {
+			nonces[nonce] = until
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read nonces: %v", err)
+	}
+	return nonces, nil
+}
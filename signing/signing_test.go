@@ -0,0 +1,223 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testKeys creates a key pair, trusted as "ops" unless trusted is false,
+// and a verifier using a fresh nonce store
+func testKeys(t *testing.T, trusted bool) (ed25519.PrivateKey, *Verifier) {
+	t.Helper()
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "ops.pem")
+	if _, err := GenerateKey(keyPath); err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	key, err := LoadPrivateKey(keyPath)
+	if err != nil {
+		t.Fatalf("LoadPrivateKey: %v", err)
+	}
+	keysDir := filepath.Join(dir, "trusted_keys")
+	if trusted {
+		if err := os.Mkdir(keysDir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(keyPath+".pub", filepath.Join(keysDir, "ops.pub")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return key, NewVerifier(keysDir, NewNonceStore(filepath.Join(dir, "state")))
+}
+
+// signBundle signs a bundle for a command after changing it with edit
+func signBundle(t *testing.T, key ed25519.PrivateKey, edit func(*Bundle)) *SignedBundle {
+	t.Helper()
+	bundle, err := NewBundle("uptime", "plain", "sh", time.Hour)
+	if err != nil {
+		t.Fatalf("NewBundle: %v", err)
+	}
+	if edit != nil {
+		edit(bundle)
+	}
+	signed, err := Sign(bundle, key)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return signed
+}
+
+// requireRejected fails unless err is a RejectedError containing the reason
+func requireRejected(t *testing.T, err error, reason string) {
+	t.Helper()
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("error = %v, want a RejectedError", err)
+	}
+	if !strings.Contains(rejected.Reason, reason) {
+		t.Fatalf("reason = %q, want it to contain %q", rejected.Reason, reason)
+	}
+}
+
+func TestVerifyValidBundle(t *testing.T) {
+	key, verifier := testKeys(t, true)
+	signed := signBundle(t, key, nil)
+	bundle, info, err := verifier.Verify(signed, time.Now())
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if bundle.Command != "uptime" || bundle.Executor != "plain" || bundle.Shell != "sh" {
+		t.Errorf("bundle = %+v", bundle)
+	}
+	if info.Signer != "ops" || info.KeyID != KeyID(key.Public().(ed25519.PublicKey)) || info.Nonce != bundle.Nonce {
+		t.Errorf("info = %+v", info)
+	}
+}
+
+func TestVerifyRejectsUntrustedKey(t *testing.T) {
+	key, verifier := testKeys(t, false)
+	_, info, err := verifier.Verify(signBundle(t, key, nil), time.Now())
+	requireRejected(t, err, "is not trusted")
+	if info.Signer != "" {
+		t.Errorf("untrusted bundle has signer %q", info.Signer)
+	}
+}
+
+func TestVerifyRejectsOtherKeysSignature(t *testing.T) {
+	key, verifier := testKeys(t, true)
+	other, _ := testKeys(t, false)
+	signed := signBundle(t, other, nil)
+	signed.KeyID = KeyID(key.Public().(ed25519.PublicKey)) // Claim the trusted key
+	_, _, err := verifier.Verify(signed, time.Now())
+	requireRejected(t, err, "invalid signature")
+}
+
+func TestVerifyRejectsTamperedPayload(t *testing.T) {
+	key, verifier := testKeys(t, true)
+	signed := signBundle(t, key, nil)
+	signed.Payload = []byte(strings.Replace(string(signed.Payload), "uptime", "reboot", 1))
+	bundle, _, err := verifier.Verify(signed, time.Now())
+	requireRejected(t, err, "invalid signature")
+	if bundle == nil || bundle.Command != "reboot" {
+		t.Errorf("rejected bundle = %+v, want what it claims", bundle)
+	}
+}
+
+func TestVerifyRejectsExpiredBundle(t *testing.T) {
+	key, verifier := testKeys(t, true)
+	signed := signBundle(t, key, nil)
+	_, _, err := verifier.Verify(signed, time.Now().Add(time.Hour+time.Second))
+	requireRejected(t, err, "bundle expired")
+}
+
+func TestVerifyRejectsFutureSignedBundle(t *testing.T) {
+	key, verifier := testKeys(t, true)
+	signed := signBundle(t, key, func(bundle *Bundle) {
+		bundle.Signed = bundle.Signed.Add(10 * time.Minute)
+		bundle.Expires = bundle.Expires.Add(10 * time.Minute)
+	})
+	_, _, err := verifier.Verify(signed, time.Now())
+	requireRejected(t, err, "signed in the future")
+
+	// Small clock differences are allowed
+	signed = signBundle(t, key, func(bundle *Bundle) {
+		bundle.Signed = bundle.Signed.Add(time.Minute)
+	})
+	if _, _, err := verifier.Verify(signed, time.Now()); err != nil {
+		t.Fatalf("Verify with 1m clock skew: %v", err)
+	}
+}
+
+func TestVerifyRejectsInvalidFields(t *testing.T) {
+	key, verifier := testKeys(t, true)
+	tests := map[string]func(*Bundle){
+		"unsupported bundle version": func(bundle *Bundle) { bundle.Version = Version + 1 },
+		"bundle has no nonce":        func(bundle *Bundle) { bundle.Nonce = "" },
+		"bundle has no expiry":       func(bundle *Bundle) { bundle.Expires = time.Time{} },
+	}
+	for reason, edit := range tests {
+		_, _, err := verifier.Verify(signBundle(t, key, edit), time.Now())
+		requireRejected(t, err, reason)
+	}
+}
+
+func TestConsumeRejectsReplay(t *testing.T) {
+	key, verifier := testKeys(t, true)
+	signed := signBundle(t, key, nil)
+	now := time.Now()
+
+	bundle, info, err := verifier.Verify(signed, now)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if used, err := verifier.Used(bundle, now); err != nil || used {
+		t.Fatalf("Used before Consume = %v, %v", used, err)
+	}
+	if err := verifier.Consume(bundle, info, now); err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	if used, err := verifier.Used(bundle, now); err != nil || !used {
+		t.Fatalf("Used after Consume = %v, %v", used, err)
+	}
+	requireRejected(t, verifier.Consume(bundle, info, now), "already used (replay)")
+
+	// Another bundle from the same key is unaffected
+	other, otherInfo, err := verifier.Verify(signBundle(t, key, nil), now)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := verifier.Consume(other, otherInfo, now); err != nil {
+		t.Fatalf("Consume of another bundle: %v", err)
+	}
+}
+
+func TestNonceStoreForgetsExpiredNonces(t *testing.T) {
+	store := NewNonceStore(t.TempDir())
+	now := time.Now()
+	if err := store.Use("old", now.Add(time.Minute), now); err != nil {
+		t.Fatalf("Use: %v", err)
+	}
+	later := now.Add(2 * time.Minute)
+	if used, err := store.Used("old", later); err != nil || used {
+		t.Fatalf("Used after expiry = %v, %v", used, err)
+	}
+	if err := store.Use("new", later.Add(time.Minute), later); err != nil {
+		t.Fatalf("Use: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(store.dir, nonceFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "old") {
+		t.Errorf("expired nonce still stored: %q", data)
+	}
+}
+
+func TestParseSignedBundle(t *testing.T) {
+	key, _ := testKeys(t, false)
+	signed := signBundle(t, key, nil)
+	path := filepath.Join(t.TempDir(), "bundle.json")
+	data, err := json.Marshal(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSignedBundle(path)
+	if err != nil {
+		t.Fatalf("LoadSignedBundle: %v", err)
+	}
+	if string(loaded.Payload) != string(signed.Payload) || loaded.KeyID != signed.KeyID {
+		t.Errorf("loaded = %+v", loaded)
+	}
+	if _, err := ParseSignedBundle([]byte(`{"payload":"","signature":""}`)); err == nil {
+		t.Error("ParseSignedBundle accepted a bundle without payload and signature")
+	}
+}
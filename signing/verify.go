@@ -0,0 +1,164 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"execute_command/utils"
+)
+
+// maxClockSkew is how far in the future a bundle's signing time may be
+const maxClockSkew = 5 * time.Minute
+
+// RejectedError is returned when a signed bundle must not run: its
+// signature is invalid or untrusted, it has expired or it was used before
+type RejectedError struct {
+	Reason string
+}
+
+// Error returns the error message for a rejected bundle
+func (e *RejectedError) Error() string {
+	return "signed bundle rejected: " + e.Reason
+}
+
+// rejected returns a RejectedError with a formatted reason
+func rejected(format string, args ...interface{}) error {
+	return &RejectedError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// TrustedKey is a public key whose bundles may run
+type TrustedKey struct {
+	Name string // File name without extension
+	ID   string
+	Key  ed25519.PublicKey
+}
+
+// SignatureInfo describes the signature of a bundle for logs and audit records
+type SignatureInfo struct {
+	Signer string `json:"signer,omitempty"` // Name of the trusted key ("" = not trusted)
+	KeyID  string `json:"key_id"`
+	Nonce  string `json:"nonce,omitempty"`
+}
+
+// String returns the signer as name (key ID)
+func (si *SignatureInfo) String() string {
+	if si.Signer == "" {
+		return "untrusted key " + si.KeyID
+	}
+	return fmt.Sprintf("%s (%s)", si.Signer, si.KeyID)
+}
+
+// DefaultKeysDir returns the default trusted keys directory in the state directory
+func DefaultKeysDir() (string, error) {
+	dir, err := utils.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "trusted_keys"), nil
+}
+
+// LoadTrustedKeys loads every public key (*.pub or *.pem) in a directory. A
+// missing directory has no keys
+func LoadTrustedKeys(dir string) (map[string]*TrustedKey, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]*TrustedKey{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trusted keys: %v", err)
+	}
+
+	keys := make(map[string]*TrustedKey)
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".pub" && ext != ".pem") {
+			continue
+		}
+		public, err := LoadPublicKey(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		key := &TrustedKey{Name: strings.TrimSuffix(entry.Name(), ext), ID: KeyID(public), Key: public}
+		keys[key.ID] = key
+	}
+	return keys, nil
+}
+
+// Verifier checks signed bundles against the trusted keys and records their
+// nonces. The keys are read for every bundle, so keys added to or removed
+// from the directory apply right away
+type Verifier struct {
+	keysDir string
+	nonces  *NonceStore
+	logger  *utils.ModuleLogger
+}
+
+// NewVerifier creates a verifier for the keys in keysDir and the nonces in the store
+func NewVerifier(keysDir string, nonces *NonceStore) *Verifier {
+	return &Verifier{
+		keysDir: keysDir,
+		nonces:  nonces,
+		logger:  utils.GetModuleLogger("signing"),
+	}
+}
+
+// Verify checks the signature and lifetime of a bundle without using its
+// nonce. The error is a RejectedError if the bundle must not run; the bundle
+// and signature info are then what the bundle claims, unverified, or nil if
+// its payload is invalid
+func (v *Verifier) Verify(sb *SignedBundle, now time.Time) (*Bundle, *SignatureInfo, error) {
+	info := &SignatureInfo{KeyID: sb.KeyID}
+	bundle, err := sb.Bundle()
+	if err != nil {
+		return nil, info, rejected("%v", err)
+	}
+	info.Nonce = bundle.Nonce
+
+	keys, err := LoadTrustedKeys(v.keysDir)
+	if err != nil {
+		return bundle, info, err
+	}
+	key, ok := keys[sb.KeyID]
+	if !ok {
+		return bundle, info, rejected("key %s is not trusted", sb.KeyID)
+	}
+	if !ed25519.Verify(key.Key, signedMessage(sb.Payload), sb.Signature) {
+		return bundle, info, rejected("invalid signature for key %s", sb.KeyID)
+	}
+	info.Signer = key.Name
+
+	switch {
+	case bundle.Version != Version:
+		return bundle, info, rejected("unsupported bundle version %d", bundle.Version)
+	case bundle.Nonce == "":
+		return bundle, info, rejected("bundle has no nonce")
+	case bundle.Expires.IsZero():
+		return bundle, info, rejected("bundle has no expiry")
+	case !now.Before(bundle.Expires):
+		return bundle, info, rejected("bundle expired at %s", bundle.Expires.Format(time.RFC3339))
+	case bundle.Signed.After(now.Add(maxClockSkew)):
+		return bundle, info, rejected("bundle is signed in the future (%s)", bundle.Signed.Format(time.RFC3339))
+	}
+	return bundle, info, nil
+}
+
+// Consume uses the nonce of a verified bundle, so the bundle runs only once
+// on this host. Callers check everything else before consuming it, so a
+// bundle refused for other reasons can still be used
+func (v *Verifier) Consume(bundle *Bundle, info *SignatureInfo, now time.Time) error {
+	if err := v.nonces.Use(bundle.Nonce, bundle.Expires, now); err != nil {
+		return err
+	}
+	v.logger.Info("Accepted bundle %s signed by %s", bundle.Nonce, info)
+	return nil
+}
+
+// Used reports whether a bundle's nonce was already used on this host
+func (v *Verifier) Used(bundle *Bundle, now time.Time) (bool, error) {
+	return v.nonces.Used(bundle.Nonce, now)
+}
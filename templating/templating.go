@@ -0,0 +1,270 @@
+package templating
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"execute_command/executor"
+	"execute_command/quote"
+	"execute_command/utils"
+)
+
+// EnvPrefix is the prefix of environment variables that provide template values
+// (e.g. EXEC_VAR_host=web01 fills {{.host}})
+const EnvPrefix = "EXEC_VAR_"
+
+// SecretFunc returns the text that stands for a secret in a rendered command,
+// e.g. a reference to the environment variable carrying its value
+type SecretFunc func(name string) (string, error)
+
+// Variables holds the values available to command templates
+type Variables map[string]string
+
+// Merge copies all values from other into the variables, overriding existing keys
+func (v Variables) Merge(other Variables) {
+	for key, value := range other {
+		v[key] = value
+	}
+}
+
+// ParseVar parses a variable definition of the form key=value
+func ParseVar(definition string) (string, string, error) {
+	key, value, found := strings.Cut(definition, "=")
+	key = strings.TrimSpace(key)
+	if !found || key == "" {
+		return "", "", fmt.Errorf("invalid variable %q (expected key=value)", definition)
+	}
+	return key, value, nil
+}
+
+// LoadVarsFile loads variables from a file. Files ending in .json must contain
+// a JSON object of strings; any other file is read as key=value lines, where
+// blank lines and lines starting with # are ignored
+func LoadVarsFile(path string) (Variables, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vars file: %v", err)
+	}
+
+	vars := Variables{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.Unmarshal(data, &vars); err != nil {
+			return nil, fmt.Errorf("failed to parse vars file %s: %v", path, err)
+		}
+		return vars, nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, err := ParseVar(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNumber, err)
+		}
+		vars[key] = value
+	}
+	return vars, nil
+}
+
+// VarsFromEnvironment returns the variables defined through EXEC_VAR_* environment variables
+func VarsFromEnvironment() Variables {
+	vars := Variables{}
+	for _, entry := range os.Environ() {
+		if !strings.HasPrefix(entry, EnvPrefix) {
+			continue
+		}
+		key, value, _ := strings.Cut(strings.TrimPrefix(entry, EnvPrefix), "=")
+		if key != "" {
+			vars[key] = value
+		}
+	}
+	return vars
+}
+
+// escapingFuncs are the functions whose output is inserted as is. The output
+// of any other placeholder is quoted for the shell the command runs in
+var escapingFuncs = map[string]bool{
+	"quote":      true,
+	"sh":         true,
+	"cmd":        true,
+	"powershell": true,
+	"raw":        true,
+	"secret":     true,
+}
+
+// Render fills the placeholders of a command template. Every placeholder is
+// quoted for the shell the command runs in unless its last function is one of:
+//
+//	quote       - quote for the shell the command runs in
+//	sh          - quote for sh (unchanged if no quoting is needed)
+//	cmd         - quote for cmd.exe (values with line breaks are refused)
+//	powershell  - quote for PowerShell
+//	raw         - insert the value verbatim
+//
+// {{secret "name"}} inserts the text returned by the secret function; it
+// fails if secret is nil. Every referenced variable must be defined;
+// otherwise an error listing the missing variables is returned before
+// anything is run.
+func Render(command string, vars Variables, shellType executor.ShellType, secret SecretFunc) (string, error) {
+	logger := utils.GetModuleLogger("templating")
+
+	tmpl, err := template.New("command").
+		Option("missingkey=error").
+		Funcs(templateFuncs(executor.ResolveShellType(shellType), secret)).
+		Parse(command)
+	if err != nil {
+		return "", fmt.Errorf("invalid command template: %v", err)
+	}
+	for _, t := range tmpl.Templates() {
+		quoteActions(t.Tree.Root)
+	}
+
+	if missing := missingVariables(tmpl, vars); len(missing) > 0 {
+		return "", fmt.Errorf("unfilled template variables: %s", strings.Join(missing, ", "))
+	}
+
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, map[string]string(vars)); err != nil {
+		return "", fmt.Errorf("failed to render command template: %v", err)
+	}
+
+	logger.Debug("Rendered command template with %d variables", len(vars))
+	return rendered.String(), nil
+}
+
+// templateFuncs returns the escaping and secret functions available to templates
+func templateFuncs(shellType executor.ShellType, secret SecretFunc) template.FuncMap {
+	return template.FuncMap{
+		"quote": func(value interface{}) (string, error) {
+			return quoteFor(fmt.Sprint(value), shellType)
+		},
+		"sh": quote.QuoteSh,
+		"cmd": func(value string) (string, error) {
+			return quoteFor(value, executor.CMDShell)
+		},
+		"powershell": quote.QuotePowerShell,
+		"raw": func(value string) string {
+			return value
+		},
+		"secret": func(name string) (string, error) {
+			if secret == nil {
+				return "", fmt.Errorf("secrets are not available here")
+			}
+			return secret(name)
+		},
+	}
+}
+
+// quoteFor quotes a value for a shell. cmd.exe ends the command at a line
+// break, which no escaping prevents, so such values are refused for cmd
+func quoteFor(value string, shellType executor.ShellType) (string, error) {
+	if shellType == executor.CMDShell && strings.ContainsAny(value, "\r\n") {
+		return "", fmt.Errorf("a value with a line break can't be quoted for cmd")
+	}
+	return quote.Quote(value, shellType), nil
+}
+
+// quoteActions appends the quote function to every placeholder under a node
+// that prints a value and doesn't end in an escaping function
+func quoteActions(node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			quoteActions(child)
+		}
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) > 0 || len(n.Pipe.Cmds) == 0 {
+			return // Variable declarations print nothing
+		}
+		last := n.Pipe.Cmds[len(n.Pipe.Cmds)-1]
+		if ident, ok := last.Args[0].(*parse.IdentifierNode); ok && escapingFuncs[ident.Ident] {
+			return
+		}
+		n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+			NodeType: parse.NodeCommand,
+			Pos:      n.Pos,
+			Args:     []parse.Node{parse.NewIdentifier("quote").SetPos(n.Pos)},
+		})
+	case *parse.IfNode:
+		quoteActions(n.List)
+		quoteActions(n.ElseList)
+	case *parse.RangeNode:
+		quoteActions(n.List)
+		quoteActions(n.ElseList)
+	case *parse.WithNode:
+		quoteActions(n.List)
+		quoteActions(n.ElseList)
+	}
+}
+
+// missingVariables returns the sorted names of variables referenced by the
+// template that are not defined
+func missingVariables(tmpl *template.Template, vars Variables) []string {
+	referenced := map[string]bool{}
+	collectFields(tmpl.Tree.Root, referenced)
+
+	var missing []string
+	for name := range referenced {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// collectFields records the top-level field names (.name) referenced under a template node
+func collectFields(node parse.Node, fields map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectFields(child, fields)
+		}
+	case *parse.ActionNode:
+		collectFields(n.Pipe, fields)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			collectFields(cmd, fields)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			collectFields(arg, fields)
+		}
+	case *parse.FieldNode:
+		fields[n.Ident[0]] = true
+	case *parse.ChainNode:
+		collectFields(n.Node, fields)
+	case *parse.IfNode:
+		collectBranch(&n.BranchNode, fields)
+	case *parse.RangeNode:
+		collectBranch(&n.BranchNode, fields)
+	case *parse.WithNode:
+		collectBranch(&n.BranchNode, fields)
+	}
+}
+
+// collectBranch records the fields referenced by an if/range/with node
+func collectBranch(branch *parse.BranchNode, fields map[string]bool) {
+	collectFields(branch.Pipe, fields)
+	collectFields(branch.List, fields)
+	collectFields(branch.ElseList, fields)
+}
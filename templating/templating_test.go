@@ -0,0 +1,189 @@
+package templating
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"execute_command/executor"
+)
+
+// payloads are values that break out of a command if they are not quoted
+var payloads = []string{
+	"it's",
+	"'; rm -rf / #",
+	`"; rm -rf / #`,
+	"a\nrm -rf /",
+	"$(id) `id` $HOME",
+	"%PATH%",
+	"^&calc",
+	"!x!",
+	"a’b",
+	"",
+}
+
+func TestRenderQuotesForEachShell(t *testing.T) {
+	tests := []struct {
+		shellType executor.ShellType
+		value     string
+		want      string
+	}{
+		{executor.ShShell, "it's", `echo 'it'\''s'`},
+		{executor.ShShell, "'; rm -rf / #", `echo ''\''; rm -rf / #'`},
+		{executor.ShShell, `"; rm -rf / #`, `echo '"; rm -rf / #'`},
+		{executor.ShShell, "a\nrm -rf /", "echo 'a\nrm -rf /'"},
+		{executor.ShShell, "$(id) `id` $HOME", "echo '$(id) `id` $HOME'"},
+		{executor.ShShell, "%PATH%", "echo %PATH%"},
+		{executor.ShShell, "^&calc", "echo '^&calc'"},
+		{executor.ShShell, "!x!", "echo '!x!'"},
+		{executor.ShShell, "", "echo ''"},
+
+		{executor.PowerShellShell, "it's", "echo 'it''s'"},
+		{executor.PowerShellShell, "'; Remove-Item C:\\ #", "echo '''; Remove-Item C:\\ #'"},
+		{executor.PowerShellShell, `"; rm -rf / #`, `echo '"; rm -rf / #'`},
+		{executor.PowerShellShell, "a\nRemove-Item x", "echo 'a\nRemove-Item x'"},
+		{executor.PowerShellShell, "$(calc) `n $env:PATH", "echo '$(calc) `n $env:PATH'"},
+		{executor.PowerShellShell, "a’b", "echo 'a’’b'"},
+		{executor.PowerShellShell, "%PATH% ^& !x!", "echo '%PATH% ^& !x!'"},
+
+		{executor.CMDShell, "it's", `echo ^"it's^"`},
+		{executor.CMDShell, `"& calc`, `echo ^"\^"^& calc^"`},
+		{executor.CMDShell, "%PATH%", `echo ^"^%PATH^%^"`},
+		{executor.CMDShell, "^&calc", `echo ^"^^^&calc^"`},
+		{executor.CMDShell, "!x!", `echo ^"^!x^!^"`},
+		{executor.CMDShell, "a|b>c", `echo ^"a^|b^>c^"`},
+		{executor.CMDShell, `C:\dir\`, `echo ^"C:\dir\\^"`},
+		{executor.CMDShell, "", `echo ^"^"`},
+	}
+	for _, test := range tests {
+		got, err := Render("echo {{.v}}", Variables{"v": test.value}, test.shellType, nil)
+		if err != nil {
+			t.Errorf("Render(%q, %s): %v", test.value, test.shellType, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Render(%q, %s) = %s, want %s", test.value, test.shellType, got, test.want)
+		}
+	}
+}
+
+func TestRenderRefusesLineBreaksForCmd(t *testing.T) {
+	for _, template := range []string{"echo {{.v}}", "echo {{.v | quote}}", "echo {{cmd .v}}"} {
+		for _, value := range []string{"a\nb", "a\rb", "a\r\ndel /q C:\\"} {
+			if got, err := Render(template, Variables{"v": value}, executor.CMDShell, nil); err == nil {
+				t.Errorf("Render(%q, %q) = %s, want an error", template, value, got)
+			}
+		}
+	}
+	// {{cmd}} refuses them whatever the shell; sh and PowerShell keep them
+	// inside their single quotes
+	if _, err := Render("echo {{cmd .v}}", Variables{"v": "a\nb"}, executor.ShShell, nil); err == nil {
+		t.Error("cmd accepted a line break with the sh shell")
+	}
+	for _, shellType := range []executor.ShellType{executor.ShShell, executor.PowerShellShell} {
+		if _, err := Render("echo {{.v}}", Variables{"v": "a\nb"}, shellType, nil); err != nil {
+			t.Errorf("Render with %s: %v", shellType, err)
+		}
+	}
+}
+
+func TestRenderShRoundTrip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sh round trips run on Unix only")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	for _, value := range payloads {
+		command, err := Render("printf %s {{.v}}", Variables{"v": value}, executor.ShShell, nil)
+		if err != nil {
+			t.Fatalf("Render(%q): %v", value, err)
+		}
+		output, err := exec.Command("sh", "-c", command).Output()
+		if err != nil {
+			t.Fatalf("sh -c %q: %v", command, err)
+		}
+		if string(output) != value {
+			t.Errorf("sh -c %q printed %q, want %q", command, output, value)
+		}
+	}
+}
+
+func TestRenderEscapingFuncs(t *testing.T) {
+	vars := Variables{"v": "a b'c", "flags": "-l -a"}
+	tests := map[string]string{
+		"ls {{.v}}":                     `ls 'a b'\''c'`,
+		"ls {{.v | quote}}":             `ls 'a b'\''c'`,
+		"ls {{sh .v}}":                  `ls 'a b'\''c'`,
+		"ls {{powershell .v}}":          `ls 'a b''c'`,
+		"ls {{cmd .v}}":                 `ls ^"a b'c^"`,
+		"ls {{raw .flags}}":             "ls -l -a",
+		"ls {{printf \"%s-x\" .v}}":     `ls 'a b'\''c-x'`,
+		"{{if .v}}ls {{.flags}}{{end}}": "ls '-l -a'",
+		"{{with .v}}ls {{.}}{{end}}":    `ls 'a b'\''c'`,
+		"{{$f := .flags}}ls {{$f}}":     "ls '-l -a'",
+	}
+	for template, want := range tests {
+		got, err := Render(template, vars, executor.ShShell, nil)
+		if err != nil {
+			t.Errorf("Render(%q): %v", template, err)
+		} else if got != want {
+			t.Errorf("Render(%q) = %s, want %s", template, got, want)
+		}
+	}
+}
+
+func TestRenderSecrets(t *testing.T) {
+	secret := func(name string) (string, error) {
+		return `"$EXEC_SECRET_` + strings.ToUpper(name) + `"`, nil
+	}
+	got, err := Render(`PGPASSWORD={{secret "db"}} psql`, nil, executor.ShShell, secret)
+	if err != nil || got != `PGPASSWORD="$EXEC_SECRET_DB" psql` {
+		t.Errorf("Render = %s, %v", got, err)
+	}
+	if _, err := Render(`{{secret "db"}}`, nil, executor.ShShell, nil); err == nil {
+		t.Error("secret rendered without a secret function")
+	}
+}
+
+func TestRenderReportsMissingVariables(t *testing.T) {
+	_, err := Render("scp {{.file}} {{.host}}:{{.dir}}", Variables{"host": "web01"}, executor.ShShell, nil)
+	if err == nil || !strings.Contains(err.Error(), "unfilled template variables: dir, file") {
+		t.Errorf("Render error = %v, want the missing dir and file", err)
+	}
+	if _, err := Render("echo {{.v", nil, executor.ShShell, nil); err == nil {
+		t.Error("Render accepted an invalid template")
+	}
+}
+
+func TestLoadVarsFile(t *testing.T) {
+	dir := t.TempDir()
+	lines := filepath.Join(dir, "vars.env")
+	if err := os.WriteFile(lines, []byte("# hosts\nhost=web01\n\nquery=a=b\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	vars, err := LoadVarsFile(lines)
+	if err != nil || !reflect.DeepEqual(vars, Variables{"host": "web01", "query": "a=b"}) {
+		t.Errorf("LoadVarsFile = %v, %v", vars, err)
+	}
+
+	object := filepath.Join(dir, "vars.json")
+	if err := os.WriteFile(object, []byte(`{"host": "web01"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if vars, err := LoadVarsFile(object); err != nil || vars["host"] != "web01" {
+		t.Errorf("LoadVarsFile = %v, %v", vars, err)
+	}
+
+	invalid := filepath.Join(dir, "invalid.env")
+	if err := os.WriteFile(invalid, []byte("host\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadVarsFile(invalid); err == nil || !strings.Contains(err.Error(), "invalid.env:1") {
+		t.Errorf("LoadVarsFile error = %v, want the line", err)
+	}
+}
@@ -0,0 +1,214 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServiceName is the service.name resource attribute of exported traces
+const ServiceName = "execute_command"
+
+// DefaultOTLPEndpoint is the OTLP/HTTP endpoint of a local collector
+const DefaultOTLPEndpoint = "http://localhost:4318"
+
+// Exporter sends the spans of a finished trace somewhere
+type Exporter interface {
+	Export(spans []*SpanData) error
+}
+
+// NewExporter creates the exporter for a -trace value: "otlp" to send
+// traces to an OTLP/HTTP collector at the endpoint, "stdout", or the path
+// of a file to append JSON lines to
+func NewExporter(target, endpoint string) (Exporter, error) {
+	switch target {
+	case "otlp":
+		if endpoint == "" {
+			endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		}
+		if endpoint == "" {
+			endpoint = DefaultOTLPEndpoint
+		}
+		if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+			return nil, fmt.Errorf("invalid OTLP endpoint %q (use http://host:port)", endpoint)
+		}
+		return NewOTLPExporter(endpoint), nil
+	case "stdout":
+		return &FileExporter{writer: os.Stdout}, nil
+	case "":
+		return nil, fmt.Errorf("no trace exporter given")
+	default:
+		return &FileExporter{path: target}, nil
+	}
+}
+
+// FileExporter writes one JSON object per span, for offline use
+type FileExporter struct {
+	path   string    // File to append to (unless writer is set)
+	writer io.Writer // e.g. os.Stdout
+	mu     sync.Mutex
+}
+
+// fileSpan is the JSON form of a span written by FileExporter
+type fileSpan struct {
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_span_id,omitempty"`
+	Name       string                 `json:"name"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	DurationMs float64                `json:"duration_ms"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// Export writes the spans, oldest first
+func (fe *FileExporter) Export(spans []*SpanData) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, span := range sortByStart(spans) {
+		record := fileSpan{
+			TraceID:    span.TraceID.String(),
+			SpanID:     span.SpanID.String(),
+			Name:       span.Name,
+			Start:      span.Start.UTC(),
+			End:        span.End.UTC(),
+			DurationMs: float64(span.End.Sub(span.Start).Microseconds()) / 1000,
+			Attributes: span.Attributes,
+			Error:      span.Error,
+		}
+		if span.ParentID.IsValid() {
+			record.ParentID = span.ParentID.String()
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	if fe.writer != nil {
+		_, err := fe.writer.Write(buf.Bytes())
+		return err
+	}
+	file, err := os.OpenFile(fe.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(buf.Bytes())
+	return err
+}
+
+// OTLPExporter sends traces to an OpenTelemetry collector using OTLP/HTTP
+// with JSON encoding
+type OTLPExporter struct {
+	url    string
+	client *http.Client
+}
+
+// NewOTLPExporter creates an exporter for a collector such as
+// http://localhost:4318; traces are posted to /v1/traces
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	return &OTLPExporter{url: url, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// Export posts the spans to the collector
+func (oe *OTLPExporter) Export(spans []*SpanData) error {
+	otlpSpans := make([]map[string]interface{}, 0, len(spans))
+	for _, span := range sortByStart(spans) {
+		otlpSpan := map[string]interface{}{
+			"traceId":           span.TraceID.String(),
+			"spanId":            span.SpanID.String(),
+			"name":              span.Name,
+			"kind":              1, // SPAN_KIND_INTERNAL
+			"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+			"attributes":        otlpAttributes(span.Attributes),
+		}
+		if span.ParentID.IsValid() {
+			otlpSpan["parentSpanId"] = span.ParentID.String()
+		}
+		if span.Error != "" {
+			otlpSpan["status"] = map[string]interface{}{"code": 2, "message": span.Error} // STATUS_CODE_ERROR
+		}
+		otlpSpans = append(otlpSpans, otlpSpan)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": ServiceName}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": ServiceName},
+				"spans": otlpSpans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	response, err := oe.client.Post(oe.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("collector at %s returned %s", oe.url, response.Status)
+	}
+	return nil
+}
+
+// otlpAttributes converts attributes to OTLP key/value pairs, sorted by key
+func otlpAttributes(attributes map[string]interface{}) []map[string]interface{} {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	list := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		var value map[string]interface{}
+		switch v := attributes[key].(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": v}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		list = append(list, map[string]interface{}{"key": key, "value": value})
+	}
+	return list
+}
+
+// sortByStart returns the spans ordered by start time
+func sortByStart(spans []*SpanData) []*SpanData {
+	sorted := append([]*SpanData(nil), spans...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return sorted[a].Start.Before(sorted[b].Start)
+	})
+	return sorted
+}
@@ -0,0 +1,274 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"execute_command/utils"
+)
+
+// TraceparentEnv carries the W3C trace context into and out of the process
+const TraceparentEnv = "TRACEPARENT"
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// String returns the trace ID in hex
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the trace ID is not all zeros
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// String returns the span ID in hex
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the span ID is not all zeros
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext is the part of a span that is propagated to other processes
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether the context has a trace and span ID
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the context as a W3C traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header value, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("invalid traceparent %q", value)
+	}
+	traceID, err1 := hex.DecodeString(parts[1])
+	spanID, err2 := hex.DecodeString(parts[2])
+	flags, err3 := hex.DecodeString(parts[3])
+	if err1 != nil || err2 != nil || err3 != nil || len(traceID) != 16 || len(spanID) != 8 || len(flags) != 1 {
+		return sc, fmt.Errorf("invalid traceparent %q", value)
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent %q: zero trace or span ID", value)
+	}
+	return sc, nil
+}
+
+// EnvironmentParent returns the trace context passed in TRACEPARENT by the
+// process that started this one, or an invalid context if there is none
+func EnvironmentParent() (SpanContext, error) {
+	value := os.Getenv(TraceparentEnv)
+	if value == "" {
+		return SpanContext{}, nil
+	}
+	return ParseTraceparent(value)
+}
+
+// Tracer creates spans and exports each trace once its root span ends
+type Tracer struct {
+	exporter Exporter
+	logger   *utils.ModuleLogger
+	mu       sync.Mutex
+	pending  map[TraceID][]*SpanData // Ended spans waiting for their root
+}
+
+// NewTracer creates a tracer that sends finished traces to the exporter
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{
+		exporter: exporter,
+		logger:   utils.GetModuleLogger("tracing"),
+		pending:  make(map[TraceID][]*SpanData),
+	}
+}
+
+// Start starts the root span of a trace in this process. A valid parent,
+// e.g. from TRACEPARENT, joins the trace of another process. A nil tracer
+// returns a nil span, which records nothing
+func (t *Tracer) Start(name string, parent SpanContext) *Span {
+	return t.StartAt(name, parent, time.Now())
+}
+
+// StartAt is Start with an explicit start time
+func (t *Tracer) StartAt(name string, parent SpanContext, start time.Time) *Span {
+	if t == nil {
+		return nil
+	}
+	span := &Span{tracer: t, root: true, data: SpanData{Name: name, Start: start, Attributes: make(map[string]interface{})}}
+	if parent.IsValid() {
+		span.data.TraceID = parent.TraceID
+		span.data.ParentID = parent.SpanID
+	} else {
+		span.data.TraceID = newTraceID()
+	}
+	span.data.SpanID = newSpanID()
+	return span
+}
+
+// finish queues an ended span and exports its trace when the root ends
+func (t *Tracer) finish(span *Span) {
+	t.mu.Lock()
+	data := span.data
+	spans := append(t.pending[data.TraceID], &data)
+	if !span.root {
+		t.pending[data.TraceID] = spans
+		t.mu.Unlock()
+		return
+	}
+	delete(t.pending, data.TraceID)
+	t.mu.Unlock()
+
+	if err := t.exporter.Export(spans); err != nil {
+		t.logger.Warn("Failed to export trace %s: %v", data.TraceID, err)
+	}
+}
+
+// SpanData is a finished span as exported
+type SpanData struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	ParentID   SpanID // Zero for the root of a trace
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{} // string, bool, int, int64 or float64 values
+	Error      string                 // Set when the span failed
+}
+
+// Span is an operation within a trace. All methods do nothing on a nil span
+type Span struct {
+	tracer *Tracer
+	root   bool // Root of the trace in this process; exports it when it ends
+	mu     sync.Mutex
+	ended  bool
+	data   SpanData
+}
+
+// Child starts a span for a sub-operation
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return &Span{
+		tracer: s.tracer,
+		data: SpanData{
+			TraceID:    s.data.TraceID,
+			SpanID:     newSpanID(),
+			ParentID:   s.data.SpanID,
+			Name:       name,
+			Start:      time.Now(),
+			Attributes: make(map[string]interface{}),
+		},
+	}
+}
+
+// ChildAt starts a span for a sub-operation that started earlier, e.g.
+// one that ran before tracing was set up
+func (s *Span) ChildAt(name string, start time.Time) *Span {
+	child := s.Child(name)
+	if child != nil {
+		child.data.Start = start
+	}
+	return child
+}
+
+// SetAttribute tags the span with a value
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes[key] = value
+}
+
+// RecordError marks the span as failed (nil errors are ignored)
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+// Context returns the span's context for propagation
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID, Sampled: true}
+}
+
+// Traceparent returns the W3C traceparent of the span, or "" for a nil span
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return s.Context().Traceparent()
+}
+
+// End finishes the span. Ending the root span exports the whole trace
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt finishes the span at the given time
+func (s *Span) EndAt(end time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = end
+	s.mu.Unlock()
+	s.tracer.finish(s)
+}
+
+// newTraceID returns a random trace ID
+func newTraceID() TraceID {
+	var id TraceID
+	rand.Read(id[:])
+	return id
+}
+
+// newSpanID returns a random span ID
+func newSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:])
+	return id
+}
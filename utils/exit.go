@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// exitHookTimeout bounds the time the exit hooks may take in total
+const exitHookTimeout = 5 * time.Second
+
+var (
+	exitMu    sync.Mutex
+	exitHooks = make(map[int]func())
+	nextHook  int
+	exiting   bool
+)
+
+// RegisterExitHook registers a function that Exit runs before the process
+// exits, e.g. to kill a running child. The returned function unregisters it
+func RegisterExitHook(hook func()) func() {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+	id := nextHook
+	nextHook++
+	exitHooks[id] = hook
+	return func() {
+		exitMu.Lock()
+		defer exitMu.Unlock()
+		delete(exitHooks, id)
+	}
+}
+
+// Exit runs the exit hooks, most recently registered first, closes the
+// global logger's sinks and exits with the code. Hooks that take longer than
+// exitHookTimeout are abandoned. Calls made while exiting block until the
+// first one exits
+func Exit(code int) {
+	exitMu.Lock()
+	if exiting {
+		exitMu.Unlock()
+		select {}
+	}
+	exiting = true
+	ids := make([]int, 0, len(exitHooks))
+	for id := range exitHooks {
+		ids = append(ids, id)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	hooks := make([]func(), 0, len(ids))
+	for _, id := range ids {
+		hooks = append(hooks, exitHooks[id])
+	}
+	exitMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, hook := range hooks {
+			runExitHook(hook)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(exitHookTimeout):
+		fmt.Fprintf(os.Stderr, "Exit hooks did not finish within %v\n", exitHookTimeout)
+	}
+
+	CloseGlobalLogger()
+	os.Exit(code)
+}
+
+// runExitHook runs a hook, so that a panicking hook doesn't stop the others
+func runExitHook(hook func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "Exit hook panicked: %v\n", r)
+		}
+	}()
+	hook()
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package utils
+
+import (
+	"os"
+)
+
+// LockFile is a no-op on Windows; writers are only serialized within the process
+func LockFile(file *os.File) error {
+	return nil
+}
+
+// UnlockFile is a no-op on Windows
+func UnlockFile(file *os.File) error {
+	return nil
+}
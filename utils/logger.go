@@ -1,10 +1,14 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"path"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -37,6 +41,41 @@ func (l LogLevel) String() string {
 	}
 }
 
+// LogFormat is the format of log lines
+type LogFormat int
+
+const (
+	TextFormat LogFormat = iota // Human readable, colored on a terminal
+	JSONFormat                  // One JSON object per line
+)
+
+// String returns the string representation of LogFormat
+func (f LogFormat) String() string {
+	switch f {
+	case TextFormat:
+		return "text"
+	case JSONFormat:
+		return "json"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseLogFormat parses a string to LogFormat
+func ParseLogFormat(format string) (LogFormat, error) {
+	switch strings.ToLower(format) {
+	case "text", "":
+		return TextFormat, nil
+	case "json":
+		return JSONFormat, nil
+	default:
+		return TextFormat, fmt.Errorf("invalid log format: %s (use text or json)", format)
+	}
+}
+
+// Fields are structured key/value pairs attached to a log line
+type Fields map[string]interface{}
+
 // Logger interface defines the logging methods
 type Logger interface {
 	Debug(format string, args ...interface{})
@@ -48,10 +87,11 @@ type Logger interface {
 	SetOutput(writer io.Writer)
 }
 
-// ModuleLogger wraps a logger with module name
+// ModuleLogger wraps a logger with module name and the fields added by With
 type ModuleLogger struct {
 	logger Logger
 	module string
+	fields Fields
 }
 
 // NewModuleLogger creates a new module logger
@@ -62,10 +102,38 @@ func NewModuleLogger(logger Logger, module string) *ModuleLogger {
 	}
 }
 
+// With returns a child logger that adds fields to every line, given as
+// alternating keys and values, e.g. With("job_id", id, "shell", "sh").
+// The parent's fields are kept unless a key is given again
+func (ml *ModuleLogger) With(keyValues ...interface{}) *ModuleLogger {
+	fields := make(Fields, len(keyValues)/2)
+	for i := 0; i < len(keyValues); i += 2 {
+		key := fmt.Sprint(keyValues[i])
+		if i+1 < len(keyValues) {
+			fields[key] = keyValues[i+1]
+		} else {
+			fields[key] = nil
+		}
+	}
+	return ml.WithFields(fields)
+}
+
+// WithFields returns a child logger that adds the fields to every line
+func (ml *ModuleLogger) WithFields(fields Fields) *ModuleLogger {
+	merged := make(Fields, len(ml.fields)+len(fields))
+	for key, value := range ml.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return &ModuleLogger{logger: ml.logger, module: ml.module, fields: merged}
+}
+
 // Debug logs a debug message with module name
 func (ml *ModuleLogger) Debug(format string, args ...interface{}) {
 	if sl, ok := ml.logger.(*SimpleLogger); ok {
-		sl.logWithModule(DEBUG, ml.module, format, args...)
+		sl.logWithModule(DEBUG, ml.module, ml.fields, format, args...)
 	} else {
 		ml.logger.Debug(format, args...)
 	}
@@ -74,7 +142,7 @@ func (ml *ModuleLogger) Debug(format string, args ...interface{}) {
 // Info logs an info message with module name
 func (ml *ModuleLogger) Info(format string, args ...interface{}) {
 	if sl, ok := ml.logger.(*SimpleLogger); ok {
-		sl.logWithModule(INFO, ml.module, format, args...)
+		sl.logWithModule(INFO, ml.module, ml.fields, format, args...)
 	} else {
 		ml.logger.Info(format, args...)
 	}
@@ -83,7 +151,7 @@ func (ml *ModuleLogger) Info(format string, args ...interface{}) {
 // Warn logs a warning message with module name
 func (ml *ModuleLogger) Warn(format string, args ...interface{}) {
 	if sl, ok := ml.logger.(*SimpleLogger); ok {
-		sl.logWithModule(WARN, ml.module, format, args...)
+		sl.logWithModule(WARN, ml.module, ml.fields, format, args...)
 	} else {
 		ml.logger.Warn(format, args...)
 	}
@@ -92,16 +160,18 @@ func (ml *ModuleLogger) Warn(format string, args ...interface{}) {
 // Error logs an error message with module name
 func (ml *ModuleLogger) Error(format string, args ...interface{}) {
 	if sl, ok := ml.logger.(*SimpleLogger); ok {
-		sl.logWithModule(ERROR, ml.module, format, args...)
+		sl.logWithModule(ERROR, ml.module, ml.fields, format, args...)
 	} else {
 		ml.logger.Error(format, args...)
 	}
 }
 
-// Fatal logs a fatal message with module name
+// Fatal logs a fatal message with module name, then exits like
+// SimpleLogger.Fatal
 func (ml *ModuleLogger) Fatal(format string, args ...interface{}) {
 	if sl, ok := ml.logger.(*SimpleLogger); ok {
-		sl.logWithModule(FATAL, ml.module, format, args...)
+		sl.logWithModule(FATAL, ml.module, ml.fields, format, args...)
+		Exit(1)
 	} else {
 		ml.logger.Fatal(format, args...)
 	}
@@ -117,89 +187,375 @@ func (ml *ModuleLogger) SetOutput(writer io.Writer) {
 	ml.logger.SetOutput(writer)
 }
 
-// SimpleLogger implements the Logger interface
+// SimpleLogger implements the Logger interface. It is safe for concurrent
+// use: settings may change while other goroutines log, and each line is
+// written to the sinks in one piece
 type SimpleLogger struct {
-	level  LogLevel
-	output io.Writer
+	mu           sync.RWMutex // Guards the settings below
+	level        LogLevel
+	moduleLevels map[string]LogLevel // Overrides by module name or wildcard pattern
+	sinks        []sink              // Replaced, never modified in place
+	redact       func(string) string // Masks secrets in messages and fields (nil = none)
+
+	writeMu sync.Mutex // Serializes writes so lines don't interleave
+}
+
+// Sink is a destination of log lines with its own format
+type Sink struct {
+	Writer io.Writer
+	Format LogFormat
+}
+
+// SinkConfig describes a sink to open: stdout, stderr or a file path
+type SinkConfig struct {
+	Target string
+	Format LogFormat
+	Rotate RotateOptions // Rotation of a file (ignored for stdout and stderr)
+}
+
+// OpenSink opens the destination of a sink. Files are appended to, and
+// rotated according to the config
+func OpenSink(config SinkConfig) (Sink, error) {
+	switch config.Target {
+	case "stdout":
+		return Sink{Writer: os.Stdout, Format: config.Format}, nil
+	case "stderr":
+		return Sink{Writer: os.Stderr, Format: config.Format}, nil
+	default:
+		file, err := OpenRotatingFile(config.Target, config.Rotate)
+		if err != nil {
+			return Sink{}, err
+		}
+		return Sink{Writer: file, Format: config.Format}, nil
+	}
+}
+
+// sink is a Sink and whether its text lines are colored
+type sink struct {
+	Sink
+	color bool
 }
 
 // NewLogger creates a new logger instance
 func NewLogger(level LogLevel) Logger {
-	return &SimpleLogger{
-		level:  level,
-		output: os.Stdout,
-	}
+	return NewLoggerWithOutput(level, os.Stdout)
 }
 
 // NewLoggerWithOutput creates a new logger with custom output
 func NewLoggerWithOutput(level LogLevel, output io.Writer) Logger {
-	return &SimpleLogger{
-		level:  level,
-		output: output,
+	logger := &SimpleLogger{level: level}
+	logger.SetSinks(Sink{Writer: output, Format: TextFormat})
+	return logger
+}
+
+// colorEnabled reports whether text written to output should be colored:
+// only on a terminal, and never when NO_COLOR is set
+func colorEnabled(output io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
 	}
+	file, ok := output.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
 
 // SetLevel sets the logging level
 func (l *SimpleLogger) SetLevel(level LogLevel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	l.level = level
 }
 
-// SetOutput sets the output writer
+// Level returns the logging level of modules without an override
+func (l *SimpleLogger) Level() LogLevel {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.level
+}
+
+// SetModuleLevel overrides the level of the modules matching a pattern: a
+// module name such as executor.base64, or a wildcard such as executor.*
+func (l *SimpleLogger) SetModuleLevel(pattern string, level LogLevel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.moduleLevels == nil {
+		l.moduleLevels = make(map[string]LogLevel)
+	}
+	l.moduleLevels[pattern] = level
+}
+
+// ClearModuleLevel removes the override of a pattern
+func (l *SimpleLogger) ClearModuleLevel(pattern string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.moduleLevels, pattern)
+}
+
+// ModuleLevels returns a copy of the module level overrides
+func (l *SimpleLogger) ModuleLevels() map[string]LogLevel {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	levels := make(map[string]LogLevel, len(l.moduleLevels))
+	for pattern, level := range l.moduleLevels {
+		levels[pattern] = level
+	}
+	return levels
+}
+
+// levelFor returns the level of a module: its exact override, else the
+// override of the longest matching wildcard, else the logger's level
+func (l *SimpleLogger) levelFor(module string) LogLevel {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if level, ok := l.moduleLevels[module]; ok {
+		return level
+	}
+	level, longest := l.level, -1
+	for pattern, patternLevel := range l.moduleLevels {
+		if len(pattern) > longest && strings.ContainsAny(pattern, "*?[") {
+			if matched, _ := path.Match(pattern, module); matched {
+				level, longest = patternLevel, len(pattern)
+			}
+		}
+	}
+	return level
+}
+
+// SetOutput replaces the sinks with a single writer, keeping the format
+// of the first sink
 func (l *SimpleLogger) SetOutput(writer io.Writer) {
-	l.output = writer
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	format := TextFormat
+	if len(l.sinks) > 0 {
+		format = l.sinks[0].Format
+	}
+	l.sinks = []sink{{Sink: Sink{Writer: writer, Format: format}, color: colorEnabled(writer)}}
+}
+
+// SetFormat sets the format of log lines of every sink
+func (l *SimpleLogger) SetFormat(format LogFormat) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sinks := make([]sink, len(l.sinks))
+	for i, s := range l.sinks {
+		s.Format = format
+		sinks[i] = s
+	}
+	l.sinks = sinks
+}
+
+// SetSinks sets the destinations every log line is written to
+func (l *SimpleLogger) SetSinks(sinks ...Sink) {
+	newSinks := make([]sink, 0, len(sinks))
+	for _, s := range sinks {
+		newSinks = append(newSinks, sink{Sink: s, color: colorEnabled(s.Writer)})
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sinks = newSinks
+}
+
+// AddSink adds a destination for log lines
+func (l *SimpleLogger) AddSink(s Sink) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sinks := make([]sink, len(l.sinks), len(l.sinks)+1)
+	copy(sinks, l.sinks)
+	l.sinks = append(sinks, sink{Sink: s, color: colorEnabled(s.Writer)})
+}
+
+// SetRedactor sets the function that masks secrets in every message and
+// in string and error field values
+func (l *SimpleLogger) SetRedactor(redact func(string) string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.redact = redact
+}
+
+// redactLine masks secrets in a message and its fields
+func (l *SimpleLogger) redactLine(message string, fields Fields) (string, Fields) {
+	l.mu.RLock()
+	redact := l.redact
+	l.mu.RUnlock()
+	if redact == nil {
+		return message, fields
+	}
+
+	var masked Fields
+	if len(fields) > 0 {
+		masked = make(Fields, len(fields))
+		for key, value := range fields {
+			switch v := value.(type) {
+			case string:
+				value = redact(v)
+			case error:
+				value = redact(v.Error())
+			}
+			masked[key] = value
+		}
+	}
+	return redact(message), masked
+}
+
+// currentSinks returns the sinks to write a line to
+func (l *SimpleLogger) currentSinks() []sink {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.sinks
+}
+
+// Close closes the sinks that are files opened for the logger, such as
+// rotating files; stdout and stderr are left open. Lines logged afterwards
+// to a closed file are dropped
+func (l *SimpleLogger) Close() error {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	var firstErr error
+	for _, s := range l.currentSinks() {
+		if s.Writer == os.Stdout || s.Writer == os.Stderr {
+			continue
+		}
+		if closer, ok := s.Writer.(io.Closer); ok {
+			if err := closer.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
 }
 
 // log writes a log message with the specified level
 func (l *SimpleLogger) log(level LogLevel, format string, args ...interface{}) {
-	l.logWithModule(level, "", format, args...)
+	l.logWithModule(level, "", nil, format, args...)
 }
 
-// logWithModule writes a log message with the specified level and module
-func (l *SimpleLogger) logWithModule(level LogLevel, module, format string, args ...interface{}) {
-	if level < l.level {
+// logWithModule writes a log message with the specified level, module and fields
+func (l *SimpleLogger) logWithModule(level LogLevel, module string, fields Fields, format string, args ...interface{}) {
+	if level < l.levelFor(module) {
 		return
 	}
 
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	message := fmt.Sprintf(format, args...)
+	now := time.Now()
+	message, fields := l.redactLine(fmt.Sprintf(format, args...), fields)
 
-	// Color codes for different log levels
-	var colorCode string
-	switch level {
-	case DEBUG:
-		colorCode = "\033[36m" // Cyan
-	case INFO:
-		colorCode = "\033[32m" // Green
-	case WARN:
-		colorCode = "\033[33m" // Yellow
-	case ERROR:
-		colorCode = "\033[31m" // Red
-	case FATAL:
-		colorCode = "\033[35m" // Magenta
+	// Format each kind of line once, however many sinks use it
+	type lineKind struct {
+		format LogFormat
+		color  bool
 	}
+	sinks := l.currentSinks()
+	lines := make(map[lineKind]string, len(sinks))
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	for _, s := range sinks {
+		kind := lineKind{format: s.Format, color: s.color && s.Format == TextFormat}
+		logLine, ok := lines[kind]
+		if !ok {
+			if s.Format == JSONFormat {
+				logLine = jsonLine(now, level, module, message, fields)
+			} else {
+				logLine = textLine(now, level, module, message, fields, kind.color)
+			}
+			lines[kind] = logLine
+		}
+		s.Writer.Write([]byte(logLine))
+	}
+}
 
-	resetCode := "\033[0m"
+// textLine formats a human readable log line, with fields as key=value
+func textLine(now time.Time, level LogLevel, module, message string, fields Fields, color bool) string {
+	timestamp := now.Format("2006-01-02 15:04:05")
+
+	// Color codes for different log levels
+	levelText := level.String()
+	if color {
+		var colorCode string
+		switch level {
+		case DEBUG:
+			colorCode = "\033[36m" // Cyan
+		case INFO:
+			colorCode = "\033[32m" // Green
+		case WARN:
+			colorCode = "\033[33m" // Yellow
+		case ERROR:
+			colorCode = "\033[31m" // Red
+		case FATAL:
+			colorCode = "\033[35m" // Magenta
+		}
+		levelText = colorCode + levelText + "\033[0m"
+	}
 
 	// Format log line with optional module name
-	var logLine string
+	var logLine strings.Builder
+	fmt.Fprintf(&logLine, "[%s] %s", timestamp, levelText)
 	if module != "" {
-		logLine = fmt.Sprintf("[%s] %s%s%s [%s] %s\n",
-			timestamp,
-			colorCode,
-			level.String(),
-			resetCode,
-			module,
-			message)
-	} else {
-		logLine = fmt.Sprintf("[%s] %s%s%s %s\n",
-			timestamp,
-			colorCode,
-			level.String(),
-			resetCode,
-			message)
+		fmt.Fprintf(&logLine, " [%s]", module)
+	}
+	logLine.WriteString(" " + message)
+	for _, key := range sortedKeys(fields) {
+		value := fmt.Sprint(fields[key])
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&logLine, " %s=%s", key, value)
+	}
+	logLine.WriteString("\n")
+	return logLine.String()
+}
+
+// reservedKeys are the keys of a JSON log line that fields can't replace
+var reservedKeys = map[string]bool{"time": true, "level": true, "module": true, "message": true}
+
+// jsonLine formats a log line as a JSON object: time (RFC 3339 with
+// milliseconds), level, module and message, then the fields. Fields named
+// like one of those are prefixed with "fields."
+func jsonLine(now time.Time, level LogLevel, module, message string, fields Fields) string {
+	var line strings.Builder
+	writePair := func(key string, value interface{}) {
+		encodedKey, _ := json.Marshal(key)
+		encodedValue, err := json.Marshal(value)
+		if err != nil {
+			encodedValue, _ = json.Marshal(fmt.Sprint(value))
+		}
+		if line.Len() > 0 {
+			line.WriteByte(',')
+		}
+		line.Write(encodedKey)
+		line.WriteByte(':')
+		line.Write(encodedValue)
 	}
 
-	l.output.Write([]byte(logLine))
+	writePair("time", now.Format("2006-01-02T15:04:05.000Z07:00"))
+	writePair("level", level.String())
+	if module != "" {
+		writePair("module", module)
+	}
+	writePair("message", message)
+	for _, key := range sortedKeys(fields) {
+		value := fields[key]
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		if reservedKeys[key] {
+			key = "fields." + key
+		}
+		writePair(key, value)
+	}
+	return "{" + line.String() + "}\n"
+}
+
+// sortedKeys returns the keys of fields in order
+func sortedKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 // Debug logs a debug message
@@ -222,46 +578,151 @@ func (l *SimpleLogger) Error(format string, args ...interface{}) {
 	l.log(ERROR, format, args...)
 }
 
-// Fatal logs a fatal message and exits
+// Fatal logs a fatal message, then runs the exit hooks, closes the global
+// logger's sinks and exits with status 1 (see Exit)
 func (l *SimpleLogger) Fatal(format string, args ...interface{}) {
 	l.log(FATAL, format, args...)
-	os.Exit(1)
+	Exit(1)
 }
 
 // ParseLogLevel parses a string to LogLevel
 func ParseLogLevel(level string) LogLevel {
+	if parsed, ok := parseLevelName(level); ok {
+		return parsed
+	}
+	return INFO // Default to INFO level
+}
+
+// ParseLogLevelStrict parses a string to LogLevel, rejecting unknown names
+func ParseLogLevelStrict(level string) (LogLevel, error) {
+	if parsed, ok := parseLevelName(level); ok {
+		return parsed, nil
+	}
+	return INFO, fmt.Errorf("invalid log level: %s (use DEBUG, INFO, WARN, ERROR or FATAL)", level)
+}
+
+// parseLevelName parses a level name, reporting whether it is known
+func parseLevelName(level string) (LogLevel, bool) {
 	switch strings.ToUpper(level) {
 	case "DEBUG":
-		return DEBUG
+		return DEBUG, true
 	case "INFO":
-		return INFO
+		return INFO, true
 	case "WARN", "WARNING":
-		return WARN
+		return WARN, true
 	case "ERROR":
-		return ERROR
+		return ERROR, true
 	case "FATAL":
-		return FATAL
+		return FATAL, true
 	default:
-		return INFO // Default to INFO level
+		return INFO, false
+	}
+}
+
+// ParseModuleLevel parses a module level override such as
+// executor.base64=DEBUG or executor.*=WARN
+func ParseModuleLevel(spec string) (string, LogLevel, error) {
+	pattern, levelName, ok := strings.Cut(spec, "=")
+	pattern = strings.TrimSpace(pattern)
+	if !ok || pattern == "" {
+		return "", INFO, fmt.Errorf("invalid module level %q (use module=LEVEL)", spec)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return "", INFO, fmt.Errorf("invalid module pattern %q: %v", pattern, err)
+	}
+	level, err := ParseLogLevelStrict(strings.TrimSpace(levelName))
+	if err != nil {
+		return "", INFO, err
 	}
+	return pattern, level, nil
 }
 
 // Global logger instance
-var globalLogger Logger
+var (
+	globalMu     sync.Mutex
+	globalLogger Logger
+)
+
+// setGlobalLogger replaces the global logger
+func setGlobalLogger(logger Logger) {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	globalLogger = logger
+}
+
+// currentGlobalLogger returns the global logger, or nil if none was created
+func currentGlobalLogger() Logger {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	return globalLogger
+}
 
 // InitGlobalLogger initializes the global logger
 func InitGlobalLogger(level LogLevel) {
-	globalLogger = NewLogger(level)
+	setGlobalLogger(NewLogger(level))
+}
+
+// InitGlobalLoggerWithFormat initializes the global logger with a log format
+func InitGlobalLoggerWithFormat(level LogLevel, format LogFormat) {
+	logger := NewLogger(level).(*SimpleLogger)
+	logger.SetFormat(format)
+	setGlobalLogger(logger)
+}
+
+// InitGlobalLoggerWithSinks initializes the global logger writing to every sink
+func InitGlobalLoggerWithSinks(level LogLevel, sinks []Sink) {
+	logger := &SimpleLogger{level: level}
+	logger.SetSinks(sinks...)
+	setGlobalLogger(logger)
 }
 
 // GetGlobalLogger returns the global logger instance
 func GetGlobalLogger() Logger {
+	globalMu.Lock()
+	defer globalMu.Unlock()
 	if globalLogger == nil {
 		globalLogger = NewLogger(INFO)
 	}
 	return globalLogger
 }
 
+// SetLogRedactor sets the function that masks secrets in the global logger's lines
+func SetLogRedactor(redact func(string) string) {
+	if sl, ok := GetGlobalLogger().(*SimpleLogger); ok {
+		sl.SetRedactor(redact)
+	}
+}
+
+// CloseGlobalLogger closes the global logger's file sinks, waiting for
+// rotated files to be compressed
+func CloseGlobalLogger() {
+	if logger, ok := currentGlobalLogger().(*SimpleLogger); ok {
+		logger.Close()
+	}
+}
+
+// SetModuleLevel overrides the global logger's level for modules matching a pattern
+func SetModuleLevel(pattern string, level LogLevel) {
+	if sl, ok := GetGlobalLogger().(*SimpleLogger); ok {
+		sl.SetModuleLevel(pattern, level)
+	}
+}
+
+// ClearModuleLevel removes a module level override of the global logger
+func ClearModuleLevel(pattern string) {
+	if sl, ok := GetGlobalLogger().(*SimpleLogger); ok {
+		sl.ClearModuleLevel(pattern)
+	}
+}
+
+// LogLevels returns the global logger's level and module level overrides
+func LogLevels() (LogLevel, map[string]LogLevel) {
+	if sl, ok := GetGlobalLogger().(*SimpleLogger); ok {
+		return sl.Level(), sl.ModuleLevels()
+	}
+	return INFO, nil
+}
+
 // Convenience functions for global logger
 func Debug(format string, args ...interface{}) {
 	GetGlobalLogger().Debug(format, args...)
@@ -0,0 +1,325 @@
+package utils
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the rotation time in the name of a rotated file
+const backupTimeFormat = "20060102T150405.000"
+
+// RotateOptions controls when a RotatingFile is rotated and what is kept
+type RotateOptions struct {
+	MaxSize    int64         // Rotate before the file grows past this many bytes (0 = no limit)
+	MaxAge     time.Duration // Rotate once the file is this old, counted from when it was started (0 = no limit)
+	MaxBackups int           // Number of rotated files kept (0 = keep all)
+	Compress   bool          // Gzip rotated files
+}
+
+// IsSet reports whether the file is rotated at all
+func (ro RotateOptions) IsSet() bool {
+	return ro.MaxSize > 0 || ro.MaxAge > 0
+}
+
+// RotatingFile is a log file that is rotated by size and age. A rotated
+// file is renamed with its rotation time, e.g. app.log becomes
+// app-20260115T093012.345.log (.log.gz when compressed). Writes are safe for
+// concurrent use, also by several processes appending to the same path
+type RotatingFile struct {
+	path    string
+	options RotateOptions
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	born time.Time // When the current file was started
+
+	maintainMu  sync.Mutex     // Serializes compression and pruning
+	maintaining sync.WaitGroup // Background compression and pruning
+}
+
+// OpenRotatingFile opens or creates a log file, appending to it
+func OpenRotatingFile(path string, options RotateOptions) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %v", err)
+	}
+	rf := &RotatingFile{path: path, options: options}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// open opens the file at the path. With MaxAge, the time a file with
+// content was started is read from the file next to it, so that processes
+// started later still rotate it in time
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	rf.file, rf.size, rf.born = file, info.Size(), time.Now()
+	if rf.options.MaxAge > 0 {
+		rf.born = rf.started()
+	}
+	return nil
+}
+
+// startedPath returns the file that records when the current file was started
+func (rf *RotatingFile) startedPath() string {
+	return rf.path + ".started"
+}
+
+// started returns when the open file was started. An empty file starts now;
+// without a record, a file with content started at the last rotation, or now
+// if it was never rotated. The result is recorded for later processes
+func (rf *RotatingFile) started() time.Time {
+	if rf.size > 0 {
+		if data, err := os.ReadFile(rf.startedPath()); err == nil {
+			if born, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data))); err == nil {
+				return born
+			}
+		}
+	}
+	born := time.Now()
+	if rf.size > 0 {
+		if backups := rf.backups(); len(backups) > 0 {
+			born = backups[0].rotated
+		}
+	}
+	rf.recordStarted(born)
+	return born
+}
+
+// recordStarted records when the current file was started
+func (rf *RotatingFile) recordStarted(born time.Time) {
+	os.WriteFile(rf.startedPath(), []byte(born.UTC().Format(time.RFC3339Nano)+"\n"), 0600)
+}
+
+// Write appends p to the file, rotating it first if p would make it too
+// large or it is too old
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if err := rf.lockCurrent(); err != nil {
+		return 0, err
+	}
+	if rf.due(len(p), time.Now()) {
+		// rotate closes the locked file, which releases the lock, and may
+		// leave no file open if it fails
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+		if err := rf.lockCurrent(); err != nil {
+			return 0, err
+		}
+	}
+	defer UnlockFile(rf.file)
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// lockCurrent locks the open file, first reopening the path if another
+// process rotated it since
+func (rf *RotatingFile) lockCurrent() error {
+	for {
+		if err := LockFile(rf.file); err != nil {
+			return fmt.Errorf("failed to lock log file: %v", err)
+		}
+		fileInfo, err := rf.file.Stat()
+		pathInfo, pathErr := os.Stat(rf.path)
+		if err == nil && pathErr == nil && os.SameFile(fileInfo, pathInfo) {
+			rf.size = fileInfo.Size()
+			return nil
+		}
+
+		UnlockFile(rf.file)
+		rf.file.Close()
+		rf.file = nil
+		if err := rf.open(); err != nil {
+			return err
+		}
+	}
+}
+
+// due reports whether the file must be rotated before writing n bytes
+func (rf *RotatingFile) due(n int, now time.Time) bool {
+	if rf.size == 0 {
+		return false
+	}
+	if rf.options.MaxSize > 0 && rf.size+int64(n) > rf.options.MaxSize {
+		return true
+	}
+	return rf.options.MaxAge > 0 && now.Sub(rf.born) >= rf.options.MaxAge
+}
+
+// rotate renames the locked file to a backup, starts a new file and
+// compresses and prunes the backups in the background
+func (rf *RotatingFile) rotate() error {
+	backup := rf.backupPath(time.Now())
+	rf.file.Close()
+	rf.file = nil
+	if err := os.Rename(rf.path, backup); err != nil {
+		if openErr := rf.open(); openErr != nil {
+			return fmt.Errorf("failed to rotate log file: %v (reopening it: %v)", err, openErr)
+		}
+		return fmt.Errorf("failed to rotate log file: %v", err)
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+
+	rf.maintaining.Add(1)
+	go func() {
+		defer rf.maintaining.Done()
+		rf.maintain()
+	}()
+	return nil
+}
+
+// backupPath returns the name of a file rotated at the given time. The name
+// has millisecond precision, so if a backup (or its compressed copy) of that
+// millisecond exists, the next free millisecond is used instead of
+// overwriting it
+func (rf *RotatingFile) backupPath(rotated time.Time) string {
+	ext := filepath.Ext(rf.path)
+	base := strings.TrimSuffix(rf.path, ext)
+	for {
+		path := base + "-" + rotated.UTC().Format(backupTimeFormat) + ext
+		if !exists(path) && !exists(path+".gz") {
+			return path
+		}
+		rotated = rotated.Add(time.Millisecond)
+	}
+}
+
+// exists reports whether a file exists at the path
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return !os.IsNotExist(err)
+}
+
+// backup is a rotated file
+type backup struct {
+	path       string
+	rotated    time.Time
+	compressed bool
+}
+
+// backups returns the rotated files of the path, newest first
+func (rf *RotatingFile) backups() []backup {
+	ext := filepath.Ext(rf.path)
+	prefix := strings.TrimSuffix(filepath.Base(rf.path), ext) + "-"
+	entries, err := os.ReadDir(filepath.Dir(rf.path))
+	if err != nil {
+		return nil
+	}
+
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		compressed := strings.HasSuffix(name, ext+".gz")
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ext)
+		if !strings.HasPrefix(name, prefix) || !compressed && !strings.HasSuffix(name, ext) {
+			continue
+		}
+		rotated, err := time.Parse(backupTimeFormat, strings.TrimPrefix(stamp, prefix))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{
+			path:       filepath.Join(filepath.Dir(rf.path), name),
+			rotated:    rotated,
+			compressed: compressed,
+		})
+	}
+	sort.Slice(backups, func(a, b int) bool {
+		return backups[a].rotated.After(backups[b].rotated)
+	})
+	return backups
+}
+
+// maintain removes the backups beyond MaxBackups and compresses the rest.
+// Backups left uncompressed by an earlier process are compressed too
+func (rf *RotatingFile) maintain() {
+	rf.maintainMu.Lock()
+	defer rf.maintainMu.Unlock()
+
+	for i, backup := range rf.backups() {
+		if rf.options.MaxBackups > 0 && i >= rf.options.MaxBackups {
+			os.Remove(backup.path)
+			continue
+		}
+		if rf.options.Compress && !backup.compressed {
+			if err := compressFile(backup.path); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to compress %s: %v\n", backup.path, err)
+			}
+		}
+	}
+}
+
+// compressFile gzips a rotated file to path.gz and removes it. The lock
+// waits for a process still finishing a write to it
+func compressFile(path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	if err := LockFile(source); err != nil {
+		return err
+	}
+	defer UnlockFile(source)
+
+	target, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(target)
+	if _, err := io.Copy(writer, source); err != nil {
+		target.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		target.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := target.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Close waits for background compression and closes the file
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.maintaining.Wait()
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotateKeepsBackupsOfTheSameMillisecond(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	rf, err := OpenRotatingFile(path, RotateOptions{MaxSize: 4})
+	if err != nil {
+		t.Fatalf("OpenRotatingFile: %v", err)
+	}
+	lines := []string{"one\n", "two\n", "six\n", "ten\n"}
+	for _, line := range lines {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	backups := rf.backups()
+	if len(backups) != len(lines)-1 {
+		t.Fatalf("%d backups, want %d", len(backups), len(lines)-1)
+	}
+	// Oldest backup first, then the current file
+	var got []string
+	for i := len(backups) - 1; i >= 0; i-- {
+		data, err := os.ReadFile(backups[i].path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(data))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, string(data))
+	if strings.Join(got, "") != strings.Join(lines, "") {
+		t.Errorf("logs = %q, want %q", got, lines)
+	}
+}
+
+func TestBackupPathSkipsExistingNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	rf := &RotatingFile{path: path}
+	now := time.Date(2026, 1, 15, 9, 30, 12, 345000000, time.UTC)
+
+	first := rf.backupPath(now)
+	if err := os.WriteFile(first, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	second := rf.backupPath(now)
+	if err := os.WriteFile(second+".gz", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	third := rf.backupPath(now)
+
+	want := []string{"app-20260115T093012.345.log", "app-20260115T093012.346.log", "app-20260115T093012.347.log"}
+	for i, got := range []string{first, second, third} {
+		if filepath.Base(got) != want[i] {
+			t.Errorf("backupPath #%d = %s, want %s", i+1, filepath.Base(got), want[i])
+		}
+	}
+}
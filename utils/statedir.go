@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// StateDirEnv overrides the directory where persistent state is stored
+const StateDirEnv = "EXECUTE_COMMAND_STATE_DIR"
+
+// StateDir returns the directory for persistent state (audit log, jobs, history),
+// creating it if needed. It is $EXECUTE_COMMAND_STATE_DIR if set, otherwise
+// $XDG_STATE_HOME/execute_command (~/.local/state/execute_command) on Unix and
+// the user config directory on Windows and macOS
+func StateDir() (string, error) {
+	dir := os.Getenv(StateDirEnv)
+	if dir == "" {
+		base, err := stateBaseDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(base, "execute_command")
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// stateBaseDir returns the platform base directory for state files
+func stateBaseDir() (string, error) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return os.UserConfigDir()
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state"), nil
+}